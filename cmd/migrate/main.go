@@ -32,15 +32,46 @@ func main() {
 		&model.Chat{},
 		&model.ChatUser{},
 		&model.Message{},
+		&model.ChatRead{},
+		&model.MessageEdit{},
+		&model.MessageReaction{},
+		&model.ChatInvite{},
+		&model.ChatBan{},
+		&model.RefreshToken{},
+		&model.PasswordResetToken{},
+		&model.UserTOTP{},
+		&model.RecoveryCode{},
+		&model.UserBlock{},
+		&model.DataExport{},
+		&model.UserIdentity{},
 	); err != nil {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
+
+	// Full-text search column and index, see migrations/010_add_message_search.sql
+	if err := db.Exec(`ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (to_tsvector('english', text)) STORED`).Error; err != nil {
+		log.Fatalf("Failed to add search column: %v", err)
+	}
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_search_vector ON messages USING GIN(search_vector)").Error; err != nil {
+		log.Fatalf("Failed to add search index: %v", err)
+	}
+
+	// Prefix indexes for the user directory, see migrations/018_add_user_profiles.sql
+	for _, column := range []string{"username", "display_name"} {
+		stmt := fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_users_%[1]s_prefix ON users (lower(%[1]s) text_pattern_ops)", column)
+		if err := db.Exec(stmt).Error; err != nil {
+			log.Fatalf("Failed to add user %s index: %v", column, err)
+		}
+	}
 	log.Printf("Migrations completed")
 
 	// Create admin user if it doesn't exist
 	admin := &model.User{
 		Username: "admin",
 		Password: "$2a$10$X7J3Y5Z8A9B0C1D2E3F4G5H6I7J8K9L0M1N2O3P4Q5R6S7T8U9V0W1X2Y3Z4", // "admin123"
+		// There is no address to verify
+		EmailVerified: true,
 	}
 	if err := db.FirstOrCreate(admin, model.User{Username: "admin"}).Error; err != nil {
 		log.Fatalf("Failed to create admin user: %v", err)
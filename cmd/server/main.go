@@ -5,14 +5,19 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"rtcs/internal/cache"
 	"rtcs/internal/config"
+	"rtcs/internal/fanout"
 	"rtcs/internal/middleware"
+	"rtcs/internal/notify"
+	"rtcs/internal/oidc"
 	"rtcs/internal/repository"
 	"rtcs/internal/service"
 	"rtcs/internal/transport"
+	"strings"
 	"syscall"
 	"time"
 
@@ -34,6 +39,13 @@ func main() {
 	cfg := config.Get()
 	log.Printf("Configuration loaded")
 
+	// Load the JWT signing keys up front so a bad key fails the start
+	keySet, err := middleware.LoadKeySet(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+	middleware.SetKeySet(keySet)
+
 	// Connect to PostgreSQL
 	db, err := connectDB(cfg.DatabaseURL)
 	if err != nil {
@@ -52,31 +64,123 @@ func main() {
 	userRepo := repository.NewUserRepository(db)
 	messageRepo := repository.NewMessageRepository(db)
 	chatRepo := repository.NewChatRepository(db)
+	searchRepo := repository.NewMessageSearchRepository(db)
+	tokenRepo := repository.NewTokenRepository(db)
+	mfaRepo := repository.NewMFARepository(db)
+	accountRepo := repository.NewAccountRepository(db)
 	log.Printf("Repositories initialized")
 
-	// Connect to Redis
-	rdb := redis.NewClient(&redis.Options{
-		Addr: cfg.RedisURL,
-	})
+	// Connect to Redis (REDIS_URL may be a redis:// URL or a plain host:port)
+	redisOpts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		redisOpts = &redis.Options{Addr: cfg.RedisURL}
+	}
+	rdb := redis.NewClient(redisOpts)
 	messageCache := cache.NewMessageCache(rdb)
+	tokenDenylist := cache.NewTokenDenylist(rdb)
+	middleware.SetRevocationChecker(tokenDenylist)
 	log.Printf("Connected to Redis")
 
 	// Initialize services
-	authService := service.NewAuthService(userRepo)
+	authService := service.NewAuthService(userRepo, tokenRepo, tokenDenylist)
+	loginLimiter := cache.NewLoginLimiter(rdb, cache.DefaultUsernamePolicy, cache.DefaultIPPolicy)
+	authService.SetLoginLimiter(loginLimiter)
+	authService.SetMFARepository(mfaRepo)
+	var notifier notify.Notifier = notify.New(cfg.NotifyOutbox)
+	if cfg.SMTPHost != "" {
+		notifier, err = notify.NewSMTPMailer(notify.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+		})
+		if err != nil {
+			log.Fatalf("Invalid SMTP settings: %v", err)
+		}
+	}
+	authService.SetNotifier(notifier)
+	authService.SetPublicURL(cfg.PublicURL)
+	authService.SetPasswordPolicy(service.PasswordPolicy{
+		MinLength:     cfg.PasswordMinLength,
+		RequireDigit:  cfg.PasswordRequireDigit,
+		RequireSymbol: cfg.PasswordRequireSymbol,
+	})
+	unverifiedPolicy, err := service.ParseUnverifiedPolicy(cfg.UnverifiedRestrictions)
+	if err != nil {
+		log.Fatalf("Invalid UNVERIFIED_RESTRICTIONS: %v", err)
+	}
+	authService.SetUnverifiedPolicy(unverifiedPolicy)
+	if len(cfg.OIDCProviders) > 0 {
+		authService.SetOIDC(repository.NewIdentityRepository(db), cache.NewOIDCStateStore(rdb))
+		for _, p := range cfg.OIDCProviders {
+			if p.Issuer == "" || p.ClientID == "" {
+				log.Fatalf("Invalid OIDC provider %q: issuer and client ID are required", p.Name)
+			}
+			authService.AddOIDCProvider(oidc.NewProvider(oidc.Config{
+				Name:         p.Name,
+				Issuer:       p.Issuer,
+				ClientID:     p.ClientID,
+				ClientSecret: p.ClientSecret,
+				RedirectURL:  strings.TrimRight(cfg.PublicURL, "/") + "/auth/oidc/" + url.PathEscape(p.Name) + "/callback",
+				Scopes:       p.Scopes,
+			}, nil))
+		}
+		log.Printf("OIDC login enabled for %d providers", len(cfg.OIDCProviders))
+	}
 	messageService := service.NewMessageService(messageRepo, messageCache)
 	chatService := service.NewChatService(chatRepo)
+	searchService := service.NewSearchService(searchRepo)
+	userService := service.NewUserService(userRepo)
+	userService.SetUnverifiedPolicy(unverifiedPolicy)
+	messageService.SetProfileLookup(userService)
+	chatService.SetProfileLookup(userService)
+	messageService.SetBlockList(userService)
+	chatService.SetBlockList(userService)
+	messageService.SetVerificationGate(userService)
+	chatService.SetVerificationGate(userService)
+	accountService := service.NewAccountService(userRepo, accountRepo, messageCache, tokenDenylist)
+	accountService.SetLoginLimiter(loginLimiter)
+	messagePolicy := service.MessagePolicy(cfg.DeletedUserMessages)
+	if !messagePolicy.Valid() {
+		log.Fatalf("Invalid DELETED_USER_MESSAGES %q: must be anonymize or delete", cfg.DeletedUserMessages)
+	}
+	accountService.SetMessagePolicy(messagePolicy)
 	log.Printf("Services initialized")
 
 	// Initialize handlers
 	authHandler := transport.NewAuthHandler(authService)
 	messageHandler := transport.NewMessageHandler(messageService)
 	chatHandler := transport.NewChatHandler(chatService)
+	searchHandler := transport.NewSearchHandler(searchService)
+	userHandler := transport.NewUserHandler(userService)
+	accountHandler := transport.NewAccountHandler(accountService)
 
 	// Create router
 	router := mux.NewRouter()
 
+	// WebSocket hub fanout: Redis pub/sub lets replicas share rooms and presence
+	var hubFanout fanout.Fanout
+	switch cfg.WSFanout {
+	case "memory":
+		hubFanout = fanout.NewMemoryFanout()
+	default:
+		hubFanout = fanout.NewRedisFanout(rdb)
+	}
+	defer hubFanout.Close()
+	log.Printf("WebSocket fanout: %s", cfg.WSFanout)
+
 	// WebSocket endpoint (register before middleware)
-	wsHandler := transport.NewWebSocketHandler()
+	wsHandler, err := transport.NewWebSocketHandler(chatService, messageService, hubFanout)
+	if err != nil {
+		log.Fatalf("Failed to start WebSocket hub: %v", err)
+	}
+	chatService.SetMembershipListener(wsHandler)
+	chatService.SetReadListener(wsHandler)
+	accountService.SetMembershipListener(wsHandler)
+	messageService.SetMessageListener(wsHandler)
+	wsHandler.SetBlockList(userService)
+	userService.SetBlockListener(wsHandler)
 	router.HandleFunc("/ws", wsHandler.HandleWebSocket)
 	log.Printf("WebSocket endpoint added")
 
@@ -92,10 +196,26 @@ func main() {
 	// Metrics endpoint
 	router.Handle("/metrics", promhttp.Handler())
 
+	// Public keys for verifying our tokens
+	router.HandleFunc("/.well-known/jwks.json", transport.JWKS).Methods("GET")
+
 	// Auth routes
 	authRouter := router.PathPrefix("/auth").Subrouter()
 	authRouter.HandleFunc("/register", authHandler.Register).Methods("POST")
 	authRouter.HandleFunc("/login", authHandler.Login).Methods("POST")
+	authRouter.HandleFunc("/login/mfa", authHandler.LoginMFA).Methods("POST")
+	authRouter.HandleFunc("/refresh", authHandler.Refresh).Methods("POST")
+	authRouter.Handle("/logout", middleware.Auth(http.HandlerFunc(authHandler.Logout))).Methods("POST")
+	authRouter.Handle("/password", middleware.Auth(http.HandlerFunc(authHandler.ChangePassword))).Methods("POST")
+	authRouter.HandleFunc("/password/reset", authHandler.RequestPasswordReset).Methods("POST")
+	authRouter.HandleFunc("/password/reset/confirm", authHandler.ConfirmPasswordReset).Methods("POST")
+	authRouter.HandleFunc("/verify-email", authHandler.VerifyEmail).Methods("GET")
+	authRouter.HandleFunc("/verify-email/resend", authHandler.RequestEmailVerification).Methods("POST")
+	authRouter.Handle("/2fa/enroll", middleware.Auth(http.HandlerFunc(authHandler.EnrollTOTP))).Methods("POST")
+	authRouter.Handle("/2fa/confirm", middleware.Auth(http.HandlerFunc(authHandler.ConfirmTOTP))).Methods("POST")
+	authRouter.Handle("/2fa/disable", middleware.Auth(http.HandlerFunc(authHandler.DisableTOTP))).Methods("POST")
+	authRouter.HandleFunc("/oidc/{provider}/start", authHandler.StartOIDCLogin).Methods("GET")
+	authRouter.HandleFunc("/oidc/{provider}/callback", authHandler.OIDCCallback).Methods("GET")
 
 	// Chat routes (protected)
 	chatRouter := router.PathPrefix("/chats").Subrouter()
@@ -103,16 +223,59 @@ func main() {
 	chatRouter.HandleFunc("", chatHandler.CreateChat).Methods("POST")
 	chatRouter.HandleFunc("", chatHandler.ListChats).Methods("GET")
 	chatRouter.HandleFunc("/{chatId}", chatHandler.GetChat).Methods("GET")
+	chatRouter.HandleFunc("/{chatId}", chatHandler.RenameChat).Methods("PATCH")
 	chatRouter.HandleFunc("/{chatId}/join", chatHandler.JoinChat).Methods("POST")
 	chatRouter.HandleFunc("/{chatId}/leave", chatHandler.LeaveChat).Methods("POST")
+	chatRouter.HandleFunc("/{chatId}/transfer", chatHandler.TransferOwnership).Methods("POST")
+	chatRouter.HandleFunc("/{chatId}/members", chatHandler.ListMembers).Methods("GET")
+	chatRouter.HandleFunc("/{chatId}/members/{userId}/kick", chatHandler.KickMember).Methods("POST")
+	chatRouter.HandleFunc("/{chatId}/members/{userId}/ban", chatHandler.BanMember).Methods("POST")
+	chatRouter.HandleFunc("/{chatId}/members/{userId}/ban", chatHandler.UnbanMember).Methods("DELETE")
+	chatRouter.HandleFunc("/{chatId}/members/{userId}/role", chatHandler.SetMemberRole).Methods("PUT")
+	chatRouter.HandleFunc("/{chatId}/invites", chatHandler.CreateInvite).Methods("POST")
+	chatRouter.HandleFunc("/{chatId}/invites", chatHandler.ListInvites).Methods("GET")
+	chatRouter.HandleFunc("/{chatId}/invites/{inviteId}", chatHandler.RevokeInvite).Methods("DELETE")
+
+	// User routes (protected)
+	userRouter := router.PathPrefix("/users").Subrouter()
+	userRouter.Use(middleware.Auth)
+	userRouter.HandleFunc("", userHandler.SearchUsers).Methods("GET")
+	userRouter.HandleFunc("/me", userHandler.GetMe).Methods("GET")
+	userRouter.HandleFunc("/me", userHandler.UpdateMe).Methods("PATCH")
+	userRouter.HandleFunc("/me", accountHandler.DeleteMe).Methods("DELETE")
+	userRouter.HandleFunc("/me/export", accountHandler.RequestExport).Methods("POST")
+	userRouter.HandleFunc("/me/export", accountHandler.GetExport).Methods("GET")
+	userRouter.HandleFunc("/me/blocks", userHandler.ListBlocks).Methods("GET")
+	userRouter.HandleFunc("/{userId}", userHandler.GetUser).Methods("GET")
+	userRouter.HandleFunc("/{userId}/block", userHandler.BlockUser).Methods("POST")
+	userRouter.HandleFunc("/{userId}/block", userHandler.UnblockUser).Methods("DELETE")
+
+	// Direct message routes
+	dmRouter := router.PathPrefix("/dms").Subrouter()
+	dmRouter.Use(middleware.Auth)
+	dmRouter.HandleFunc("/{userId}", chatHandler.OpenDirectChat).Methods("POST")
+
+	// Invite routes
+	inviteRouter := router.PathPrefix("/invites").Subrouter()
+	inviteRouter.Use(middleware.Auth)
+	inviteRouter.HandleFunc("/{token}/accept", chatHandler.AcceptInvite).Methods("POST")
 
 	// Message routes (protected)
 	messageRouter := router.PathPrefix("/messages").Subrouter()
 	messageRouter.Use(middleware.Auth)
 	messageRouter.HandleFunc("", messageHandler.Send).Methods("POST")
+	messageRouter.HandleFunc("/{messageId}", messageHandler.EditMessage).Methods("PATCH")
+	messageRouter.HandleFunc("/{messageId}/thread", messageHandler.GetThread).Methods("GET")
+	messageRouter.HandleFunc("/{messageId}/reactions/{emoji}", messageHandler.AddReaction).Methods("POST")
+	messageRouter.HandleFunc("/{messageId}/reactions/{emoji}", messageHandler.RemoveReaction).Methods("DELETE")
 	messageRouter.HandleFunc("/{messageId}", messageHandler.DeleteMessage).Methods("DELETE")
 	messageRouter.HandleFunc("/chat/{chatId}", messageHandler.GetChatHistory).Methods("GET")
 
+	// Search routes (protected)
+	searchRouter := router.PathPrefix("/search").Subrouter()
+	searchRouter.Use(middleware.Auth)
+	searchRouter.HandleFunc("/messages", searchHandler.SearchMessages).Methods("GET")
+
 	// Serve static files from the public directory (must be last)
 	staticRouter := router.PathPrefix("/").Subrouter()
 	staticRouter.PathPrefix("/").Handler(http.FileServer(http.Dir("public")))
@@ -136,6 +299,11 @@ func main() {
 	}
 	log.Printf("======================")
 
+	// Build data exports in the background until shutdown
+	exportCtx, stopExports := context.WithCancel(context.Background())
+	defer stopExports()
+	go accountService.RunExports(exportCtx)
+
 	// Create server
 	srv := &http.Server{
 		Addr:    ":8080",
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// LoginPolicy decides how long logins are refused after failed attempts
+type LoginPolicy struct {
+	FreeAttempts     int           // Failures allowed before any delay
+	LockoutThreshold int           // Failures after which logins are locked for LockoutDuration
+	BaseDelay        time.Duration // Delay after the first failure past FreeAttempts, doubling with each further one
+	LockoutDuration  time.Duration // Longest delay, applied from LockoutThreshold on
+	Window           time.Duration // Failures are forgotten this long after the last one
+}
+
+// LockFor returns how long logins are refused after the given number of
+// consecutive failures
+func (p LoginPolicy) LockFor(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	if failures >= p.LockoutThreshold {
+		return p.LockoutDuration
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.LockoutDuration; i++ {
+		delay *= 2
+	}
+	if delay > p.LockoutDuration {
+		delay = p.LockoutDuration
+	}
+	return delay
+}
+
+var (
+	// DefaultUsernamePolicy protects single accounts
+	DefaultUsernamePolicy = LoginPolicy{
+		FreeAttempts:     3,
+		LockoutThreshold: 10,
+		BaseDelay:        time.Second,
+		LockoutDuration:  15 * time.Minute,
+		Window:           time.Hour,
+	}
+
+	// DefaultIPPolicy is looser since many users may share an address
+	DefaultIPPolicy = LoginPolicy{
+		FreeAttempts:     20,
+		LockoutThreshold: 100,
+		BaseDelay:        time.Second,
+		LockoutDuration:  15 * time.Minute,
+		Window:           time.Hour,
+	}
+)
+
+// LoginLimiter counts failed logins per username and per client IP in Redis,
+// so lockouts survive restarts and apply on every replica
+type LoginLimiter struct {
+	client *redis.Client
+	scopes map[string]LoginPolicy
+}
+
+func NewLoginLimiter(client *redis.Client, usernamePolicy, ipPolicy LoginPolicy) *LoginLimiter {
+	return &LoginLimiter{
+		client: client,
+		scopes: map[string]LoginPolicy{"username": usernamePolicy, "ip": ipPolicy},
+	}
+}
+
+// Check returns how much longer logins for the username or from the IP are
+// locked, and which of the two is locked the longest. A zero duration means
+// the attempt may go ahead.
+func (l *LoginLimiter) Check(ctx context.Context, username, ip string) (time.Duration, string, error) {
+	var retryAfter time.Duration
+	var blockedScope string
+	for scope, value := range loginTargets(username, ip) {
+		ttl, err := l.client.PTTL(ctx, loginKey("lock", scope, value)).Result()
+		if err != nil {
+			return 0, "", err
+		}
+		// PTTL is negative when the key does not exist
+		if ttl > retryAfter {
+			retryAfter, blockedScope = ttl, scope
+		}
+	}
+	return retryAfter, blockedScope, nil
+}
+
+// RecordFailure counts a failed attempt and locks the username and IP for as
+// long as their policies require
+func (l *LoginLimiter) RecordFailure(ctx context.Context, username, ip string) error {
+	for scope, value := range loginTargets(username, ip) {
+		policy := l.scopes[scope]
+		failKey := loginKey("fail", scope, value)
+
+		pipe := l.client.TxPipeline()
+		incr := pipe.Incr(ctx, failKey)
+		pipe.Expire(ctx, failKey, policy.Window)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+
+		if lock := policy.LockFor(int(incr.Val())); lock > 0 {
+			if err := l.client.Set(ctx, loginKey("lock", scope, value), 1, lock).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RecordSuccess clears the username's failures. The IP's are kept so that one
+// valid account cannot be used to reset the counter while guessing others.
+func (l *LoginLimiter) RecordSuccess(ctx context.Context, username, ip string) error {
+	return l.client.Del(ctx, loginKey("fail", "username", username), loginKey("lock", "username", username)).Err()
+}
+
+// loginTargets maps each scope to the value counted in it; an unknown IP is
+// not counted, as it would lump unrelated clients together
+func loginTargets(username, ip string) map[string]string {
+	targets := map[string]string{"username": username}
+	if ip != "" {
+		targets["ip"] = ip
+	}
+	return targets
+}
+
+func loginKey(kind, scope, value string) string {
+	return fmt.Sprintf("login_%s:%s:%s", kind, scope, value)
+}
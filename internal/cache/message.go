@@ -47,24 +47,37 @@ func (c *MessageCache) DeleteMessage(ctx context.Context, messageID string) erro
 	return c.client.Del(ctx, key).Err()
 }
 
-func (c *MessageCache) SetChatMessages(ctx context.Context, chatID string, messages []*model.Message) error {
-	key := fmt.Sprintf("chat:%s:messages", chatID)
-	data, err := json.Marshal(messages)
+// History pages of a chat live in one hash, one field per page, so a single
+// delete drops every page of the chat
+func chatPagesKey(chatID string) string {
+	return fmt.Sprintf("chat:%s:history", chatID)
+}
+
+func (c *MessageCache) SetChatPage(ctx context.Context, chatID, pageKey string, page *model.MessagePage) error {
+	key := chatPagesKey(chatID)
+	data, err := json.Marshal(page)
 	if err != nil {
 		return err
 	}
-	return c.client.Set(ctx, key, data, 1*time.Hour).Err()
+	pipe := c.client.TxPipeline()
+	pipe.HSet(ctx, key, pageKey, data)
+	pipe.Expire(ctx, key, 1*time.Hour)
+	_, err = pipe.Exec(ctx)
+	return err
 }
 
-func (c *MessageCache) GetChatMessages(ctx context.Context, chatID string) ([]*model.Message, error) {
-	key := fmt.Sprintf("chat:%s:messages", chatID)
-	data, err := c.client.Get(ctx, key).Bytes()
+func (c *MessageCache) GetChatPage(ctx context.Context, chatID, pageKey string) (*model.MessagePage, error) {
+	data, err := c.client.HGet(ctx, chatPagesKey(chatID), pageKey).Bytes()
 	if err != nil {
 		return nil, err
 	}
-	var messages []*model.Message
-	if err := json.Unmarshal(data, &messages); err != nil {
+	var page model.MessagePage
+	if err := json.Unmarshal(data, &page); err != nil {
 		return nil, err
 	}
-	return messages, nil
+	return &page, nil
+}
+
+func (c *MessageCache) DeleteChatPages(ctx context.Context, chatID string) error {
+	return c.client.Del(ctx, chatPagesKey(chatID)).Err()
 }
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"rtcs/internal/oidc"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// OIDCStateStore keeps OpenID Connect logins in flight in Redis, by state,
+// so the callback may land on any replica
+type OIDCStateStore struct {
+	client *redis.Client
+}
+
+func NewOIDCStateStore(client *redis.Client) *OIDCStateStore {
+	return &OIDCStateStore{client: client}
+}
+
+// Save keeps req for ttl
+func (s *OIDCStateStore) Save(ctx context.Context, req *oidc.AuthRequest, ttl time.Duration) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("oidc_state:%s", req.State)
+	return s.client.Set(ctx, key, data, ttl).Err()
+}
+
+// Take removes and returns the login with the given state, or nil if there
+// is none, so each state is accepted once
+func (s *OIDCStateStore) Take(ctx context.Context, state string) (*oidc.AuthRequest, error) {
+	key := fmt.Sprintf("oidc_state:%s", state)
+	data, err := s.client.GetDel(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var req oidc.AuthRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
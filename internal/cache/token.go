@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TokenDenylist records revoked access token IDs (jti) in Redis until the
+// tokens would have expired anyway, so every replica refuses them
+type TokenDenylist struct {
+	client *redis.Client
+}
+
+func NewTokenDenylist(client *redis.Client) *TokenDenylist {
+	return &TokenDenylist{client: client}
+}
+
+// Revoke denies a token ID until expiresAt
+func (d *TokenDenylist) Revoke(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	key := fmt.Sprintf("revoked_token:%s", tokenID)
+	return d.client.Set(ctx, key, 1, ttl).Err()
+}
+
+func (d *TokenDenylist) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	key := fmt.Sprintf("revoked_token:%s", tokenID)
+	n, err := d.client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,45 @@
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Event kinds understood by the WebSocket hub
+const (
+	KindBroadcast = "broadcast" // Deliver Data to every connected client
+	KindChat      = "chat"      // Deliver Data to subscribers of ChatID
+	KindJoin      = "join"      // Subscribe UserID's connections to ChatID
+	KindLeave     = "leave"     // Unsubscribe UserID's connections from ChatID
+	KindBlock     = "block"     // Stop delivering TargetID's frames to UserID's connections
+	KindUnblock   = "unblock"   // Resume delivering TargetID's frames to UserID's connections
+)
+
+// Event is a hub event shared between server nodes. For KindBroadcast and
+// KindChat events UserID is the author of the frame, if it has one, so that
+// nodes can hold it back from users who blocked them.
+type Event struct {
+	Kind     string          `json:"kind"`
+	ChatID   string          `json:"chat_id,omitempty"`
+	UserID   string          `json:"user_id,omitempty"`
+	TargetID string          `json:"target_id,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// Handler receives events published by any node, including this one
+type Handler func(event Event)
+
+// Fanout distributes hub events and presence between server nodes. Each node
+// publishes every event and delivers what it receives to its local clients.
+type Fanout interface {
+	Publish(ctx context.Context, event Event) error
+	Subscribe(ctx context.Context, handler Handler) error
+
+	// Presence is counted per connection, so a user stays online until
+	// their last connection on any node goes away
+	UserOnline(ctx context.Context, userID string) error
+	UserOffline(ctx context.Context, userID string) error
+	OnlineUsers(ctx context.Context) ([]string, error)
+
+	Close() error
+}
@@ -0,0 +1,71 @@
+package fanout
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryFanout delivers events within a single process. Several hubs sharing
+// one MemoryFanout behave like nodes of a cluster.
+type MemoryFanout struct {
+	mu       sync.RWMutex
+	handlers []Handler
+	presence map[string]int
+}
+
+// NewMemoryFanout creates an in-process fanout backend
+func NewMemoryFanout() *MemoryFanout {
+	return &MemoryFanout{
+		presence: make(map[string]int),
+	}
+}
+
+func (f *MemoryFanout) Publish(ctx context.Context, event Event) error {
+	f.mu.RLock()
+	handlers := make([]Handler, len(f.handlers))
+	copy(handlers, f.handlers)
+	f.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+	return nil
+}
+
+func (f *MemoryFanout) Subscribe(ctx context.Context, handler Handler) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers = append(f.handlers, handler)
+	return nil
+}
+
+func (f *MemoryFanout) UserOnline(ctx context.Context, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.presence[userID]++
+	return nil
+}
+
+func (f *MemoryFanout) UserOffline(ctx context.Context, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.presence[userID]--
+	if f.presence[userID] <= 0 {
+		delete(f.presence, userID)
+	}
+	return nil
+}
+
+func (f *MemoryFanout) OnlineUsers(ctx context.Context) ([]string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	users := make([]string, 0, len(f.presence))
+	for userID := range f.presence {
+		users = append(users, userID)
+	}
+	return users, nil
+}
+
+func (f *MemoryFanout) Close() error {
+	return nil
+}
@@ -0,0 +1,155 @@
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	eventsChannel   = "rtcs:ws:events"
+	presencePrefix  = "rtcs:ws:presence:"
+	presenceTTL     = 90 * time.Second
+	presenceRefresh = 30 * time.Second
+)
+
+// RedisFanout shares events between nodes over Redis pub/sub. Presence is kept
+// in one hash per node (user ID -> connection count) that expires unless the
+// node keeps refreshing it, so a crashed node's users eventually go offline.
+type RedisFanout struct {
+	client      *redis.Client
+	nodeID      string
+	presenceKey string
+
+	mu     sync.Mutex
+	pubsub *redis.PubSub
+	done   chan struct{}
+}
+
+// NewRedisFanout creates a Redis-backed fanout for this node
+func NewRedisFanout(client *redis.Client) *RedisFanout {
+	nodeID := uuid.New().String()
+	f := &RedisFanout{
+		client:      client,
+		nodeID:      nodeID,
+		presenceKey: presencePrefix + nodeID,
+		done:        make(chan struct{}),
+	}
+
+	go f.refreshPresence()
+
+	return f
+}
+
+func (f *RedisFanout) Publish(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return f.client.Publish(ctx, eventsChannel, data).Err()
+}
+
+func (f *RedisFanout) Subscribe(ctx context.Context, handler Handler) error {
+	pubsub := f.client.Subscribe(ctx, eventsChannel)
+	// Wait for the subscription to be confirmed so no events are missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("failed to subscribe to %s: %w", eventsChannel, err)
+	}
+
+	f.mu.Lock()
+	f.pubsub = pubsub
+	f.mu.Unlock()
+
+	go func() {
+		for msg := range pubsub.Channel() {
+			var event Event
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				log.Printf("Error unmarshaling fanout event: %v", err)
+				continue
+			}
+			handler(event)
+		}
+	}()
+
+	return nil
+}
+
+func (f *RedisFanout) UserOnline(ctx context.Context, userID string) error {
+	pipe := f.client.TxPipeline()
+	pipe.HIncrBy(ctx, f.presenceKey, userID, 1)
+	pipe.Expire(ctx, f.presenceKey, presenceTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (f *RedisFanout) UserOffline(ctx context.Context, userID string) error {
+	count, err := f.client.HIncrBy(ctx, f.presenceKey, userID, -1).Result()
+	if err != nil {
+		return err
+	}
+	if count <= 0 {
+		return f.client.HDel(ctx, f.presenceKey, userID).Err()
+	}
+	return nil
+}
+
+func (f *RedisFanout) OnlineUsers(ctx context.Context) ([]string, error) {
+	seen := make(map[string]bool)
+	iter := f.client.Scan(ctx, 0, presencePrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		users, err := f.client.HKeys(ctx, iter.Val()).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, userID := range users {
+			seen[userID] = true
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	users := make([]string, 0, len(seen))
+	for userID := range seen {
+		users = append(users, userID)
+	}
+	return users, nil
+}
+
+func (f *RedisFanout) Close() error {
+	close(f.done)
+
+	f.mu.Lock()
+	pubsub := f.pubsub
+	f.mu.Unlock()
+	if pubsub != nil {
+		pubsub.Close()
+	}
+
+	// Our connections are gone, so take our users offline right away
+	return f.client.Del(context.Background(), f.presenceKey).Err()
+}
+
+// refreshPresence keeps this node's presence hash alive while it is running
+func (f *RedisFanout) refreshPresence() {
+	ticker := time.NewTicker(presenceRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.client.Expire(context.Background(), f.presenceKey, presenceTTL).Err(); err != nil {
+				log.Printf("Error refreshing presence for node %s: %v", f.nodeID, err)
+			}
+		case <-f.done:
+			return
+		}
+	}
+}
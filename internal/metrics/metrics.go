@@ -75,4 +75,20 @@ var (
 		},
 		[]string{"operation"},
 	)
+
+	// Auth metrics
+	LoginFailuresTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rtcs_login_failures_total",
+			Help: "Total number of logins refused for wrong credentials",
+		},
+	)
+
+	LoginBlockedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rtcs_login_blocked_total",
+			Help: "Total number of login attempts refused while locked out",
+		},
+		[]string{"scope"},
+	)
 )
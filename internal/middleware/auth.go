@@ -10,15 +10,51 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
+// AccessTokenTTL is how long an access token is valid; clients renew it with
+// a refresh token
+const AccessTokenTTL = 15 * time.Minute
+
+// MFATokenTTL is how long a user has to enter their second factor after the
+// password was accepted
+const MFATokenTTL = 5 * time.Minute
+
+// EmailVerificationTTL is how long an email verification link stays valid
+const EmailVerificationTTL = 48 * time.Hour
+
+// mfaPurpose marks tokens that only prove the password step of a login
+const mfaPurpose = "mfa"
+
+// verifyEmailPurpose marks tokens sent in email verification links
+const verifyEmailPurpose = "verify_email"
+
+// ErrTokenRevoked is returned for tokens revoked before they expired
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// RevocationChecker reports whether a token ID (jti) has been revoked
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+// revocations is consulted by Auth and the WebSocket handshake; nil disables
+// revocation checks
+var revocations RevocationChecker
+
+// SetRevocationChecker installs the denylist consulted for every token
+func SetRevocationChecker(checker RevocationChecker) {
+	revocations = checker
+}
+
 type Claims struct {
-	UserID string `json:"user_id"`
+	UserID    string `json:"user_id"`
+	SessionID string `json:"sid,omitempty"`     // The login the token was issued for, revocable as a whole
+	Purpose   string `json:"purpose,omitempty"` // Empty for access tokens; tokens with a purpose grant no access
+	Email     string `json:"email,omitempty"`   // The address an email verification token was sent to
 	jwt.RegisteredClaims
 }
 
-var jwtKey = []byte("your-secret-key") // TODO: Move to config
-
 func AuthMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -47,13 +83,7 @@ func AuthMiddleware() func(http.Handler) http.Handler {
 			}
 			log.Printf("Auth middleware: extracted token: %s", tokenString)
 
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					log.Printf("Auth middleware: unexpected signing method: %v", token.Header["alg"])
-					return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-				}
-				return jwtKey, nil
-			})
+			token, err := jwt.Parse(tokenString, Keys().keyFunc)
 
 			if err != nil {
 				log.Printf("Auth middleware: error parsing token: %v", err)
@@ -61,7 +91,7 @@ func AuthMiddleware() func(http.Handler) http.Handler {
 				return
 			}
 
-			if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+			if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid && claims["purpose"] == nil {
 				userID, ok := claims["user_id"].(string)
 				if !ok {
 					log.Printf("Auth middleware: user_id not found in claims")
@@ -81,26 +111,83 @@ func AuthMiddleware() func(http.Handler) http.Handler {
 	}
 }
 
-// GenerateToken creates a new JWT token for a user
+// GenerateToken creates a new access token for a user. Every token gets a
+// unique ID (jti) so it can be revoked on its own.
 func GenerateToken(userID string) (string, error) {
+	return GenerateSessionToken(userID, "")
+}
+
+// GenerateSessionToken creates an access token belonging to a session, so
+// that revoking the session ID revokes every token issued for it
+func GenerateSessionToken(userID, sessionID string) (string, error) {
+	claims := &Claims{
+		UserID:    userID,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	return Keys().sign(claims)
+}
+
+// GenerateMFAToken creates the token handed out after the password step of a
+// login, to be exchanged for an access token together with a second factor
+func GenerateMFAToken(userID string) (string, error) {
 	claims := &Claims{
-		UserID: userID,
+		UserID:  userID,
+		Purpose: mfaPurpose,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(MFATokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	return Keys().sign(claims)
 }
 
+// GenerateEmailVerificationToken creates the token sent to a user to prove
+// they own email
+func GenerateEmailVerificationToken(userID, email string) (string, error) {
+	claims := &Claims{
+		UserID:  userID,
+		Purpose: verifyEmailPurpose,
+		Email:   email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(EmailVerificationTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	return Keys().sign(claims)
+}
+
+// ValidateToken validates an access token
 func ValidateToken(tokenString string) (*Claims, error) {
+	return validateToken(tokenString, "")
+}
+
+// ValidateMFAToken validates a token from GenerateMFAToken
+func ValidateMFAToken(tokenString string) (*Claims, error) {
+	return validateToken(tokenString, mfaPurpose)
+}
+
+// ValidateEmailVerificationToken validates a token from
+// GenerateEmailVerificationToken
+func ValidateEmailVerificationToken(tokenString string) (*Claims, error) {
+	return validateToken(tokenString, verifyEmailPurpose)
+}
+
+func validateToken(tokenString, purpose string) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, Keys().keyFunc)
 
 	if err != nil {
 		return nil, err
@@ -110,5 +197,36 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	if claims.Purpose != purpose {
+		return nil, errors.New("token is not valid for this use")
+	}
+
 	return claims, nil
 }
+
+// CheckRevoked returns ErrTokenRevoked if the token or its session was
+// revoked. Tokens are refused when the denylist cannot be reached, since a
+// revoked token must not slip through.
+func CheckRevoked(ctx context.Context, claims *Claims) error {
+	if revocations == nil {
+		return nil
+	}
+	return IsTokenRevoked(ctx, revocations, claims)
+}
+
+// IsTokenRevoked checks the token's ID and session ID against checker
+func IsTokenRevoked(ctx context.Context, checker RevocationChecker, claims *Claims) error {
+	for _, id := range []string{claims.ID, claims.SessionID} {
+		if id == "" {
+			continue
+		}
+		revoked, err := checker.IsRevoked(ctx, id)
+		if err != nil {
+			return fmt.Errorf("checking token revocation: %w", err)
+		}
+		if revoked {
+			return ErrTokenRevoked
+		}
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"rtcs/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is a key tokens are signed or verified with. Sign is nil for
+// keys that only verify, such as a retired key whose private half is gone.
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	Sign   interface{} // []byte, *rsa.PrivateKey or ed25519.PrivateKey
+	Verify interface{} // []byte, *rsa.PublicKey or ed25519.PublicKey
+}
+
+// KeySet holds the active signing key and every key still accepted for
+// verification, looked up by the token's kid header
+type KeySet struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// NewKeySet creates a key set signing with the key named activeID
+func NewKeySet(activeID string, keys ...*SigningKey) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*SigningKey, len(keys))}
+	for _, key := range keys {
+		if _, dup := ks.keys[key.ID]; dup {
+			return nil, fmt.Errorf("duplicate JWT key ID %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+
+	ks.active = ks.keys[activeID]
+	if ks.active == nil {
+		return nil, fmt.Errorf("active JWT key %q is not configured", activeID)
+	}
+	if ks.active.Sign == nil {
+		return nil, fmt.Errorf("active JWT key %q has no private key", activeID)
+	}
+	return ks, nil
+}
+
+// LoadKeySet builds the key set described by the configuration. Without
+// configured keys JWTSecret becomes a single HS256 key; with a single key that
+// key is active unless another is named.
+func LoadKeySet(cfg *config.Config) (*KeySet, error) {
+	if len(cfg.JWTKeys) == 0 {
+		if cfg.JWTSecret == config.DefaultJWTSecret {
+			log.Printf("WARNING: JWT_SECRET is not set; tokens are signed with the well-known default secret and can be forged")
+		}
+		return NewKeySet("default", hmacKey("default", cfg.JWTSecret))
+	}
+
+	keys := make([]*SigningKey, 0, len(cfg.JWTKeys))
+	for _, keyCfg := range cfg.JWTKeys {
+		key, err := loadKey(keyCfg)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", keyCfg.ID, err)
+		}
+		keys = append(keys, key)
+	}
+
+	activeID := cfg.JWTActiveKeyID
+	if activeID == "" && len(keys) == 1 {
+		activeID = keys[0].ID
+	}
+	return NewKeySet(activeID, keys...)
+}
+
+func hmacKey(id, secret string) *SigningKey {
+	return &SigningKey{ID: id, Method: jwt.SigningMethodHS256, Sign: []byte(secret), Verify: []byte(secret)}
+}
+
+func loadKey(cfg config.JWTKey) (*SigningKey, error) {
+	switch cfg.Algorithm {
+	case "HS256":
+		if cfg.Secret == "" {
+			return nil, errors.New("empty secret")
+		}
+		return hmacKey(cfg.ID, cfg.Secret), nil
+	case "RS256", "EdDSA":
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", cfg.Algorithm)
+	}
+
+	data, err := os.ReadFile(cfg.PEMFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in %s", cfg.PEMFile)
+	}
+
+	var private crypto.Signer
+	var public crypto.PublicKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var parsed interface{}
+		if parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			var ok bool
+			if private, ok = parsed.(crypto.Signer); !ok {
+				err = fmt.Errorf("unsupported private key type %T", parsed)
+			}
+		}
+	case "PUBLIC KEY":
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if private != nil {
+		public = private.Public()
+	}
+
+	key := &SigningKey{ID: cfg.ID, Verify: public}
+	switch cfg.Algorithm {
+	case "RS256":
+		if _, ok := public.(*rsa.PublicKey); !ok {
+			return nil, errors.New("RS256 needs an RSA key")
+		}
+		key.Method = jwt.SigningMethodRS256
+	case "EdDSA":
+		if _, ok := public.(ed25519.PublicKey); !ok {
+			return nil, errors.New("EdDSA needs an Ed25519 key")
+		}
+		key.Method = jwt.SigningMethodEdDSA
+	}
+	if private != nil {
+		key.Sign = private
+	}
+	return key, nil
+}
+
+// sign signs claims with the active key, naming it in the kid header
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.Method, claims)
+	token.Header["kid"] = ks.active.ID
+	return token.SignedString(ks.active.Sign)
+}
+
+// keyFunc picks the verification key named by a token's kid header. Tokens
+// without a kid are checked against the active key. The token's alg must match
+// the key's, so an RSA public key can never be used as an HMAC secret.
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	key := ks.active
+	if kid, ok := token.Header["kid"].(string); ok {
+		if key = ks.keys[kid]; key == nil {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Verify, nil
+}
+
+// JWK is a public key in JSON Web Key form
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // Ed25519 curve
+	X   string `json:"x,omitempty"`   // Ed25519 public key
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set. HMAC keys are shared secrets and
+// are never published, so a set of HMAC keys yields an empty document.
+func (ks *KeySet) JWKS() JWKSet {
+	ids := make([]string, 0, len(ks.keys))
+	for id := range ks.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, id := range ids {
+		key := ks.keys[id]
+		switch public := key.Verify.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return set
+}
+
+var (
+	keySet     atomic.Pointer[KeySet]
+	keySetOnce sync.Once
+)
+
+// SetKeySet replaces the keys tokens are signed and verified with. It is safe
+// to call while requests are being authenticated.
+func SetKeySet(ks *KeySet) {
+	keySetOnce.Do(func() {})
+	keySet.Store(ks)
+}
+
+// Keys returns the key set in use. Until SetKeySet is called it is loaded from
+// the configuration.
+func Keys() *KeySet {
+	keySetOnce.Do(func() {
+		ks, err := LoadKeySet(config.Get())
+		if err != nil {
+			panic(fmt.Sprintf("loading JWT keys: %v", err))
+		}
+		keySet.Store(ks)
+	})
+	return keySet.Load()
+}
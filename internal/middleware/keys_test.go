@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"rtcs/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return path
+}
+
+func withKeySet(t *testing.T, ks *KeySet) {
+	t.Helper()
+	previous := Keys()
+	SetKeySet(ks)
+	t.Cleanup(func() { SetKeySet(previous) })
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	rsaPath := writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	oldKeys, err := LoadKeySet(&config.Config{
+		JWTKeys: []config.JWTKey{{ID: "old", Algorithm: "HS256", Secret: "old-secret"}},
+	})
+	if err != nil {
+		t.Fatalf("LoadKeySet failed: %v", err)
+	}
+	withKeySet(t, oldKeys)
+	oldToken, err := GenerateToken("user-1")
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	// Rotate to an RSA key while still accepting the old one
+	newKeys, err := LoadKeySet(&config.Config{
+		JWTKeys: []config.JWTKey{
+			{ID: "old", Algorithm: "HS256", Secret: "old-secret"},
+			{ID: "new", Algorithm: "RS256", PEMFile: rsaPath},
+		},
+		JWTActiveKeyID: "new",
+	})
+	if err != nil {
+		t.Fatalf("LoadKeySet failed: %v", err)
+	}
+	withKeySet(t, newKeys)
+
+	if claims, err := ValidateToken(oldToken); err != nil || claims.UserID != "user-1" {
+		t.Fatalf("Expected a token signed before the rotation to validate, got %v", err)
+	}
+	newToken, err := GenerateToken("user-2")
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	parsed, _, _ := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+	if parsed.Header["kid"] != "new" || parsed.Method.Alg() != "RS256" {
+		t.Errorf("Expected an RS256 token with kid new, got %v", parsed.Header)
+	}
+	if _, err := ValidateToken(newToken); err != nil {
+		t.Errorf("ValidateToken failed: %v", err)
+	}
+
+	jwks := newKeys.JWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != "new" || jwks.Keys[0].Kty != "RSA" {
+		t.Errorf("Expected only the RSA key to be published, got %+v", jwks.Keys)
+	}
+
+	// Once the old key is dropped its tokens stop validating
+	retired, _ := LoadKeySet(&config.Config{
+		JWTKeys: []config.JWTKey{{ID: "new", Algorithm: "RS256", PEMFile: rsaPath}},
+	})
+	withKeySet(t, retired)
+	if _, err := ValidateToken(oldToken); err == nil {
+		t.Error("Expected a token signed with a removed key to be rejected")
+	}
+}
+
+func TestSetKeySet_WhileValidating(t *testing.T) {
+	keys, err := LoadKeySet(&config.Config{JWTSecret: "shared-secret"})
+	if err != nil {
+		t.Fatalf("LoadKeySet failed: %v", err)
+	}
+	withKeySet(t, keys)
+	token, err := GenerateToken("user-1")
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	// Swapping in an equivalent key set never fails validation; run with
+	// -race to catch unsynchronised access
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			SetKeySet(keys)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := ValidateToken(token); err != nil {
+			t.Fatalf("ValidateToken failed: %v", err)
+		}
+	}
+	<-done
+}
+
+func TestKeySet_EdDSAAndAlgorithmConfusion(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(private)
+	ks, err := LoadKeySet(&config.Config{
+		JWTKeys: []config.JWTKey{{ID: "ed", Algorithm: "EdDSA", PEMFile: writePEM(t, "PRIVATE KEY", der)}},
+	})
+	if err != nil {
+		t.Fatalf("LoadKeySet failed: %v", err)
+	}
+	withKeySet(t, ks)
+
+	token, err := GenerateToken("user-1")
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	if _, err := ValidateToken(token); err != nil {
+		t.Errorf("ValidateToken failed: %v", err)
+	}
+	if jwks := ks.JWKS(); len(jwks.Keys) != 1 || jwks.Keys[0].Crv != "Ed25519" {
+		t.Errorf("Expected the Ed25519 key to be published, got %+v", jwks.Keys)
+	}
+
+	// An HMAC token keyed with the public key must not pass for an EdDSA one
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: "attacker"})
+	forged.Header["kid"] = "ed"
+	forgedString, _ := forged.SignedString([]byte(public))
+	if _, err := ValidateToken(forgedString); err == nil {
+		t.Error("Expected a token with a mismatched algorithm to be rejected")
+	}
+}
+
+func TestLoadKeySet_Errors(t *testing.T) {
+	public, _, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(public)
+	publicOnly := writePEM(t, "PUBLIC KEY", der)
+
+	tests := map[string]*config.Config{
+		"unknown algorithm":        {JWTKeys: []config.JWTKey{{ID: "a", Algorithm: "none"}}},
+		"missing active key":       {JWTKeys: []config.JWTKey{{ID: "a", Algorithm: "HS256", Secret: "s"}, {ID: "b", Algorithm: "HS256", Secret: "t"}}},
+		"active key cannot sign":   {JWTKeys: []config.JWTKey{{ID: "a", Algorithm: "EdDSA", PEMFile: publicOnly}}},
+		"algorithm does not match": {JWTKeys: []config.JWTKey{{ID: "a", Algorithm: "RS256", PEMFile: publicOnly}}},
+	}
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadKeySet(cfg); err == nil {
+				t.Error("Expected LoadKeySet to fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeletedUserName is the display name left on an account once it is deleted.
+// The row itself stays so that messages kept after the deletion still have a
+// sender.
+const DeletedUserName = "Deleted user"
+
+// DeletedUsername returns the username a deleted account is renamed to,
+// freeing its email address for a new account
+func DeletedUsername(id uuid.UUID) string {
+	return "deleted:" + id.String()
+}
+
+// AccountDeletion describes what deleting an account touched, so that caches
+// and live connections can be brought up to date afterwards
+type AccountDeletion struct {
+	Username       string      // Username before the deletion
+	ChatIDs        []uuid.UUID // Chats the user was a member of or had written in
+	LeftChatIDs    []uuid.UUID // Chats the user was removed from
+	MessageIDs     []uuid.UUID // Messages the user wrote
+	TokenFamilyIDs []uuid.UUID // Sessions that were revoked
+}
+
+// ExportStatus is the state of a data export
+type ExportStatus string
+
+const (
+	ExportPending ExportStatus = "pending" // Waiting for a worker
+	ExportRunning ExportStatus = "running"
+	ExportReady   ExportStatus = "ready" // Archive can be downloaded until ExpiresAt
+	ExportFailed  ExportStatus = "failed"
+)
+
+// DataExport is a user's request for a copy of their personal data. The zip
+// archive is built in the background and kept until it expires.
+type DataExport struct {
+	ID          uuid.UUID    `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	UserID      uuid.UUID    `gorm:"type:uuid;index;not null" json:"user_id"`
+	Status      ExportStatus `gorm:"type:varchar(16);not null;default:pending" json:"status"`
+	Error       string       `gorm:"type:text;not null;default:''" json:"error,omitempty"`
+	Archive     []byte       `gorm:"type:bytea" json:"-"`
+	Size        int64        `gorm:"not null;default:0" json:"size,omitempty"` // Archive size in bytes
+	CreatedAt   time.Time    `json:"created_at"`
+	StartedAt   *time.Time   `json:"started_at,omitempty"`
+	CompletedAt *time.Time   `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+}
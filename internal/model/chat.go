@@ -1,26 +1,125 @@
 package model
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// ChatVisibility decides who may join a chat
+type ChatVisibility string
+
+const (
+	VisibilityPublic  ChatVisibility = "public"  // Anyone may join
+	VisibilityPrivate ChatVisibility = "private" // Joined through invites only
+)
+
+// Valid reports whether v is a known visibility
+func (v ChatVisibility) Valid() bool {
+	return v == VisibilityPublic || v == VisibilityPrivate
+}
+
+// ChatKind tells group chats apart from direct messages
+type ChatKind string
+
+const (
+	KindGroup  ChatKind = "group"
+	KindDirect ChatKind = "direct" // Exactly two members, see DirectKey
+)
+
+// DirectKey identifies the direct message chat between two users, whichever
+// of them opened it
+func DirectKey(a, b uuid.UUID) string {
+	if strings.Compare(a.String(), b.String()) > 0 {
+		a, b = b, a
+	}
+	return a.String() + ":" + b.String()
+}
+
 // Chat represents a chat room
 type Chat struct {
+	ID         uuid.UUID      `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	Name       string         `gorm:"type:varchar(255);not null" json:"name"`
+	Kind       ChatKind       `gorm:"type:varchar(16);not null;default:group" json:"kind"`
+	DirectKey  *string        `gorm:"type:varchar(73);uniqueIndex" json:"-"` // Set for direct messages only
+	Visibility ChatVisibility `gorm:"type:varchar(16);not null;default:public" json:"visibility"`
+	CreatedBy  uuid.UUID      `gorm:"type:uuid;index" json:"created_by"`
+	LastSeq    int64          `gorm:"not null;default:0" json:"last_seq"` // Sequence number of the newest message
+	CreatedAt  time.Time      `gorm:"index" json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  *time.Time     `gorm:"index" json:"deleted_at,omitempty"`
+	Users      []User         `gorm:"many2many:chat_users;" json:"users,omitempty"`
+}
+
+// ChatInvite lets users join a private chat. Only a hash of the token is
+// stored; the token itself is returned once, when the invite is created.
+type ChatInvite struct {
 	ID        uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
-	Name      string     `gorm:"type:varchar(255);not null" json:"name"`
-	CreatedAt time.Time  `gorm:"index" json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	DeletedAt *time.Time `gorm:"index" json:"deleted_at,omitempty"`
-	Users     []User     `gorm:"many2many:chat_users;" json:"users,omitempty"`
+	ChatID    uuid.UUID  `gorm:"type:uuid;index;not null" json:"chat_id"`
+	CreatedBy uuid.UUID  `gorm:"type:uuid;not null" json:"created_by"`
+	TokenHash string     `gorm:"type:varchar(64);uniqueIndex;not null" json:"-"`
+	Token     string     `gorm:"-" json:"token,omitempty"`
+	MaxUses   int        `gorm:"not null;default:0" json:"max_uses"` // 0 means unlimited
+	Uses      int        `gorm:"not null;default:0" json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ChatRole is a member's role in a chat, which decides what they may do
+type ChatRole string
+
+const (
+	RoleOwner    ChatRole = "owner"
+	RoleAdmin    ChatRole = "admin"
+	RoleMember   ChatRole = "member"
+	RoleReadOnly ChatRole = "read_only"
+)
+
+// Valid reports whether r is a known role
+func (r ChatRole) Valid() bool {
+	switch r {
+	case RoleOwner, RoleAdmin, RoleMember, RoleReadOnly:
+		return true
+	}
+	return false
 }
 
 // ChatUser represents a user's membership in a chat
 type ChatUser struct {
 	ChatID   uuid.UUID `gorm:"type:uuid;primaryKey" json:"chat_id"`
 	UserID   uuid.UUID `gorm:"type:uuid;primaryKey" json:"user_id"`
+	Role     ChatRole  `gorm:"type:varchar(16);not null;default:member" json:"role"`
 	JoinedAt time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"joined_at"`
 	Chat     *Chat     `gorm:"foreignKey:ChatID" json:"-"`
 	User     *User     `gorm:"foreignKey:UserID" json:"-"`
+
+	// Member's profile, filled in on request
+	Profile *UserProfile `gorm:"-" json:"user,omitempty"`
+}
+
+// ChatRead is a user's read pointer into a chat, used for read receipts and
+// unread counts
+type ChatRead struct {
+	ChatID            uuid.UUID `gorm:"type:uuid;primaryKey" json:"chat_id"`
+	UserID            uuid.UUID `gorm:"type:uuid;primaryKey" json:"user_id"`
+	LastReadMessageID uuid.UUID `gorm:"type:uuid;not null" json:"last_read_message_id"`
+	LastReadSeq       int64     `gorm:"not null" json:"last_read_seq"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// ChatBan keeps a user out of a chat until it expires or is lifted
+type ChatBan struct {
+	ChatID    uuid.UUID  `gorm:"type:uuid;primaryKey" json:"chat_id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;primaryKey" json:"user_id"`
+	BannedBy  uuid.UUID  `gorm:"type:uuid;not null" json:"banned_by"`
+	Reason    string     `gorm:"type:varchar(500);not null;default:''" json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Nil for permanent bans
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Active reports whether the ban is still in force at now
+func (b *ChatBan) Active(now time.Time) bool {
+	return b.ExpiresAt == nil || b.ExpiresAt.After(now)
 }
@@ -7,11 +7,64 @@ import (
 )
 
 type Message struct {
-	ID        uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
-	ChatID    uuid.UUID `gorm:"type:uuid;index" json:"chat_id"`
-	SenderID  uuid.UUID `gorm:"type:uuid;index" json:"sender_id"`
-	Text      string    `gorm:"type:text;not null" json:"text"`
-	CreatedAt time.Time `gorm:"index" json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	ChatID    uuid.UUID  `gorm:"type:uuid;index" json:"chat_id"`
+	SenderID  uuid.UUID  `gorm:"type:uuid;index" json:"sender_id"`
+	ParentID  *uuid.UUID `gorm:"type:uuid;index" json:"parent_id,omitempty"` // Root of the thread this message replies in
+	Text      string     `gorm:"type:text;not null" json:"text"`
+	Seq       int64      `gorm:"not null;default:0" json:"seq"` // Per-chat sequence number, assigned on save
+	CreatedAt time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
 	DeletedAt *time.Time `gorm:"index" json:"deleted_at,omitempty"`
+
+	// Thread summary and reactions, filled in when reading history
+	ReplyCount  int64           `gorm:"-" json:"reply_count"`
+	LastReplyAt *time.Time      `gorm:"-" json:"last_reply_at,omitempty"`
+	Reactions   []ReactionCount `gorm:"-" json:"reactions,omitempty"`
+
+	// Sender's profile, filled in on request
+	Sender *UserProfile `gorm:"-" json:"sender,omitempty"`
+}
+
+// MessageCursor is a position in a chat's history, ordered by creation time
+// with the ID as tie-breaker
+type MessageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// MessagePage is one page of chat history, newest message first
+type MessagePage struct {
+	Messages   []*Message `json:"messages"`
+	NextCursor string     `json:"next_cursor,omitempty"` // Continues in the same direction; empty on the last page
+}
+
+// ReplyStats summarises the replies to a thread root
+type ReplyStats struct {
+	ParentID    uuid.UUID
+	Count       int64
+	LastReplyAt time.Time
+}
+
+// MessageEdit is a prior revision of a message, stored when it is edited
+type MessageEdit struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+	MessageID uuid.UUID `gorm:"type:uuid;index;not null" json:"message_id"`
+	Text      string    `gorm:"type:text;not null" json:"text"` // Text before the edit
+	EditedAt  time.Time `gorm:"not null" json:"edited_at"`
+}
+
+// MessageReaction is one user's emoji reaction to a message
+type MessageReaction struct {
+	MessageID uuid.UUID `gorm:"type:uuid;primaryKey" json:"message_id"`
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey" json:"user_id"`
+	Emoji     string    `gorm:"primaryKey" json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReactionCount is the number of users who reacted to a message with an emoji
+type ReactionCount struct {
+	MessageID uuid.UUID `json:"-"`
+	Emoji     string    `json:"emoji"`
+	Count     int64     `json:"count"`
 }
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SearchQuery is a full-text search over the messages of the chats a user
+// belongs to. The optional fields narrow the search.
+type SearchQuery struct {
+	UserID   uuid.UUID
+	Text     string
+	ChatID   *uuid.UUID
+	SenderID *uuid.UUID
+	From     *time.Time // Inclusive
+	To       *time.Time // Exclusive
+	Limit    int
+	Offset   int
+}
+
+// SearchResult is a message matching a search, with the matching terms
+// highlighted in Snippet. Snippets are not HTML-escaped.
+type SearchResult struct {
+	Message *Message `json:"message"`
+	Snippet string   `json:"snippet"`
+	Rank    float64  `json:"rank"`
+}
@@ -15,6 +15,38 @@ type User struct {
 	DeletedAt *time.Time `gorm:"index" json:"deleted_at,omitempty"`
 	Username  string     `gorm:"unique;not null" json:"username"`
 	Password  string     `json:"-"` // Hidden from JSON
+
+	// EmailVerified is set once the user opened the link sent to their
+	// address, which is their username
+	EmailVerified bool `gorm:"not null;default:false" json:"email_verified"`
+
+	// Profile
+	DisplayName string `gorm:"type:varchar(64);not null;default:''" json:"display_name"`
+	Bio         string `gorm:"type:text;not null;default:''" json:"bio"`
+	Avatar      string `gorm:"type:varchar(512);not null;default:''" json:"avatar"`  // URL or storage key of the avatar image
+	Timezone    string `gorm:"type:varchar(64);not null;default:''" json:"timezone"` // IANA name, e.g. Europe/Berlin
+	StatusText  string `gorm:"type:varchar(140);not null;default:''" json:"status_text"`
+}
+
+// UserProfile is the part of a user's profile embedded next to their
+// messages and memberships
+type UserProfile struct {
+	ID          uuid.UUID `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Avatar      string    `json:"avatar,omitempty"`
+	StatusText  string    `json:"status_text,omitempty"`
+}
+
+// Profile returns the user's embeddable profile
+func (u *User) Profile() *UserProfile {
+	return &UserProfile{
+		ID:          u.ID,
+		Username:    u.Username,
+		DisplayName: u.DisplayName,
+		Avatar:      u.Avatar,
+		StatusText:  u.StatusText,
+	}
 }
 
 // BeforeCreate is called before creating a new user
@@ -24,3 +56,11 @@ func (u *User) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// UserBlock records that BlockerID no longer wants to see BlockedID's
+// messages or be sent direct messages by them
+type UserBlock struct {
+	BlockerID uuid.UUID `gorm:"type:uuid;primaryKey" json:"blocker_id"`
+	BlockedID uuid.UUID `gorm:"type:uuid;primaryKey;index" json:"blocked_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
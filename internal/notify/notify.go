@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Message is a notification for a single user, such as a password reset link
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notifier delivers messages to users. SMTPMailer sends them as email;
+// LogOutbox and FileOutbox write them to a local outbox so that deployments
+// and tests can work without a mail server.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// LogOutbox writes messages to the server log
+type LogOutbox struct{}
+
+func NewLogOutbox() *LogOutbox {
+	return &LogOutbox{}
+}
+
+func (LogOutbox) Notify(ctx context.Context, msg Message) error {
+	log.Printf("Notification to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileOutbox appends messages to a file, one JSON object per line
+type FileOutbox struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileOutbox(path string) *FileOutbox {
+	return &FileOutbox{path: path}
+}
+
+type outboxEntry struct {
+	Message
+	SentAt time.Time `json:"sent_at"`
+}
+
+func (o *FileOutbox) Notify(ctx context.Context, msg Message) error {
+	line, err := json.Marshal(outboxEntry{Message: msg, SentAt: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// Messages carry secrets such as reset tokens, so keep the file private
+	f, err := os.OpenFile(o.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// New returns a FileOutbox writing to path, or a LogOutbox if path is empty
+func New(path string) Notifier {
+	if path == "" {
+		return NewLogOutbox()
+	}
+	return NewFileOutbox(path)
+}
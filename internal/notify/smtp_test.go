@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// receivedMail is one message accepted by fakeSMTPServer
+type receivedMail struct {
+	from, to string
+	data     string
+}
+
+// fakeSMTPServer accepts one message over plain SMTP and sends it on mails
+func fakeSMTPServer(t *testing.T) (host string, port int, mails <-chan receivedMail) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	out := make(chan receivedMail, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+		var received receivedMail
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "MAIL":
+				received.from = line
+				reply("250 OK")
+			case "RCPT":
+				received.to = line
+				reply("250 OK")
+			case "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received.data = data.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				out <- received
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, out
+}
+
+func TestSMTPMailer_Notify(t *testing.T) {
+	host, port, mails := fakeSMTPServer(t)
+	mailer, err := NewSMTPMailer(SMTPConfig{Host: host, Port: port, From: "RTCS <no-reply@example.com>"})
+	if err != nil {
+		t.Fatalf("NewSMTPMailer failed: %v", err)
+	}
+
+	err = mailer.Notify(context.Background(), Message{
+		To:      "alice@example.com",
+		Subject: "Verify\r\nBcc: mallory@example.com",
+		Body:    "Open https://chat.example.com/auth/verify-email?token=abc to confirm.",
+	})
+	if err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	got := <-mails
+	if !strings.HasPrefix(got.from, "MAIL FROM:<no-reply@example.com>") {
+		t.Errorf("sender = %q", got.from)
+	}
+	if got.to != "RCPT TO:<alice@example.com>" {
+		t.Errorf("recipient = %q", got.to)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if bcc := msg.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("subject injected a Bcc header: %q", bcc)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Verify\r\nBcc: mallory@example.com" {
+		t.Errorf("subject = %q, %v", subject, err)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatalf("reading body failed: %v", err)
+	}
+	if !strings.Contains(string(body), "https://chat.example.com/auth/verify-email?token=abc") {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestNewSMTPMailer_InvalidSender(t *testing.T) {
+	if _, err := NewSMTPMailer(SMTPConfig{Host: "localhost", Port: 25, From: "not an address"}); err == nil {
+		t.Error("expected an error for a malformed sender")
+	}
+}
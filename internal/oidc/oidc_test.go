@@ -0,0 +1,143 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"rtcs/internal/oidc"
+	"rtcs/internal/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const redirectURL = "https://chat.example.com/auth/oidc/test/callback"
+
+// login runs the flow up to the provider's callback, returning the request
+// and the code
+func login(t *testing.T, idp *oidctest.Server, provider *oidc.Provider) (*oidc.AuthRequest, string) {
+	t.Helper()
+	req, err := oidc.NewAuthRequest(provider.Name())
+	if err != nil {
+		t.Fatalf("NewAuthRequest failed: %v", err)
+	}
+	authURL, err := provider.AuthCodeURL(context.Background(), req)
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	callback, err := idp.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("Authorize failed: %v", err)
+	}
+	if got := callback.Query().Get("state"); got != req.State {
+		t.Fatalf("callback state = %q, want %q", got, req.State)
+	}
+	return req, callback.Query().Get("code")
+}
+
+func TestProvider_Login(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	idp.SetUser(oidctest.User{Subject: "user-1", Email: "alice@example.com", EmailVerified: true, Name: "Alice"})
+	provider := oidc.NewProvider(idp.Config("test", redirectURL), nil)
+
+	req, code := login(t, idp, provider)
+	token, err := provider.Exchange(context.Background(), req, code)
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	want := oidc.IDToken{Subject: "user-1", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}
+	if *token != want {
+		t.Errorf("token = %+v, want %+v", *token, want)
+	}
+
+	// Codes work once
+	if _, err := provider.Exchange(context.Background(), req, code); !errors.Is(err, oidc.ErrTokenExchange) {
+		t.Errorf("reusing the code: got %v, want ErrTokenExchange", err)
+	}
+}
+
+func TestProvider_AuthCodeURL(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	provider := oidc.NewProvider(idp.Config("test", redirectURL), nil)
+
+	req := &oidc.AuthRequest{State: "state", Nonce: "nonce", Verifier: "verifier"}
+	authURL, err := provider.AuthCodeURL(context.Background(), req)
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	q := u.Query()
+	for param, want := range map[string]string{
+		"redirect_uri":          redirectURL,
+		"scope":                 "openid email profile",
+		"nonce":                 "nonce",
+		"code_challenge":        oidc.CodeChallenge("verifier"),
+		"code_challenge_method": "S256",
+	} {
+		if got := q.Get(param); got != want {
+			t.Errorf("%s = %q, want %q", param, got, want)
+		}
+	}
+}
+
+func TestProvider_RejectsWrongVerifier(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	idp.SetUser(oidctest.User{Subject: "user-1"})
+	provider := oidc.NewProvider(idp.Config("test", redirectURL), nil)
+
+	req, code := login(t, idp, provider)
+	req.Verifier = "someone-elses-verifier"
+	if _, err := provider.Exchange(context.Background(), req, code); !errors.Is(err, oidc.ErrTokenExchange) {
+		t.Errorf("got %v, want ErrTokenExchange", err)
+	}
+}
+
+func TestProvider_RejectsBadIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(claims jwt.MapClaims)
+	}{
+		{"other nonce", func(c jwt.MapClaims) { c["nonce"] = "replayed" }},
+		{"other audience", func(c jwt.MapClaims) { c["aud"] = "another-client" }},
+		{"other issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+		{"shared audience without azp", func(c jwt.MapClaims) { c["aud"] = []string{oidctest.ClientID, "another-client"} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := oidctest.NewServer()
+			defer idp.Close()
+			idp.SetUser(oidctest.User{Subject: "user-1"})
+			idp.IDTokenClaims = tt.change
+			provider := oidc.NewProvider(idp.Config("test", redirectURL), nil)
+
+			req, code := login(t, idp, provider)
+			if _, err := provider.Exchange(context.Background(), req, code); !errors.Is(err, oidc.ErrInvalidIDToken) {
+				t.Errorf("got %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+func TestProvider_DiscoveryChecksIssuer(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	cfg := idp.Config("test", redirectURL)
+	cfg.Issuer += "/"
+	provider := oidc.NewProvider(cfg, nil)
+
+	_, err := provider.AuthCodeURL(context.Background(), &oidc.AuthRequest{})
+	if !errors.Is(err, oidc.ErrDiscovery) {
+		t.Errorf("got %v, want ErrDiscovery", err)
+	}
+}
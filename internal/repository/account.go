@@ -0,0 +1,291 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"rtcs/internal/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AccountRepository interface {
+	DeleteAccount(ctx context.Context, userID uuid.UUID, deleteMessages bool) (*model.AccountDeletion, error)
+	ListMemberships(ctx context.Context, userID uuid.UUID) ([]*model.ChatUser, error)
+	ListUserMessages(ctx context.Context, userID uuid.UUID, after *model.MessageCursor, limit int) ([]*model.Message, error)
+
+	// Export methods
+	CreateExport(ctx context.Context, export *model.DataExport) error
+	GetLatestExport(ctx context.Context, userID uuid.UUID) (*model.DataExport, error)
+	GetExportArchive(ctx context.Context, exportID uuid.UUID) ([]byte, error)
+	ClaimExport(ctx context.Context, staleBefore time.Time) (*model.DataExport, error)
+	CompleteExport(ctx context.Context, exportID uuid.UUID, archive []byte, expiresAt time.Time) error
+	FailExport(ctx context.Context, exportID uuid.UUID, reason string) error
+	DeleteExpiredExports(ctx context.Context, now time.Time) (int64, error)
+}
+
+type accountRepository struct {
+	db *gorm.DB
+}
+
+func NewAccountRepository(db *gorm.DB) AccountRepository {
+	return &accountRepository{db: db}
+}
+
+// DeleteAccount soft-deletes a user in one transaction. The user row is kept
+// as the sender of their remaining messages, but renamed and stripped of its
+// profile; memberships, reactions, blocks, sessions, 2FA, provider logins
+// and exports are removed. With deleteMessages the user's messages are
+// emptied and marked deleted rather than left in place. It returns nil if the
+// user does not exist or was already deleted.
+func (r *accountRepository) DeleteAccount(ctx context.Context, userID uuid.UUID, deleteMessages bool) (*model.AccountDeletion, error) {
+	var deletion *model.AccountDeletion
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user model.User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&user, "id = ? AND deleted_at IS NULL", userID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		result := &model.AccountDeletion{Username: user.Username}
+		now := time.Now()
+
+		// Chats the user owns alone go to the longest-standing admin, or
+		// failing that member, so that they are not left without an owner
+		var orphaned []uuid.UUID
+		err = tx.Model(&model.ChatUser{}).
+			Where("user_id = ? AND role = ?", userID, model.RoleOwner).
+			Where("NOT EXISTS (SELECT 1 FROM chat_users AS other WHERE other.chat_id = chat_users.chat_id AND other.user_id <> ? AND other.role = ?)", userID, model.RoleOwner).
+			Pluck("chat_id", &orphaned).Error
+		if err != nil {
+			return err
+		}
+		for _, chatID := range orphaned {
+			err := tx.Exec(`UPDATE chat_users SET role = ? WHERE chat_id = ? AND user_id = (
+				SELECT user_id FROM chat_users WHERE chat_id = ? AND user_id <> ?
+				ORDER BY CASE role WHEN ? THEN 0 WHEN ? THEN 1 ELSE 2 END, joined_at ASC
+				LIMIT 1)`,
+				model.RoleOwner, chatID, chatID, userID, model.RoleAdmin, model.RoleMember).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&model.ChatUser{}).Where("user_id = ?", userID).Pluck("chat_id", &result.LeftChatIDs).Error; err != nil {
+			return err
+		}
+		if len(result.LeftChatIDs) > 0 {
+			// Direct chats are named after the peer, who is now gone
+			err := tx.Model(&model.Chat{}).
+				Where("id IN ? AND kind = ?", result.LeftChatIDs, model.KindDirect).
+				Update("name", model.DeletedUserName).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, table := range []interface{}{&model.ChatUser{}, &model.ChatRead{}, &model.ChatBan{}, &model.MessageReaction{}} {
+			if err := tx.Where("user_id = ?", userID).Delete(table).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&model.Message{}).Where("sender_id = ?", userID).Pluck("id", &result.MessageIDs).Error; err != nil {
+			return err
+		}
+		var messageChatIDs []uuid.UUID
+		if err := tx.Model(&model.Message{}).Where("sender_id = ?", userID).Distinct().Pluck("chat_id", &messageChatIDs).Error; err != nil {
+			return err
+		}
+		result.ChatIDs = mergeIDs(result.LeftChatIDs, messageChatIDs)
+		if deleteMessages && len(result.MessageIDs) > 0 {
+			// Deleted messages keep their place, so sequence numbers, threads
+			// and read pointers stay intact
+			authored := tx.Model(&model.Message{}).Select("id").Where("sender_id = ?", userID)
+			if err := tx.Where("message_id IN (?)", authored).Delete(&model.MessageEdit{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("message_id IN (?)", authored).Delete(&model.MessageReaction{}).Error; err != nil {
+				return err
+			}
+			err := tx.Model(&model.Message{}).
+				Where("sender_id = ?", userID).
+				Updates(map[string]interface{}{"text": "", "deleted_at": now}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("blocker_id = ? OR blocked_id = ?", userID, userID).Delete(&model.UserBlock{}).Error; err != nil {
+			return err
+		}
+
+		err = tx.Model(&model.RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Distinct().
+			Pluck("family_id", &result.TokenFamilyIDs).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&model.RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+		for _, table := range []interface{}{&model.PasswordResetToken{}, &model.RecoveryCode{}, &model.UserTOTP{}, &model.DataExport{}, &model.UserIdentity{}} {
+			if err := tx.Where("user_id = ?", userID).Delete(table).Error; err != nil {
+				return err
+			}
+		}
+
+		err = tx.Model(&model.User{}).
+			Where("id = ?", userID).
+			Updates(map[string]interface{}{
+				"username":     model.DeletedUsername(userID),
+				"password":     "",
+				"display_name": model.DeletedUserName,
+				"bio":          "",
+				"avatar":       "",
+				"timezone":     "",
+				"status_text":  "",
+				"deleted_at":   now,
+				"updated_at":   now,
+			}).Error
+		if err != nil {
+			return err
+		}
+		deletion = result
+		return nil
+	})
+	return deletion, err
+}
+
+// mergeIDs returns the IDs found in either list, each once
+func mergeIDs(a, b []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(a)+len(b))
+	merged := make([]uuid.UUID, 0, len(a)+len(b))
+	for _, ids := range [][]uuid.UUID{a, b} {
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				merged = append(merged, id)
+			}
+		}
+	}
+	return merged
+}
+
+// ListMemberships returns the user's chat memberships with their chats,
+// oldest first
+func (r *accountRepository) ListMemberships(ctx context.Context, userID uuid.UUID) ([]*model.ChatUser, error) {
+	var members []*model.ChatUser
+	err := r.db.WithContext(ctx).
+		Preload("Chat").
+		Where("user_id = ?", userID).
+		Order("joined_at ASC").
+		Find(&members).Error
+	return members, err
+}
+
+// ListUserMessages retrieves up to limit messages written by the user, oldest
+// first, starting after the cursor if one is given
+func (r *accountRepository) ListUserMessages(ctx context.Context, userID uuid.UUID, after *model.MessageCursor, limit int) ([]*model.Message, error) {
+	var messages []*model.Message
+	query := r.db.WithContext(ctx).Where("sender_id = ?", userID)
+	if after != nil {
+		query = query.Where("(created_at, id) > (?, ?)", after.CreatedAt, after.ID)
+	}
+	err := query.Order("created_at ASC, id ASC").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+func (r *accountRepository) CreateExport(ctx context.Context, export *model.DataExport) error {
+	return r.db.WithContext(ctx).Create(export).Error
+}
+
+// GetLatestExport returns the user's most recent export without its archive,
+// or nil if they have none
+func (r *accountRepository) GetLatestExport(ctx context.Context, userID uuid.UUID) (*model.DataExport, error) {
+	var export model.DataExport
+	err := r.db.WithContext(ctx).
+		Omit("archive").
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		First(&export).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &export, err
+}
+
+// GetExportArchive returns the archive of a finished export, or nil if there
+// is none
+func (r *accountRepository) GetExportArchive(ctx context.Context, exportID uuid.UUID) ([]byte, error) {
+	var export model.DataExport
+	err := r.db.WithContext(ctx).
+		Select("archive").
+		Where("id = ? AND status = ?", exportID, model.ExportReady).
+		First(&export).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return export.Archive, err
+}
+
+// ClaimExport marks the oldest pending export as running and returns it, or
+// nil if there is nothing to do. Exports left running since before
+// staleBefore, by a worker that went away, are claimed again. Concurrent
+// workers never claim the same export.
+func (r *accountRepository) ClaimExport(ctx context.Context, staleBefore time.Time) (*model.DataExport, error) {
+	var export model.DataExport
+	err := r.db.WithContext(ctx).Raw(`UPDATE data_exports SET status = ?, started_at = ? WHERE id = (
+			SELECT id FROM data_exports
+			WHERE status = ? OR (status = ? AND started_at < ?)
+			ORDER BY created_at ASC
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id, user_id, status, created_at, started_at`,
+		model.ExportRunning, time.Now(), model.ExportPending, model.ExportRunning, staleBefore).
+		Scan(&export).Error
+	if err != nil || export.ID == uuid.Nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+func (r *accountRepository) CompleteExport(ctx context.Context, exportID uuid.UUID, archive []byte, expiresAt time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&model.DataExport{}).
+		Where("id = ?", exportID).
+		Updates(map[string]interface{}{
+			"status":       model.ExportReady,
+			"archive":      archive,
+			"size":         len(archive),
+			"completed_at": time.Now(),
+			"expires_at":   expiresAt,
+		}).Error
+}
+
+func (r *accountRepository) FailExport(ctx context.Context, exportID uuid.UUID, reason string) error {
+	return r.db.WithContext(ctx).
+		Model(&model.DataExport{}).
+		Where("id = ?", exportID).
+		Updates(map[string]interface{}{
+			"status":       model.ExportFailed,
+			"error":        reason,
+			"completed_at": time.Now(),
+		}).Error
+}
+
+// DeleteExpiredExports removes exports whose archive expired before now,
+// returning how many were removed
+func (r *accountRepository) DeleteExpiredExports(ctx context.Context, now time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("expires_at < ?", now).Delete(&model.DataExport{})
+	return result.RowsAffected, result.Error
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type chatRepository struct {
@@ -44,7 +45,9 @@ func (r *chatRepository) ListMessages(ctx context.Context, chatID uuid.UUID, lim
 }
 
 func (r *chatRepository) DeleteMessage(ctx context.Context, id uuid.UUID) error {
-	return r.db.WithContext(ctx).Delete(&model.Message{}, "id = ?", id).Error
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return deleteMessage(tx, id)
+	})
 }
 
 func (r *chatRepository) CreateChat(ctx context.Context, chat *model.Chat) error {
@@ -69,12 +72,26 @@ func (r *chatRepository) ListChats(ctx context.Context, userID uuid.UUID) ([]*mo
 	return chats, err
 }
 
+func (r *chatRepository) RenameChat(ctx context.Context, chatID uuid.UUID, name string) error {
+	return r.db.WithContext(ctx).
+		Model(&model.Chat{}).
+		Where("id = ?", chatID).
+		Updates(map[string]interface{}{"name": name, "updated_at": time.Now()}).Error
+}
+
 func (r *chatRepository) AddUserToChat(ctx context.Context, chatID, userID uuid.UUID) error {
-	return r.db.WithContext(ctx).Create(&model.ChatUser{
+	return r.AddMember(ctx, &model.ChatUser{
 		ChatID:   chatID,
 		UserID:   userID,
+		Role:     model.RoleMember,
 		JoinedAt: time.Now(),
-	}).Error
+	})
+}
+
+// AddMember adds a member to a chat. Adding someone who is already a member
+// changes nothing.
+func (r *chatRepository) AddMember(ctx context.Context, member *model.ChatUser) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(member).Error
 }
 
 func (r *chatRepository) RemoveUserFromChat(ctx context.Context, chatID, userID uuid.UUID) error {
@@ -82,3 +99,274 @@ func (r *chatRepository) RemoveUserFromChat(ctx context.Context, chatID, userID
 		Where("chat_id = ? AND user_id = ?", chatID, userID).
 		Delete(&model.ChatUser{}).Error
 }
+
+func (r *chatRepository) IsMember(ctx context.Context, chatID, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&model.ChatUser{}).
+		Where("chat_id = ? AND user_id = ?", chatID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *chatRepository) GetMember(ctx context.Context, chatID, userID uuid.UUID) (*model.ChatUser, error) {
+	var member model.ChatUser
+	err := r.db.WithContext(ctx).First(&member, "chat_id = ? AND user_id = ?", chatID, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &member, err
+}
+
+func (r *chatRepository) ListMembers(ctx context.Context, chatID uuid.UUID) ([]*model.ChatUser, error) {
+	var members []*model.ChatUser
+	err := r.db.WithContext(ctx).
+		Where("chat_id = ?", chatID).
+		Order("joined_at ASC").
+		Find(&members).Error
+	return members, err
+}
+
+func (r *chatRepository) UpdateMemberRole(ctx context.Context, chatID, userID uuid.UUID, role model.ChatRole) error {
+	return r.db.WithContext(ctx).
+		Model(&model.ChatUser{}).
+		Where("chat_id = ? AND user_id = ?", chatID, userID).
+		Update("role", role).Error
+}
+
+// TransferOwnership makes toID an owner and demotes fromID to admin
+func (r *chatRepository) TransferOwnership(ctx context.Context, chatID, fromID, toID uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.ChatUser{}).
+			Where("chat_id = ? AND user_id = ?", chatID, toID).
+			Update("role", model.RoleOwner).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&model.ChatUser{}).
+			Where("chat_id = ? AND user_id = ?", chatID, fromID).
+			Update("role", model.RoleAdmin).Error
+	})
+}
+
+// BanMember stores a ban, replacing any earlier one, and removes the user from
+// the chat. It reports whether the user was a member.
+func (r *chatRepository) BanMember(ctx context.Context, ban *model.ChatBan) (bool, error) {
+	removed := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "chat_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"banned_by", "reason", "expires_at", "created_at"}),
+		}).Create(ban).Error
+		if err != nil {
+			return err
+		}
+
+		result := tx.Where("chat_id = ? AND user_id = ?", ban.ChatID, ban.UserID).Delete(&model.ChatUser{})
+		removed = result.RowsAffected > 0
+		return result.Error
+	})
+	return removed, err
+}
+
+// GetBan returns the user's ban from a chat, or nil if they are not banned or
+// the ban has expired
+func (r *chatRepository) GetBan(ctx context.Context, chatID, userID uuid.UUID) (*model.ChatBan, error) {
+	var ban model.ChatBan
+	err := r.db.WithContext(ctx).
+		Where("chat_id = ? AND user_id = ?", chatID, userID).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		First(&ban).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &ban, err
+}
+
+// LiftBan removes a ban, reporting whether there was one
+func (r *chatRepository) LiftBan(ctx context.Context, chatID, userID uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Where("chat_id = ? AND user_id = ?", chatID, userID).
+		Delete(&model.ChatBan{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *chatRepository) GetUser(ctx context.Context, id uuid.UUID) (*model.User, error) {
+	var user model.User
+	err := r.db.WithContext(ctx).First(&user, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &user, err
+}
+
+func (r *chatRepository) GetDirectChat(ctx context.Context, directKey string) (*model.Chat, error) {
+	var chat model.Chat
+	err := r.db.WithContext(ctx).First(&chat, "direct_key = ?", directKey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &chat, err
+}
+
+// CreateDirectChat creates a direct message chat together with its members.
+// It reports false, creating nothing, if the pair already has one.
+func (r *chatRepository) CreateDirectChat(ctx context.Context, chat *model.Chat, memberIDs []uuid.UUID) (bool, error) {
+	created := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Two users opening the chat at once race on the direct_key index
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "direct_key"}},
+			DoNothing: true,
+		}).Create(chat)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		for _, userID := range memberIDs {
+			err := tx.Create(&model.ChatUser{
+				ChatID:   chat.ID,
+				UserID:   userID,
+				Role:     model.RoleMember,
+				JoinedAt: chat.CreatedAt,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		created = true
+		return nil
+	})
+	return created, err
+}
+
+// DirectChatPeers returns, per direct message chat of the user, the other
+// participant
+func (r *chatRepository) DirectChatPeers(ctx context.Context, userID uuid.UUID) (map[uuid.UUID]*model.User, error) {
+	var rows []struct {
+		ChatID uuid.UUID
+		model.User
+	}
+	err := r.db.WithContext(ctx).
+		Table("chat_users AS mine").
+		Select("mine.chat_id, users.*").
+		Joins("JOIN chats ON chats.id = mine.chat_id AND chats.kind = ?", model.KindDirect).
+		Joins("JOIN chat_users AS peer ON peer.chat_id = mine.chat_id AND peer.user_id <> mine.user_id").
+		Joins("JOIN users ON users.id = peer.user_id").
+		Where("mine.user_id = ?", userID).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make(map[uuid.UUID]*model.User, len(rows))
+	for i := range rows {
+		peers[rows[i].ChatID] = &rows[i].User
+	}
+	return peers, nil
+}
+
+func (r *chatRepository) CreateInvite(ctx context.Context, invite *model.ChatInvite) error {
+	return r.db.WithContext(ctx).Create(invite).Error
+}
+
+func (r *chatRepository) GetInviteByTokenHash(ctx context.Context, tokenHash string) (*model.ChatInvite, error) {
+	var invite model.ChatInvite
+	err := r.db.WithContext(ctx).First(&invite, "token_hash = ?", tokenHash).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &invite, err
+}
+
+func (r *chatRepository) ListInvites(ctx context.Context, chatID uuid.UUID) ([]*model.ChatInvite, error) {
+	var invites []*model.ChatInvite
+	err := r.db.WithContext(ctx).
+		Where("chat_id = ?", chatID).
+		Order("created_at DESC").
+		Find(&invites).Error
+	return invites, err
+}
+
+// RevokeInvite marks an invite revoked, reporting whether it was active
+func (r *chatRepository) RevokeInvite(ctx context.Context, chatID, inviteID uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.ChatInvite{}).
+		Where("id = ? AND chat_id = ? AND revoked_at IS NULL", inviteID, chatID).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// RedeemInvite uses up one use of an invite and adds the user to its chat.
+// It reports false, changing nothing, if the invite is revoked, expired or
+// used up.
+func (r *chatRepository) RedeemInvite(ctx context.Context, invite *model.ChatInvite, userID uuid.UUID) (bool, error) {
+	redeemed := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The conditional update keeps concurrent redemptions within max_uses
+		result := tx.Model(&model.ChatInvite{}).
+			Where("id = ? AND revoked_at IS NULL", invite.ID).
+			Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+			Where("max_uses = 0 OR uses < max_uses").
+			Update("uses", gorm.Expr("uses + 1"))
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		err := tx.Create(&model.ChatUser{
+			ChatID:   invite.ChatID,
+			UserID:   userID,
+			Role:     model.RoleMember,
+			JoinedAt: time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+		redeemed = true
+		return nil
+	})
+	return redeemed, err
+}
+
+// MarkRead stores a read pointer, only ever moving it forward. It reports
+// whether the pointer moved.
+func (r *chatRepository) MarkRead(ctx context.Context, read *model.ChatRead) (bool, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_read_message_id", "last_read_seq", "updated_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "chat_reads.last_read_seq < excluded.last_read_seq"},
+		}},
+	}).Create(read)
+	return result.RowsAffected > 0, result.Error
+}
+
+// UnreadCounts counts, per chat of the user, messages from others after their
+// read pointer, leaving out messages from hiddenSenders. Chats without unread
+// messages are omitted.
+func (r *chatRepository) UnreadCounts(ctx context.Context, userID uuid.UUID, hiddenSenders []uuid.UUID) (map[uuid.UUID]int64, error) {
+	var rows []struct {
+		ChatID uuid.UUID
+		Count  int64
+	}
+	query := r.db.WithContext(ctx).
+		Table("messages").
+		Select("messages.chat_id, COUNT(*) AS count").
+		Joins("JOIN chat_users ON chat_users.chat_id = messages.chat_id AND chat_users.user_id = ?", userID).
+		Joins("LEFT JOIN chat_reads ON chat_reads.chat_id = messages.chat_id AND chat_reads.user_id = chat_users.user_id").
+		Where("messages.seq > COALESCE(chat_reads.last_read_seq, 0)").
+		Where("messages.sender_id <> ? AND messages.deleted_at IS NULL", userID)
+	if len(hiddenSenders) > 0 {
+		query = query.Where("messages.sender_id NOT IN ?", hiddenSenders)
+	}
+	err := query.Group("messages.chat_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uuid.UUID]int64, len(rows))
+	for _, row := range rows {
+		counts[row.ChatID] = row.Count
+	}
+	return counts, nil
+}
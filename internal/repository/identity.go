@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"rtcs/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type IdentityRepository interface {
+	GetIdentity(ctx context.Context, provider, subject string) (*model.UserIdentity, error)
+	LinkIdentity(ctx context.Context, identity *model.UserIdentity) (bool, error)
+	CreateUserWithIdentity(ctx context.Context, user *model.User, identity *model.UserIdentity) (bool, error)
+}
+
+type identityRepository struct {
+	db *gorm.DB
+}
+
+func NewIdentityRepository(db *gorm.DB) IdentityRepository {
+	return &identityRepository{db: db}
+}
+
+func (r *identityRepository) GetIdentity(ctx context.Context, provider, subject string) (*model.UserIdentity, error) {
+	var identity model.UserIdentity
+	err := r.db.WithContext(ctx).First(&identity, "provider = ? AND subject = ?", provider, subject).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &identity, err
+}
+
+// LinkIdentity links a provider account to an existing user. It reports
+// false if the provider account is already linked.
+func (r *identityRepository) LinkIdentity(ctx context.Context, identity *model.UserIdentity) (bool, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "provider"}, {Name: "subject"}},
+		DoNothing: true,
+	}).Create(identity)
+	return result.RowsAffected > 0, result.Error
+}
+
+// CreateUserWithIdentity creates a user signing up through a provider
+// together with the link to the provider account. It reports false, creating
+// nothing, if the username or the provider account is taken.
+func (r *identityRepository) CreateUserWithIdentity(ctx context.Context, user *model.User, identity *model.UserIdentity) (bool, error) {
+	created := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// A password registration or another login may race for the address
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "username"}},
+			DoNothing: true,
+		}).Create(user)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		identity.UserID = user.ID
+		result = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "provider"}, {Name: "subject"}},
+			DoNothing: true,
+		}).Create(identity)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errIdentityTaken
+		}
+		created = true
+		return nil
+	})
+	if errors.Is(err, errIdentityTaken) {
+		return false, nil
+	}
+	return created, err
+}
+
+// errIdentityTaken rolls back CreateUserWithIdentity
+var errIdentityTaken = errors.New("identity already linked")
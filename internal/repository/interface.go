@@ -20,6 +20,37 @@ type Repository interface {
 	CreateChat(ctx context.Context, chat *model.Chat) error
 	GetChat(ctx context.Context, id uuid.UUID) (*model.Chat, error)
 	ListChats(ctx context.Context, userID uuid.UUID) ([]*model.Chat, error)
+	RenameChat(ctx context.Context, chatID uuid.UUID, name string) error
 	AddUserToChat(ctx context.Context, chatID, userID uuid.UUID) error
+	AddMember(ctx context.Context, member *model.ChatUser) error
 	RemoveUserFromChat(ctx context.Context, chatID, userID uuid.UUID) error
+	IsMember(ctx context.Context, chatID, userID uuid.UUID) (bool, error)
+
+	// Role methods
+	GetMember(ctx context.Context, chatID, userID uuid.UUID) (*model.ChatUser, error)
+	ListMembers(ctx context.Context, chatID uuid.UUID) ([]*model.ChatUser, error)
+	UpdateMemberRole(ctx context.Context, chatID, userID uuid.UUID, role model.ChatRole) error
+	TransferOwnership(ctx context.Context, chatID, fromID, toID uuid.UUID) error
+
+	// Ban methods
+	BanMember(ctx context.Context, ban *model.ChatBan) (bool, error)
+	GetBan(ctx context.Context, chatID, userID uuid.UUID) (*model.ChatBan, error)
+	LiftBan(ctx context.Context, chatID, userID uuid.UUID) (bool, error)
+
+	// Direct message methods
+	GetUser(ctx context.Context, id uuid.UUID) (*model.User, error)
+	GetDirectChat(ctx context.Context, directKey string) (*model.Chat, error)
+	CreateDirectChat(ctx context.Context, chat *model.Chat, memberIDs []uuid.UUID) (bool, error)
+	DirectChatPeers(ctx context.Context, userID uuid.UUID) (map[uuid.UUID]*model.User, error)
+
+	// Invite methods
+	CreateInvite(ctx context.Context, invite *model.ChatInvite) error
+	GetInviteByTokenHash(ctx context.Context, tokenHash string) (*model.ChatInvite, error)
+	ListInvites(ctx context.Context, chatID uuid.UUID) ([]*model.ChatInvite, error)
+	RevokeInvite(ctx context.Context, chatID, inviteID uuid.UUID) (bool, error)
+	RedeemInvite(ctx context.Context, invite *model.ChatInvite, userID uuid.UUID) (bool, error)
+
+	// Read pointer methods
+	MarkRead(ctx context.Context, read *model.ChatRead) (bool, error)
+	UnreadCounts(ctx context.Context, userID uuid.UUID, hiddenSenders []uuid.UUID) (map[uuid.UUID]int64, error)
 }
@@ -2,12 +2,13 @@ package repository
 
 import (
 	"context"
-	"time"
+	"errors"
 
 	"rtcs/internal/model"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // MessageRepository handles database operations for messages
@@ -22,50 +23,196 @@ func NewMessageRepository(db *gorm.DB) *MessageRepository {
 	}
 }
 
-// SaveMessage saves a message to the database
+// SaveMessage saves a message to the database, assigning it the next
+// sequence number of its chat
 func (r *MessageRepository) SaveMessage(ctx context.Context, message *model.Message) error {
-	return r.db.WithContext(ctx).Create(message).Error
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The row lock on the chat serialises concurrent senders
+		var seq int64
+		err := tx.Raw("UPDATE chats SET last_seq = last_seq + 1 WHERE id = ? RETURNING last_seq", message.ChatID).
+			Scan(&seq).Error
+		if err != nil {
+			return err
+		}
+		message.Seq = seq
+		return tx.Create(message).Error
+	})
 }
 
-// GetMessages retrieves messages for a chat
-func (r *MessageRepository) GetMessages(ctx context.Context, chatID uuid.UUID, limit int) ([]*model.Message, error) {
+// GetMessages retrieves up to limit messages of a chat, newest first. With
+// before set only older messages are returned; with after set, only the
+// oldest messages newer than it. Deleted messages are left out here and in the
+// other listings.
+func (r *MessageRepository) GetMessages(ctx context.Context, chatID uuid.UUID, before, after *model.MessageCursor, limit int) ([]*model.Message, error) {
+	var messages []*model.Message
+	query := r.db.WithContext(ctx).Where("chat_id = ? AND deleted_at IS NULL", chatID)
+	switch {
+	case after != nil:
+		query = query.Where("(created_at, id) > (?, ?)", after.CreatedAt, after.ID).
+			Order("created_at ASC, id ASC")
+	case before != nil:
+		query = query.Where("(created_at, id) < (?, ?)", before.CreatedAt, before.ID).
+			Order("created_at DESC, id DESC")
+	default:
+		query = query.Order("created_at DESC, id DESC")
+	}
+	if err := query.Limit(limit).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	if after != nil {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, nil
+}
+
+// GetMessagesAfter retrieves up to limit messages of a chat with a sequence
+// number greater than afterSeq, oldest first
+func (r *MessageRepository) GetMessagesAfter(ctx context.Context, chatID uuid.UUID, afterSeq int64, limit int) ([]*model.Message, error) {
 	var messages []*model.Message
 	err := r.db.WithContext(ctx).
-		Where("chat_id = ?", chatID).
-		Order("created_at DESC").
+		Where("chat_id = ? AND seq > ? AND deleted_at IS NULL", chatID, afterSeq).
+		Order("seq ASC").
 		Limit(limit).
 		Find(&messages).Error
 	return messages, err
 }
 
+// GetReplies retrieves up to limit replies in the thread rooted at parentID,
+// oldest first
+func (r *MessageRepository) GetReplies(ctx context.Context, parentID uuid.UUID, limit int) ([]*model.Message, error) {
+	var messages []*model.Message
+	err := r.db.WithContext(ctx).
+		Where("parent_id = ? AND deleted_at IS NULL", parentID).
+		Order("created_at ASC").
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// GetReplyStats returns reply counts and last reply times for the given
+// thread roots; roots without replies are left out
+func (r *MessageRepository) GetReplyStats(ctx context.Context, parentIDs []uuid.UUID) ([]*model.ReplyStats, error) {
+	var stats []*model.ReplyStats
+	if len(parentIDs) == 0 {
+		return stats, nil
+	}
+	err := r.db.WithContext(ctx).
+		Model(&model.Message{}).
+		Select("parent_id, COUNT(*) AS count, MAX(created_at) AS last_reply_at").
+		Where("parent_id IN ? AND deleted_at IS NULL", parentIDs).
+		Group("parent_id").
+		Scan(&stats).Error
+	return stats, err
+}
+
 // GetMessage retrieves a message by ID
 func (r *MessageRepository) GetMessage(ctx context.Context, messageID uuid.UUID) (*model.Message, error) {
 	var message model.Message
 	err := r.db.WithContext(ctx).First(&message, "id = ?", messageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &message, nil
 }
 
+// EditMessage stores the new text of a message together with the revision it
+// replaces
+func (r *MessageRepository) EditMessage(ctx context.Context, message *model.Message, edit *model.MessageEdit) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(edit).Error; err != nil {
+			return err
+		}
+		return tx.Model(message).
+			Updates(map[string]interface{}{"text": message.Text, "updated_at": message.UpdatedAt}).Error
+	})
+}
+
+// AddReaction stores a reaction, reporting whether it was new
+func (r *MessageRepository) AddReaction(ctx context.Context, reaction *model.MessageReaction) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(reaction)
+	return result.RowsAffected > 0, result.Error
+}
+
+// RemoveReaction deletes a reaction, reporting whether it existed
+func (r *MessageRepository) RemoveReaction(ctx context.Context, reaction *model.MessageReaction) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Where("message_id = ? AND user_id = ? AND emoji = ?", reaction.MessageID, reaction.UserID, reaction.Emoji).
+		Delete(&model.MessageReaction{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetReactionCounts returns per-emoji reaction counts for the given messages
+func (r *MessageRepository) GetReactionCounts(ctx context.Context, messageIDs []uuid.UUID) ([]*model.ReactionCount, error) {
+	var counts []*model.ReactionCount
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+	err := r.db.WithContext(ctx).
+		Model(&model.MessageReaction{}).
+		Select("message_id, emoji, COUNT(*) AS count").
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, emoji").
+		Order("MIN(created_at)").
+		Scan(&counts).Error
+	return counts, err
+}
+
+// GetMember retrieves a user's membership of a chat, or nil if they are not
+// a member
+func (r *MessageRepository) GetMember(ctx context.Context, chatID, userID uuid.UUID) (*model.ChatUser, error) {
+	var member model.ChatUser
+	err := r.db.WithContext(ctx).First(&member, "chat_id = ? AND user_id = ?", chatID, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &member, err
+}
+
 // DeleteMessage deletes a message
 func (r *MessageRepository) DeleteMessage(ctx context.Context, messageID uuid.UUID) error {
-	return r.db.WithContext(ctx).Delete(&model.Message{}, "id = ?", messageID).Error
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return deleteMessage(tx, messageID)
+	})
 }
 
-// CreateChatIfNotExists creates a new chat if it doesn't exist
-func (r *MessageRepository) CreateChatIfNotExists(ctx context.Context, chat *model.Chat) error {
-	result := r.db.WithContext(ctx).FirstOrCreate(chat, model.Chat{ID: chat.ID})
-	return result.Error
-}
+// deleteMessage deletes a message, first moving read pointers off it. A
+// pointer moves to the message before it in the chat, keeping its sequence
+// number so unread counts do not change; without an earlier message the
+// pointer is dropped, which reads the same.
+func deleteMessage(tx *gorm.DB, messageID uuid.UUID) error {
+	var message model.Message
+	err := tx.Select("id", "chat_id", "seq").First(&message, "id = ?", messageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 
-// AddUserToChat adds a user to a chat if they're not already a member
-func (r *MessageRepository) AddUserToChat(ctx context.Context, chatID, userID uuid.UUID) error {
-	chatUser := &model.ChatUser{
-		ChatID:   chatID,
-		UserID:   userID,
-		JoinedAt: time.Now(),
+	var previous model.Message
+	err = tx.Select("id").
+		Where("chat_id = ? AND seq < ?", message.ChatID, message.Seq).
+		Order("seq DESC").
+		Take(&previous).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		err = tx.Where("last_read_message_id = ?", messageID).Delete(&model.ChatRead{}).Error
+	case err == nil:
+		err = tx.Model(&model.ChatRead{}).
+			Where("last_read_message_id = ?", messageID).
+			Update("last_read_message_id", previous.ID).Error
 	}
-	result := r.db.WithContext(ctx).FirstOrCreate(chatUser, model.ChatUser{ChatID: chatID, UserID: userID})
-	return result.Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Delete(&model.Message{}, "id = ?", messageID).Error
 }
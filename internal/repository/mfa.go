@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"rtcs/internal/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type MFARepository interface {
+	GetTOTP(ctx context.Context, userID uuid.UUID) (*model.UserTOTP, error)
+	SaveTOTP(ctx context.Context, enrollment *model.UserTOTP) (bool, error)
+	ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64, codes []*model.RecoveryCode) (bool, error)
+	UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error)
+	DeleteTOTP(ctx context.Context, userID uuid.UUID) error
+}
+
+type mfaRepository struct {
+	db *gorm.DB
+}
+
+func NewMFARepository(db *gorm.DB) MFARepository {
+	return &mfaRepository{db: db}
+}
+
+func (r *mfaRepository) GetTOTP(ctx context.Context, userID uuid.UUID) (*model.UserTOTP, error) {
+	var enrollment model.UserTOTP
+	err := r.db.WithContext(ctx).First(&enrollment, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &enrollment, err
+}
+
+// SaveTOTP stores a new enrollment, replacing one that was never confirmed.
+// It reports false, changing nothing, if the user already has 2FA on.
+func (r *mfaRepository) SaveTOTP(ctx context.Context, enrollment *model.UserTOTP) (bool, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"secret", "confirmed_at", "last_used_step", "created_at"}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "user_totps.confirmed_at IS NULL"}}},
+	}).Create(enrollment)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ConfirmTOTP turns 2FA on, marking step as used and replacing the user's
+// recovery codes. It reports false if the enrollment is missing or was
+// already confirmed.
+func (r *mfaRepository) ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64, codes []*model.RecoveryCode) (bool, error) {
+	confirmed := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.UserTOTP{}).
+			Where("user_id = ? AND confirmed_at IS NULL", userID).
+			Updates(map[string]interface{}{"confirmed_at": time.Now(), "last_used_step": step})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&codes).Error; err != nil {
+			return err
+		}
+		confirmed = true
+		return nil
+	})
+	return confirmed, err
+}
+
+// UseTOTPStep records that the code for step was used. It reports false if
+// that step, or a later one, was used before.
+func (r *mfaRepository) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.UserTOTP{}).
+		Where("user_id = ? AND confirmed_at IS NOT NULL AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	return result.RowsAffected > 0, result.Error
+}
+
+// UseRecoveryCode marks a recovery code used, reporting false if the user has
+// no such unused code
+func (r *mfaRepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteTOTP turns 2FA off, removing the enrollment and recovery codes
+func (r *mfaRepository) DeleteTOTP(ctx context.Context, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&model.UserTOTP{}).Error
+	})
+}
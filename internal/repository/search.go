@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+
+	"rtcs/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// Options for ts_headline; matches are wrapped in <mark> tags
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5"
+
+// MessageSearchRepository searches messages with Postgres full-text search,
+// using the search_vector column from migrations/010_add_message_search.sql
+type MessageSearchRepository struct {
+	db *gorm.DB
+}
+
+// NewMessageSearchRepository creates a new message search repository
+func NewMessageSearchRepository(db *gorm.DB) *MessageSearchRepository {
+	return &MessageSearchRepository{
+		db: db,
+	}
+}
+
+type searchRow struct {
+	model.Message `gorm:"embedded"`
+	Rank          float64
+	Snippet       string
+}
+
+// SearchMessages returns the messages matching query, best match first
+func (r *MessageSearchRepository) SearchMessages(ctx context.Context, query *model.SearchQuery) ([]*model.SearchResult, error) {
+	tx := r.db.WithContext(ctx).
+		Table("messages").
+		Select("messages.*, ts_rank(messages.search_vector, q) AS rank, "+
+			"ts_headline('english', messages.text, q, ?) AS snippet", headlineOptions).
+		Joins("CROSS JOIN websearch_to_tsquery('english', ?) AS q", query.Text).
+		Joins("JOIN chat_users ON chat_users.chat_id = messages.chat_id AND chat_users.user_id = ?", query.UserID).
+		Where("messages.search_vector @@ q AND messages.deleted_at IS NULL")
+
+	if query.ChatID != nil {
+		tx = tx.Where("messages.chat_id = ?", *query.ChatID)
+	}
+	if query.SenderID != nil {
+		tx = tx.Where("messages.sender_id = ?", *query.SenderID)
+	}
+	if query.From != nil {
+		tx = tx.Where("messages.created_at >= ?", *query.From)
+	}
+	if query.To != nil {
+		tx = tx.Where("messages.created_at < ?", *query.To)
+	}
+
+	var rows []*searchRow
+	err := tx.Order("rank DESC, messages.created_at DESC").
+		Limit(query.Limit).
+		Offset(query.Offset).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*model.SearchResult, len(rows))
+	for i, row := range rows {
+		message := row.Message
+		results[i] = &model.SearchResult{Message: &message, Snippet: row.Snippet, Rank: row.Rank}
+	}
+	return results, nil
+}
@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"rtcs/internal/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type TokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*model.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, used, next *model.RefreshToken) (bool, error)
+	RevokeTokenFamily(ctx context.Context, familyID uuid.UUID) error
+	RevokeUserTokens(ctx context.Context, userID, keepFamilyID uuid.UUID) ([]uuid.UUID, error)
+
+	CreatePasswordReset(ctx context.Context, reset *model.PasswordResetToken) error
+	UsePasswordReset(ctx context.Context, tokenHash string) (*model.PasswordResetToken, error)
+}
+
+type tokenRepository struct {
+	db *gorm.DB
+}
+
+func NewTokenRepository(db *gorm.DB) TokenRepository {
+	return &tokenRepository{db: db}
+}
+
+func (r *tokenRepository) CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	return r.db.WithContext(ctx).Create(token).Error
+}
+
+func (r *tokenRepository) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	var token model.RefreshToken
+	err := r.db.WithContext(ctx).First(&token, "token_hash = ?", tokenHash).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &token, err
+}
+
+// RotateRefreshToken revokes a used token and stores its replacement. It
+// reports false, changing nothing, if the used token was already revoked,
+// e.g. by a concurrent refresh.
+func (r *tokenRepository) RotateRefreshToken(ctx context.Context, used, next *model.RefreshToken) (bool, error) {
+	rotated := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", used.ID).
+			Updates(map[string]interface{}{"revoked_at": time.Now(), "replaced_by": next.ID})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		if err := tx.Create(next).Error; err != nil {
+			return err
+		}
+		rotated = true
+		return nil
+	})
+	return rotated, err
+}
+
+// RevokeTokenFamily revokes every token descended from the same login
+func (r *tokenRepository) RevokeTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	return r.db.WithContext(ctx).
+		Model(&model.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeUserTokens revokes every session of a user except the one in
+// keepFamilyID (uuid.Nil keeps none) and returns the families it revoked
+func (r *tokenRepository) RevokeUserTokens(ctx context.Context, userID, keepFamilyID uuid.UUID) ([]uuid.UUID, error) {
+	var families []uuid.UUID
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.RefreshToken{}).
+			Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userID, keepFamilyID).
+			Distinct().
+			Pluck("family_id", &families).Error; err != nil {
+			return err
+		}
+		if len(families) == 0 {
+			return nil
+		}
+		return tx.Model(&model.RefreshToken{}).
+			Where("family_id IN ? AND revoked_at IS NULL", families).
+			Update("revoked_at", time.Now()).Error
+	})
+	return families, err
+}
+
+func (r *tokenRepository) CreatePasswordReset(ctx context.Context, reset *model.PasswordResetToken) error {
+	return r.db.WithContext(ctx).Create(reset).Error
+}
+
+// UsePasswordReset marks a reset token used and returns it, or returns nil if
+// it does not exist, has expired or was already used. Any other outstanding
+// reset tokens of the user are used up with it.
+func (r *tokenRepository) UsePasswordReset(ctx context.Context, tokenHash string) (*model.PasswordResetToken, error) {
+	var reset *model.PasswordResetToken
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var found model.PasswordResetToken
+		err := tx.First(&found, "token_hash = ?", tokenHash).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		result := tx.Model(&model.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL AND expires_at > ?", found.ID, now).
+			Update("used_at", now)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		if err := tx.Model(&model.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", found.UserID).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		found.UsedAt = &now
+		reset = &found
+		return nil
+	})
+	return reset, err
+}
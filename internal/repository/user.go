@@ -3,15 +3,29 @@ package repository
 import (
 	"context"
 	"rtcs/internal/model"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserRepository interface {
 	Create(ctx context.Context, user *model.User) error
 	GetByID(ctx context.Context, id uuid.UUID) (*model.User, error)
 	GetByUsername(ctx context.Context, username string) (*model.User, error)
+	UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error
+	MarkEmailVerified(ctx context.Context, id uuid.UUID) (bool, error)
+	UpdateProfile(ctx context.Context, user *model.User) error
+	Search(ctx context.Context, prefix string, limit int) ([]*model.User, error)
+	GetProfiles(ctx context.Context, ids []uuid.UUID) ([]*model.UserProfile, error)
+
+	// Block methods
+	Block(ctx context.Context, block *model.UserBlock) (bool, error)
+	Unblock(ctx context.Context, blockerID, blockedID uuid.UUID) (bool, error)
+	ListBlocks(ctx context.Context, blockerID uuid.UUID) ([]*model.UserBlock, error)
+	IsBlockedEitherWay(ctx context.Context, a, b uuid.UUID) (bool, error)
 }
 
 type userRepository struct {
@@ -49,3 +63,94 @@ func (r *userRepository) GetByUsername(ctx context.Context, username string) (*m
 	}
 	return &user, nil
 }
+
+func (r *userRepository) UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error {
+	return r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ?", id).
+		Update("password", passwordHash).Error
+}
+
+// MarkEmailVerified records that the user verified their email, reporting
+// whether it was unverified before
+func (r *userRepository) MarkEmailVerified(ctx context.Context, id uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ? AND email_verified = ? AND deleted_at IS NULL", id, false).
+		Updates(map[string]interface{}{"email_verified": true, "updated_at": time.Now()})
+	return result.RowsAffected > 0, result.Error
+}
+
+// UpdateProfile saves the profile fields of a user
+func (r *userRepository) UpdateProfile(ctx context.Context, user *model.User) error {
+	return r.db.WithContext(ctx).
+		Model(user).
+		Select("display_name", "bio", "avatar", "timezone", "status_text", "updated_at").
+		Updates(user).Error
+}
+
+// Search returns users whose username or display name starts with prefix,
+// ignoring case, ordered by username
+func (r *userRepository) Search(ctx context.Context, prefix string, limit int) ([]*model.User, error) {
+	pattern := likeEscaper.Replace(strings.ToLower(prefix)) + "%"
+	var users []*model.User
+	err := r.db.WithContext(ctx).
+		Where("deleted_at IS NULL").
+		Where("lower(username) LIKE ? OR lower(display_name) LIKE ?", pattern, pattern).
+		Order("username").
+		Limit(limit).
+		Find(&users).Error
+	return users, err
+}
+
+// GetProfiles returns the profiles of the given users; unknown IDs are
+// skipped
+func (r *userRepository) GetProfiles(ctx context.Context, ids []uuid.UUID) ([]*model.UserProfile, error) {
+	var profiles []*model.UserProfile
+	if len(ids) == 0 {
+		return profiles, nil
+	}
+	err := r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Select("id", "username", "display_name", "avatar", "status_text").
+		Where("id IN ?", ids).
+		Find(&profiles).Error
+	return profiles, err
+}
+
+// Block stores a block, reporting false if it already existed
+func (r *userRepository) Block(ctx context.Context, block *model.UserBlock) (bool, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(block)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Unblock removes a block, reporting whether there was one
+func (r *userRepository) Unblock(ctx context.Context, blockerID, blockedID uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).
+		Delete(&model.UserBlock{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListBlocks returns the users a user has blocked, most recent first
+func (r *userRepository) ListBlocks(ctx context.Context, blockerID uuid.UUID) ([]*model.UserBlock, error) {
+	var blocks []*model.UserBlock
+	err := r.db.WithContext(ctx).
+		Where("blocker_id = ?", blockerID).
+		Order("created_at DESC").
+		Find(&blocks).Error
+	return blocks, err
+}
+
+// IsBlockedEitherWay reports whether either user has blocked the other
+func (r *userRepository) IsBlockedEitherWay(ctx context.Context, a, b uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&model.UserBlock{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", a, b, b, a).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// likeEscaper escapes the LIKE wildcards in user input
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
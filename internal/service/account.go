@@ -0,0 +1,384 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"rtcs/internal/middleware"
+	"rtcs/internal/model"
+	"rtcs/internal/repository"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MessagePolicy decides what happens to a user's messages when they delete
+// their account
+type MessagePolicy string
+
+const (
+	AnonymizeMessages MessagePolicy = "anonymize" // Kept, shown as sent by a deleted user
+	DeleteMessages    MessagePolicy = "delete"    // Emptied and marked deleted
+)
+
+// Valid reports whether p is a known policy
+func (p MessagePolicy) Valid() bool {
+	return p == AnonymizeMessages || p == DeleteMessages
+}
+
+// Data export timing
+const (
+	// DataExportTTL is how long a finished export can be downloaded
+	DataExportTTL = 7 * 24 * time.Hour
+	// exportPollInterval is how often workers look for exports queued on
+	// other nodes and drop expired ones
+	exportPollInterval = 30 * time.Second
+	// exportStaleAfter is how long an export may run before another worker
+	// assumes its worker died and takes it over
+	exportStaleAfter = 15 * time.Minute
+	// exportBatchSize is how many messages are read at once while exporting
+	exportBatchSize = 500
+)
+
+// Account errors
+var (
+	ErrExportNotFound = errors.New("no data export found")
+	ErrExportNotReady = errors.New("data export is not ready yet")
+)
+
+// AccountService handles account deletion and personal data exports
+type AccountService struct {
+	users    repository.UserRepository
+	accounts repository.AccountRepository
+	cache    MessageCache
+	denylist TokenDenylist
+	limiter  LoginLimiter
+	listener MembershipListener
+	policy   MessagePolicy
+	wake     chan struct{}
+}
+
+// NewAccountService creates a new account service. Messages of deleted
+// accounts are anonymized until SetMessagePolicy is called. A nil denylist
+// leaves access tokens of deleted accounts valid until they expire.
+func NewAccountService(users repository.UserRepository, accounts repository.AccountRepository, cache MessageCache, denylist TokenDenylist) *AccountService {
+	return &AccountService{
+		users:    users,
+		accounts: accounts,
+		cache:    cache,
+		denylist: denylist,
+		policy:   AnonymizeMessages,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// SetMessagePolicy sets what happens to the messages of deleted accounts
+func (s *AccountService) SetMessagePolicy(policy MessagePolicy) {
+	s.policy = policy
+}
+
+// SetLoginLimiter lets deletion clear the failed login count of the account
+func (s *AccountService) SetLoginLimiter(limiter LoginLimiter) {
+	s.limiter = limiter
+}
+
+// SetMembershipListener registers the listener notified about the chats a
+// deleted user leaves
+func (s *AccountService) SetMembershipListener(listener MembershipListener) {
+	s.listener = listener
+}
+
+// DeleteAccount deletes the caller's account after checking their password.
+// The account is soft-deleted and stripped of personal data, its messages are
+// handled per the message policy, and every session, including the one in
+// claims, is revoked.
+func (s *AccountService) DeleteAccount(ctx context.Context, userID uuid.UUID, claims *middleware.Claims, password string) error {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.DeletedAt != nil {
+		return ErrUserNotFound
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return ErrWrongPassword
+	}
+
+	deletion, err := s.accounts.DeleteAccount(ctx, userID, s.policy == DeleteMessages)
+	if err != nil {
+		return err
+	}
+	if deletion == nil {
+		// Deleted by a concurrent request
+		return ErrUserNotFound
+	}
+
+	if s.listener != nil {
+		for _, chatID := range deletion.LeftChatIDs {
+			s.listener.ChatLeft(chatID, userID)
+		}
+	}
+	// The account is gone either way; report what could not be cleaned up
+	return errors.Join(s.revokeSessions(ctx, deletion, claims), s.purgeCache(ctx, deletion))
+}
+
+// revokeSessions refuses the access tokens still held by a deleted account
+func (s *AccountService) revokeSessions(ctx context.Context, deletion *model.AccountDeletion, claims *middleware.Claims) error {
+	if s.denylist == nil {
+		return nil
+	}
+	// None outlives AccessTokenTTL from now
+	expiresAt := time.Now().Add(middleware.AccessTokenTTL)
+	for _, family := range deletion.TokenFamilyIDs {
+		if err := s.denylist.Revoke(ctx, family.String(), expiresAt); err != nil {
+			return err
+		}
+	}
+	// Tokens issued before sessions were tracked only have their own ID
+	if claims != nil && claims.ID != "" && claims.ExpiresAt != nil {
+		return s.denylist.Revoke(ctx, claims.ID, claims.ExpiresAt.Time)
+	}
+	return nil
+}
+
+// purgeCache drops the Redis entries holding a deleted account's data
+func (s *AccountService) purgeCache(ctx context.Context, deletion *model.AccountDeletion) error {
+	for _, chatID := range deletion.ChatIDs {
+		if err := s.cache.DeleteChatPages(ctx, chatID.String()); err != nil {
+			return err
+		}
+	}
+	for _, messageID := range deletion.MessageIDs {
+		if err := s.cache.DeleteMessage(ctx, messageID.String()); err != nil {
+			return err
+		}
+	}
+	if s.limiter != nil {
+		return s.limiter.RecordSuccess(ctx, deletion.Username, "")
+	}
+	return nil
+}
+
+// RequestExport queues an export of the user's data. If one is already
+// queued or running, that one is returned instead.
+func (s *AccountService) RequestExport(ctx context.Context, userID uuid.UUID) (*model.DataExport, error) {
+	latest, err := s.accounts.GetLatestExport(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil && (latest.Status == model.ExportPending || latest.Status == model.ExportRunning) {
+		return latest, nil
+	}
+
+	export := &model.DataExport{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Status:    model.ExportPending,
+		CreatedAt: time.Now(),
+	}
+	if err := s.accounts.CreateExport(ctx, export); err != nil {
+		return nil, err
+	}
+
+	// Start on it right away if this node's worker is idle
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return export, nil
+}
+
+// GetExport returns the user's most recent export that has not expired
+func (s *AccountService) GetExport(ctx context.Context, userID uuid.UUID) (*model.DataExport, error) {
+	export, err := s.accounts.GetLatestExport(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if export == nil || (export.ExpiresAt != nil && !export.ExpiresAt.After(time.Now())) {
+		return nil, ErrExportNotFound
+	}
+	return export, nil
+}
+
+// ExportArchive returns the user's most recent export with its zip archive.
+// It fails with ErrExportNotReady while the export is still being built or if
+// it failed.
+func (s *AccountService) ExportArchive(ctx context.Context, userID uuid.UUID) (*model.DataExport, []byte, error) {
+	export, err := s.GetExport(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if export.Status != model.ExportReady {
+		return export, nil, ErrExportNotReady
+	}
+	archive, err := s.accounts.GetExportArchive(ctx, export.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if archive == nil {
+		// Expired between the two reads
+		return nil, nil, ErrExportNotFound
+	}
+	return export, archive, nil
+}
+
+// RunExports builds queued exports until ctx is cancelled. Every node may
+// run it; each export is claimed by one worker.
+func (s *AccountService) RunExports(ctx context.Context) {
+	ticker := time.NewTicker(exportPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for s.processNextExport(ctx) {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-ticker.C:
+			if n, err := s.accounts.DeleteExpiredExports(ctx, time.Now()); err != nil {
+				log.Printf("Error deleting expired data exports: %v", err)
+			} else if n > 0 {
+				log.Printf("Deleted %d expired data exports", n)
+			}
+		}
+	}
+}
+
+// processNextExport builds one queued export, reporting whether there was one
+func (s *AccountService) processNextExport(ctx context.Context) bool {
+	export, err := s.accounts.ClaimExport(ctx, time.Now().Add(-exportStaleAfter))
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Error claiming data export: %v", err)
+		}
+		return false
+	}
+	if export == nil {
+		return false
+	}
+
+	archive, err := s.BuildExport(ctx, export.UserID)
+	if err != nil {
+		log.Printf("Error building data export %s: %v", export.ID, err)
+		if err := s.accounts.FailExport(ctx, export.ID, "failed to collect data"); err != nil {
+			log.Printf("Error marking data export %s failed: %v", export.ID, err)
+		}
+		return true
+	}
+	if err := s.accounts.CompleteExport(ctx, export.ID, archive, time.Now().Add(DataExportTTL)); err != nil {
+		log.Printf("Error saving data export %s: %v", export.ID, err)
+	}
+	return true
+}
+
+// exportedMembership is a chat membership as it appears in an export
+type exportedMembership struct {
+	ChatID   uuid.UUID      `json:"chat_id"`
+	ChatName string         `json:"chat_name,omitempty"`
+	Kind     model.ChatKind `json:"kind,omitempty"`
+	Role     model.ChatRole `json:"role"`
+	JoinedAt time.Time      `json:"joined_at"`
+}
+
+// BuildExport returns a zip archive of the user's profile, chat memberships
+// and the messages they wrote, each as a JSON file
+func (s *AccountService) BuildExport(ctx context.Context, userID uuid.UUID) ([]byte, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	members, err := s.accounts.ListMemberships(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	memberships := make([]exportedMembership, len(members))
+	for i, member := range members {
+		memberships[i] = exportedMembership{
+			ChatID:   member.ChatID,
+			Role:     member.Role,
+			JoinedAt: member.JoinedAt,
+		}
+		if member.Chat != nil {
+			memberships[i].ChatName = member.Chat.Name
+			memberships[i].Kind = member.Chat.Kind
+		}
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	if err := writeJSONFile(archive, "profile.json", user); err != nil {
+		return nil, err
+	}
+	if err := writeJSONFile(archive, "memberships.json", memberships); err != nil {
+		return nil, err
+	}
+	file, err := archive.Create("messages.json")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.writeMessages(ctx, file, userID); err != nil {
+		return nil, err
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeMessages writes the user's messages as a JSON array, a batch at a time
+func (s *AccountService) writeMessages(ctx context.Context, w io.Writer, userID uuid.UUID) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	var after *model.MessageCursor
+	first := true
+	for {
+		messages, err := s.accounts.ListUserMessages(ctx, userID, after, exportBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			data, err := json.Marshal(message)
+			if err != nil {
+				return err
+			}
+			if !first {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			first = false
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		if len(messages) < exportBatchSize {
+			break
+		}
+		last := messages[len(messages)-1]
+		after = &model.MessageCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+func writeJSONFile(archive *zip.Writer, name string, value interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
@@ -3,61 +3,318 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"rtcs/internal/metrics"
 	"rtcs/internal/middleware"
 	"rtcs/internal/model"
+	"rtcs/internal/notify"
+	"rtcs/internal/oidc"
 	"rtcs/internal/repository"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// RefreshTokenTTL is how long a refresh token may be used before the user has
+// to log in again
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+// PasswordResetTTL is how long a password reset link stays valid
+const PasswordResetTTL = time.Hour
+
+// Auth errors
+var (
+	ErrInvalidCredentials  = errors.New("invalid credentials")
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used; all sessions from that login have been revoked")
+	ErrTooManyAttempts     = errors.New("too many failed login attempts")
+	ErrWrongPassword       = errors.New("current password is incorrect")
+	ErrInvalidResetToken   = errors.New("password reset token is invalid, expired or already used")
+)
+
+// LoginThrottledError is returned while logins for a username or from an IP
+// are locked after repeated failures. It matches ErrTooManyAttempts.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LoginThrottledError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// LoginLimiter tracks failed logins per username and per client IP
+type LoginLimiter interface {
+	// Check returns how long logins are still locked, zero if they are not,
+	// and whether the username or the IP is the one locked
+	Check(ctx context.Context, username, ip string) (time.Duration, string, error)
+	RecordFailure(ctx context.Context, username, ip string) error
+	RecordSuccess(ctx context.Context, username, ip string) error
+}
+
+// TokenDenylist revokes access tokens by ID until they expire
+type TokenDenylist interface {
+	Revoke(ctx context.Context, tokenID string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
 // AuthService handles user authentication
 type AuthService struct {
-	userRepo repository.UserRepository
+	userRepo  repository.UserRepository
+	tokenRepo repository.TokenRepository
+	mfaRepo   repository.MFARepository
+	denylist  TokenDenylist
+	limiter   LoginLimiter
+	notifier  notify.Notifier
+	policy    PasswordPolicy
+	publicURL string
+	// unverified lists what users may not do before verifying their email
+	unverified UnverifiedPolicy
+
+	// OpenID Connect login, off until SetOIDC is called
+	identities repository.IdentityRepository
+	oidcStates OIDCStateStore
+	providers  map[string]*oidc.Provider
 }
 
-// NewAuthService creates a new authentication service
-func NewAuthService(userRepo repository.UserRepository) *AuthService {
+// NewAuthService creates a new authentication service. A nil denylist turns
+// access token revocation off. Notifications go to the log until SetNotifier
+// is called.
+func NewAuthService(userRepo repository.UserRepository, tokenRepo repository.TokenRepository, denylist TokenDenylist) *AuthService {
 	return &AuthService{
-		userRepo: userRepo,
+		userRepo:  userRepo,
+		tokenRepo: tokenRepo,
+		denylist:  denylist,
+		notifier:  notify.NewLogOutbox(),
+		policy:    DefaultPasswordPolicy,
+		publicURL: "http://localhost:8080",
 	}
 }
 
+// SetLoginLimiter turns on brute-force protection for Login
+func (s *AuthService) SetLoginLimiter(limiter LoginLimiter) {
+	s.limiter = limiter
+}
+
+// SetMFARepository turns on two-factor authentication
+func (s *AuthService) SetMFARepository(mfaRepo repository.MFARepository) {
+	s.mfaRepo = mfaRepo
+}
+
+// SetNotifier sets how password reset and email verification links reach
+// users
+func (s *AuthService) SetNotifier(notifier notify.Notifier) {
+	s.notifier = notifier
+}
+
+// SetPasswordPolicy sets the policy new passwords must meet
+func (s *AuthService) SetPasswordPolicy(policy PasswordPolicy) {
+	s.policy = policy
+}
+
+// SetPublicURL sets the server address used in links sent to users
+func (s *AuthService) SetPublicURL(publicURL string) {
+	s.publicURL = strings.TrimRight(publicURL, "/")
+}
+
+// SetUnverifiedPolicy sets what users may not do until they verify their
+// email. Of these, AuthService enforces RestrictLogin.
+func (s *AuthService) SetUnverifiedPolicy(policy UnverifiedPolicy) {
+	s.unverified = policy
+}
+
 // LoginRequest represents the login request body
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
-// LoginResponse represents the login response
-type LoginResponse struct {
-	Token string `json:"token"`
+// TokenPair is a short-lived access token together with the refresh token
+// used to renew it
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // Access token lifetime in seconds
 }
 
-// Login authenticates a user and returns a JWT token
-func (s *AuthService) Login(ctx context.Context, username, password string) (string, error) {
-	// Get user by username
-	user, err := s.userRepo.GetByUsername(ctx, username)
-	if err != nil || user == nil {
-		return "", errors.New("invalid credentials")
+// Login authenticates a user and starts a new session. ip is the client's
+// address, used to throttle guessing across accounts; it may be empty.
+func (s *AuthService) Login(ctx context.Context, username, password, ip string) (*TokenPair, error) {
+	if s.limiter != nil {
+		retryAfter, scope, err := s.limiter.Check(ctx, username, ip)
+		if err != nil {
+			return nil, err
+		}
+		if retryAfter > 0 {
+			metrics.LoginBlockedTotal.WithLabelValues(scope).Inc()
+			return nil, &LoginThrottledError{RetryAfter: retryAfter}
+		}
 	}
 
-	// Compare password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", errors.New("invalid credentials")
+	user, err := s.checkPassword(ctx, username, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		metrics.LoginFailuresTotal.Inc()
+		if s.limiter != nil {
+			if err := s.limiter.RecordFailure(ctx, username, ip); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !user.EmailVerified && s.unverified.Restricts(RestrictLogin) {
+		return nil, ErrEmailNotVerified
+	}
+
+	// With 2FA on the failures are only cleared once the second factor is
+	// right too, so the password cannot be used to reset the count
+	mfa, err := s.mfaEnabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa {
+		token, err := middleware.GenerateMFAToken(user.ID.String())
+		if err != nil {
+			return nil, err
+		}
+		return nil, &MFARequiredError{Token: token, ExpiresIn: int64(middleware.MFATokenTTL / time.Second)}
+	}
+
+	if s.limiter != nil {
+		if err := s.limiter.RecordSuccess(ctx, username, ip); err != nil {
+			return nil, err
+		}
+	}
+	return s.startSession(ctx, user.ID)
+}
+
+// startSession issues the first token pair of a new session
+func (s *AuthService) startSession(ctx context.Context, userID uuid.UUID) (*TokenPair, error) {
+	// Each login starts a new refresh token family
+	refresh, token, err := newRefreshToken(userID, uuid.New())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.tokenRepo.CreateRefreshToken(ctx, refresh); err != nil {
+		return nil, err
+	}
+
+	return issueTokenPair(refresh, token)
+}
+
+// Refresh exchanges a refresh token for a new token pair. The refresh token
+// is used up; presenting it again is treated as theft and revokes every token
+// of its family.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	used, err := s.tokenRepo.GetRefreshTokenByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if used == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if used.RevokedAt != nil {
+		return nil, s.revokeReusedFamily(ctx, used)
+	}
+	if !used.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	next, token, err := newRefreshToken(used.UserID, used.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := s.tokenRepo.RotateRefreshToken(ctx, used, next)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// Another request used the token first
+		return nil, s.revokeReusedFamily(ctx, used)
+	}
+
+	return issueTokenPair(next, token)
+}
+
+// Logout revokes the access token described by claims and, if given, the
+// session of the refresh token
+func (s *AuthService) Logout(ctx context.Context, userID uuid.UUID, claims *middleware.Claims, refreshToken string) error {
+	if s.denylist != nil && claims != nil && claims.ID != "" && claims.ExpiresAt != nil {
+		if err := s.denylist.Revoke(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+			return err
+		}
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+	refresh, err := s.tokenRepo.GetRefreshTokenByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		return err
 	}
+	if refresh == nil || refresh.UserID != userID {
+		return ErrInvalidRefreshToken
+	}
+	if err := s.tokenRepo.RevokeTokenFamily(ctx, refresh.FamilyID); err != nil {
+		return err
+	}
+	return s.revokeSessionAccess(ctx, refresh.FamilyID)
+}
 
-	// Generate token
-	token, err := middleware.GenerateToken(user.ID.String())
+// checkPassword returns the user if the password matches and
+// ErrInvalidCredentials if the user does not exist or it does not
+func (s *AuthService) checkPassword(ctx context.Context, username, password string) (*model.User, error) {
+	user, err := s.userRepo.GetByUsername(ctx, username)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	if user == nil {
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
 
-	return token, nil
+func (s *AuthService) revokeReusedFamily(ctx context.Context, used *model.RefreshToken) error {
+	if err := s.tokenRepo.RevokeTokenFamily(ctx, used.FamilyID); err != nil {
+		return err
+	}
+	// Whoever replayed the token may hold an access token of the session too
+	if err := s.revokeSessionAccess(ctx, used.FamilyID); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// revokeSessionAccess stops the access tokens issued to a session, whose ID
+// is its refresh token family. None outlives AccessTokenTTL from now.
+func (s *AuthService) revokeSessionAccess(ctx context.Context, familyID uuid.UUID) error {
+	if s.denylist == nil {
+		return nil
+	}
+	return s.denylist.Revoke(ctx, familyID.String(), time.Now().Add(middleware.AccessTokenTTL))
 }
 
-// Register creates a new user
+// Register creates a new user with username as their email and sends them
+// a verification link
 func (s *AuthService) Register(ctx context.Context, username, password string) (*model.User, error) {
+	if err := ValidateEmail(username); err != nil {
+		return nil, err
+	}
+	if err := s.policy.Validate(password); err != nil {
+		return nil, err
+	}
+
 	// Check if username already exists
 	existingUser, err := s.userRepo.GetByUsername(ctx, username)
 	if err == nil && existingUser != nil {
@@ -80,15 +337,141 @@ func (s *AuthService) Register(ctx context.Context, username, password string) (
 		return nil, err
 	}
 
+	// The account exists either way; the user can ask for another link
+	if err := s.sendVerification(ctx, user); err != nil {
+		log.Printf("Error sending verification email to user %s: %v", user.ID, err)
+	}
+
 	return user, nil
 }
 
+// ChangePassword sets a new password for a user who knows the current one.
+// Every other session of the user is revoked; sessionID, the caller's own, is
+// kept.
+func (s *AuthService) ChangePassword(ctx context.Context, userID uuid.UUID, sessionID, currentPassword, newPassword string) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)); err != nil {
+		return ErrWrongPassword
+	}
+
+	keep, err := uuid.Parse(sessionID)
+	if err != nil {
+		// Tokens issued before sessions were tracked keep nothing
+		keep = uuid.Nil
+	}
+	return s.setPassword(ctx, user.ID, newPassword, keep)
+}
+
+// RequestPasswordReset sends a reset link to the user with the given email.
+// Nothing happens for unknown addresses, and callers must not tell the
+// difference, so that accounts cannot be discovered this way.
+func (s *AuthService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetByUsername(ctx, email)
+	if err != nil || user == nil {
+		return err
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	reset := &model.PasswordResetToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(PasswordResetTTL),
+		CreatedAt: now,
+	}
+	if err := s.tokenRepo.CreatePasswordReset(ctx, reset); err != nil {
+		return err
+	}
+
+	link := s.publicURL + "/reset-password?token=" + url.QueryEscape(token)
+	return s.notifier.Notify(ctx, notify.Message{
+		To:      user.Username,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your account. If it was you, open %s within %v "+
+			"or use this token: %s\nOtherwise you can ignore this message.", link, PasswordResetTTL, token),
+	})
+}
+
+// ResetPassword sets a new password using a token from RequestPasswordReset.
+// The token is used up and every session of the user is revoked.
+func (s *AuthService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	// Check the policy first so a rejected password does not use up the token
+	if err := s.policy.Validate(newPassword); err != nil {
+		return err
+	}
+
+	reset, err := s.tokenRepo.UsePasswordReset(ctx, hashToken(token))
+	if err != nil {
+		return err
+	}
+	if reset == nil {
+		return ErrInvalidResetToken
+	}
+	if err := s.setPassword(ctx, reset.UserID, newPassword, uuid.Nil); err != nil {
+		return err
+	}
+
+	// Whoever locked the account out was guessing the old password
+	if s.limiter != nil {
+		user, err := s.userRepo.GetByID(ctx, reset.UserID)
+		if err != nil {
+			return err
+		}
+		if user != nil {
+			return s.limiter.RecordSuccess(ctx, user.Username, "")
+		}
+	}
+	return nil
+}
+
+// setPassword stores a new password and revokes every session of the user
+// except keepSessionID
+func (s *AuthService) setPassword(ctx context.Context, userID uuid.UUID, password string, keepSessionID uuid.UUID) error {
+	if err := s.policy.Validate(password); err != nil {
+		return err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdatePassword(ctx, userID, string(hashed)); err != nil {
+		return err
+	}
+
+	families, err := s.tokenRepo.RevokeUserTokens(ctx, userID, keepSessionID)
+	if err != nil {
+		return err
+	}
+	// Access tokens already issued to those sessions stop working too
+	for _, family := range families {
+		if err := s.revokeSessionAccess(ctx, family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *AuthService) ValidateToken(ctx context.Context, token string) (uuid.UUID, error) {
 	// Validate token
 	claims, err := middleware.ValidateToken(token)
 	if err != nil {
 		return uuid.Nil, err
 	}
+	if s.denylist != nil {
+		if err := middleware.IsTokenRevoked(ctx, s.denylist, claims); err != nil {
+			return uuid.Nil, err
+		}
+	}
 
 	// Parse user ID from claims
 	userID, err := uuid.Parse(claims.UserID)
@@ -98,9 +481,40 @@ func (s *AuthService) ValidateToken(ctx context.Context, token string) (uuid.UUI
 
 	// Check if user exists
 	user, err := s.userRepo.GetByID(ctx, userID)
-	if err != nil || user == nil {
+	if err != nil || user == nil || user.DeletedAt != nil {
 		return uuid.Nil, errors.New("user not found")
 	}
 
 	return userID, nil
 }
+
+// newRefreshToken creates a refresh token in the given family, returning the
+// stored record and the token handed to the client
+func newRefreshToken(userID, familyID uuid.UUID) (*model.RefreshToken, string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	return &model.RefreshToken{
+		ID:        uuid.New(),
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(RefreshTokenTTL),
+		CreatedAt: now,
+	}, token, nil
+}
+
+// issueTokenPair pairs a refresh token with an access token for its session
+func issueTokenPair(refresh *model.RefreshToken, refreshToken string) (*TokenPair, error) {
+	accessToken, err := middleware.GenerateSessionToken(refresh.UserID.String(), refresh.FamilyID.String())
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(middleware.AccessTokenTTL / time.Second),
+	}, nil
+}
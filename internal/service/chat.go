@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"errors"
+	"time"
 
 	"rtcs/internal/model"
 	"rtcs/internal/repository"
@@ -10,39 +11,359 @@ import (
 	"github.com/google/uuid"
 )
 
+// MembershipListener is notified after chat membership changes so that live
+// connections can follow along
+type MembershipListener interface {
+	ChatJoined(chatID, userID uuid.UUID)
+	ChatLeft(chatID, userID uuid.UUID)
+}
+
+// ReadListener is notified after a user's read pointer moves so receipts can
+// be pushed to the other members
+type ReadListener interface {
+	MessagesRead(read *model.ChatRead)
+}
+
+// Chat errors
+var (
+	ErrChatNotFound      = errors.New("chat not found")
+	ErrInvalidChatName   = errors.New("chat name must be between 1 and 255 characters")
+	ErrInvalidRole       = errors.New("invalid role")
+	ErrLastOwner         = errors.New("the last owner must transfer ownership first")
+	ErrSelfManagement    = errors.New("cannot apply this action to yourself")
+	ErrInvalidVisibility = errors.New("invalid chat visibility")
+	ErrPrivateChat       = errors.New("private chats can only be joined through an invite")
+	ErrInvalidInvite     = errors.New("invite is invalid, expired or used up")
+	ErrInviteNotFound    = errors.New("invite not found")
+	ErrInvalidInviteOpts = errors.New("invite expiry must be in the future and max uses not negative")
+	ErrUserNotFound      = errors.New("user not found")
+	ErrDirectToSelf      = errors.New("cannot start a direct message with yourself")
+	ErrBanned            = errors.New("user is banned from this chat")
+	ErrBanNotFound       = errors.New("ban not found")
+	ErrInvalidBan        = errors.New("ban duration must not be negative and the reason at most 500 characters")
+	ErrMessageNotInChat  = errors.New("message not found in this chat")
+)
+
+// ChatSummary is a chat as listed for one user
+type ChatSummary struct {
+	*model.Chat
+	UnreadCount int64 `json:"unread_count"`
+}
+
 type ChatService struct {
-	repo repository.Repository
+	repo         repository.Repository
+	auth         *Authorizer
+	listener     MembershipListener
+	readListener ReadListener
+	profiles     ProfileLookup
+	blocks       BlockList
+	verified     VerificationGate
 }
 
 func NewChatService(repo repository.Repository) *ChatService {
-	return &ChatService{repo: repo}
+	return &ChatService{repo: repo, auth: NewAuthorizer(repo)}
+}
+
+// SetMembershipListener registers the listener notified on join and leave
+func (s *ChatService) SetMembershipListener(listener MembershipListener) {
+	s.listener = listener
+}
+
+// SetProfileLookup lets member lists embed member profiles
+func (s *ChatService) SetProfileLookup(profiles ProfileLookup) {
+	s.profiles = profiles
+}
+
+// SetBlockList stops users who blocked each other from opening direct
+// messages, and leaves messages from blocked users out of unread counts
+func (s *ChatService) SetBlockList(blocks BlockList) {
+	s.blocks = blocks
+}
+
+// blockedSenders lists the users whose messages userID does not see
+func (s *ChatService) blockedSenders(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	if s.blocks == nil {
+		return nil, nil
+	}
+	blocked, err := s.blocks.BlockedUsers(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	senders := make([]uuid.UUID, 0, len(blocked))
+	for id := range blocked {
+		senders = append(senders, id)
+	}
+	return senders, nil
+}
+
+// SetVerificationGate keeps users who have not verified their email from
+// creating or joining chats if the gate's policy says so
+func (s *ChatService) SetVerificationGate(gate VerificationGate) {
+	s.verified = gate
+}
+
+// requireVerified checks action against the verification gate, if any
+func (s *ChatService) requireVerified(ctx context.Context, userID uuid.UUID, action UnverifiedAction) error {
+	if s.verified == nil {
+		return nil
+	}
+	return s.verified.RequireVerified(ctx, userID, action)
 }
 
-func (s *ChatService) CreateChat(ctx context.Context, name string, creatorID uuid.UUID) (*model.Chat, error) {
+// CreateChat creates a chat owned by its creator. An empty visibility makes
+// the chat public.
+func (s *ChatService) CreateChat(ctx context.Context, name string, creatorID uuid.UUID, visibility model.ChatVisibility) (*model.Chat, error) {
+	if name == "" || len(name) > 255 {
+		return nil, ErrInvalidChatName
+	}
+	if visibility == "" {
+		visibility = model.VisibilityPublic
+	}
+	if !visibility.Valid() {
+		return nil, ErrInvalidVisibility
+	}
+	if err := s.requireVerified(ctx, creatorID, RestrictCreateChats); err != nil {
+		return nil, err
+	}
+
 	chatID := uuid.New()
 	chat := &model.Chat{
-		ID:   chatID,
-		Name: name,
+		ID:         chatID,
+		Name:       name,
+		Kind:       model.KindGroup,
+		Visibility: visibility,
+		CreatedBy:  creatorID,
 	}
 
 	if err := s.repo.CreateChat(ctx, chat); err != nil {
 		return nil, err
 	}
 
-	// Add creator to the chat
-	if err := s.repo.AddUserToChat(ctx, chatID, creatorID); err != nil {
+	// Add creator to the chat as its owner
+	owner := &model.ChatUser{
+		ChatID:   chatID,
+		UserID:   creatorID,
+		Role:     model.RoleOwner,
+		JoinedAt: time.Now(),
+	}
+	if err := s.repo.AddMember(ctx, owner); err != nil {
 		return nil, err
 	}
 
 	return chat, nil
 }
 
-func (s *ChatService) GetChat(ctx context.Context, id uuid.UUID) (*model.Chat, error) {
+// SetReadListener registers the listener notified about read receipts
+func (s *ChatService) SetReadListener(listener ReadListener) {
+	s.readListener = listener
+}
+
+// GetChat returns a chat the user is a member of
+func (s *ChatService) GetChat(ctx context.Context, id, userID uuid.UUID) (*model.Chat, error) {
+	if _, err := s.auth.Authorize(ctx, id, userID, PermReadMessages); err != nil {
+		return nil, err
+	}
 	return s.repo.GetChat(ctx, id)
 }
 
-func (s *ChatService) ListChats(ctx context.Context, userID uuid.UUID) ([]*model.Chat, error) {
-	return s.repo.ListChats(ctx, userID)
+// RenameChat changes the name of a chat
+func (s *ChatService) RenameChat(ctx context.Context, chatID, userID uuid.UUID, name string) (*model.Chat, error) {
+	if name == "" || len(name) > 255 {
+		return nil, ErrInvalidChatName
+	}
+	if _, err := s.auth.Authorize(ctx, chatID, userID, PermRenameChat); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.RenameChat(ctx, chatID, name); err != nil {
+		return nil, err
+	}
+	return s.repo.GetChat(ctx, chatID)
+}
+
+func (s *ChatService) ListChats(ctx context.Context, userID uuid.UUID) ([]*ChatSummary, error) {
+	chats, err := s.repo.ListChats(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	hidden, err := s.blockedSenders(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	unread, err := s.repo.UnreadCounts(ctx, userID, hidden)
+	if err != nil {
+		return nil, err
+	}
+
+	peers, err := s.repo.DirectChatPeers(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]*ChatSummary, 0, len(chats))
+	for _, chat := range chats {
+		summaries = append(summaries, &ChatSummary{Chat: directChatView(chat, peers[chat.ID]), UnreadCount: unread[chat.ID]})
+	}
+	return summaries, nil
+}
+
+// OpenDirectChat returns the direct message chat between two users, creating
+// it with both as members the first time
+func (s *ChatService) OpenDirectChat(ctx context.Context, userID, peerID uuid.UUID) (*model.Chat, error) {
+	if userID == peerID {
+		return nil, ErrDirectToSelf
+	}
+	if err := s.requireVerified(ctx, userID, RestrictCreateChats); err != nil {
+		return nil, err
+	}
+	peer, err := s.repo.GetUser(ctx, peerID)
+	if err != nil {
+		return nil, err
+	}
+	if peer == nil || peer.DeletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+	if s.blocks != nil {
+		blocked, err := s.blocks.IsBlockedEitherWay(ctx, userID, peerID)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			return nil, ErrBlocked
+		}
+	}
+
+	key := model.DirectKey(userID, peerID)
+	chat, err := s.repo.GetDirectChat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if chat == nil {
+		chat = &model.Chat{
+			ID:         uuid.New(),
+			Kind:       model.KindDirect,
+			DirectKey:  &key,
+			Visibility: model.VisibilityPrivate,
+			CreatedBy:  userID,
+			CreatedAt:  time.Now(),
+		}
+		created, err := s.repo.CreateDirectChat(ctx, chat, []uuid.UUID{userID, peerID})
+		if err != nil {
+			return nil, err
+		}
+		if !created {
+			// The other user opened it first
+			if chat, err = s.repo.GetDirectChat(ctx, key); err != nil {
+				return nil, err
+			}
+		} else if s.listener != nil {
+			s.listener.ChatJoined(chat.ID, userID)
+			s.listener.ChatJoined(chat.ID, peerID)
+		}
+	}
+
+	// Either user may have left the chat since; opening it brings both back
+	for _, memberID := range []uuid.UUID{userID, peerID} {
+		member, err := s.repo.GetMember(ctx, chat.ID, memberID)
+		if err != nil {
+			return nil, err
+		}
+		if member != nil {
+			continue
+		}
+		err = s.repo.AddMember(ctx, &model.ChatUser{
+			ChatID:   chat.ID,
+			UserID:   memberID,
+			Role:     model.RoleMember,
+			JoinedAt: time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if s.listener != nil {
+			s.listener.ChatJoined(chat.ID, memberID)
+		}
+	}
+
+	return directChatView(chat, peer), nil
+}
+
+// MarkRead moves the user's read pointer in a chat up to the given message
+func (s *ChatService) MarkRead(ctx context.Context, chatID, userID, messageID uuid.UUID) (*model.ChatRead, error) {
+	if _, err := s.auth.Authorize(ctx, chatID, userID, PermReadMessages); err != nil {
+		return nil, err
+	}
+
+	message, err := s.repo.GetMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message == nil || message.ChatID != chatID {
+		return nil, ErrMessageNotInChat
+	}
+
+	read := &model.ChatRead{
+		ChatID:            chatID,
+		UserID:            userID,
+		LastReadMessageID: message.ID,
+		LastReadSeq:       message.Seq,
+		UpdatedAt:         time.Now(),
+	}
+	moved, err := s.repo.MarkRead(ctx, read)
+	if err != nil {
+		return nil, err
+	}
+
+	if moved && s.readListener != nil {
+		s.readListener.MessagesRead(read)
+	}
+	return read, nil
+}
+
+func (s *ChatService) IsMember(ctx context.Context, chatID, userID uuid.UUID) (bool, error) {
+	return s.repo.IsMember(ctx, chatID, userID)
+}
+
+// CanSubscribe checks that a user may follow a chat live: they must be a
+// member and not banned from it
+func (s *ChatService) CanSubscribe(ctx context.Context, chatID, userID uuid.UUID) error {
+	if err := s.ensureNotBanned(ctx, chatID, userID); err != nil {
+		return err
+	}
+	isMember, err := s.repo.IsMember(ctx, chatID, userID)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return ErrNotChatMember
+	}
+	return nil
+}
+
+// ListMembers returns the members of a chat with their roles, oldest first.
+// With embedProfiles each member carries their profile.
+func (s *ChatService) ListMembers(ctx context.Context, chatID, userID uuid.UUID, embedProfiles bool) ([]*model.ChatUser, error) {
+	if _, err := s.auth.Authorize(ctx, chatID, userID, PermReadMessages); err != nil {
+		return nil, err
+	}
+	members, err := s.repo.ListMembers(ctx, chatID)
+	if err != nil || !embedProfiles || s.profiles == nil {
+		return members, err
+	}
+
+	ids := make([]uuid.UUID, len(members))
+	for i, member := range members {
+		ids[i] = member.UserID
+	}
+	profiles, err := s.profiles.GetProfiles(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		member.Profile = profiles[member.UserID]
+	}
+	return members, nil
 }
 
 func (s *ChatService) JoinChat(ctx context.Context, chatID, userID uuid.UUID) error {
@@ -52,10 +373,119 @@ func (s *ChatService) JoinChat(ctx context.Context, chatID, userID uuid.UUID) er
 		return err
 	}
 	if chat == nil {
-		return errors.New("chat not found")
+		return ErrChatNotFound
+	}
+	if chat.Visibility == model.VisibilityPrivate {
+		return ErrPrivateChat
+	}
+	if err := s.ensureNotBanned(ctx, chatID, userID); err != nil {
+		return err
+	}
+	if err := s.requireVerified(ctx, userID, RestrictJoinChats); err != nil {
+		return err
+	}
+
+	if err := s.repo.AddUserToChat(ctx, chatID, userID); err != nil {
+		return err
+	}
+
+	if s.listener != nil {
+		s.listener.ChatJoined(chatID, userID)
+	}
+	return nil
+}
+
+// CreateInvite creates an invite to a chat. A nil expiresAt never expires and
+// a maxUses of 0 allows unlimited uses. The returned invite carries the token,
+// which is not stored and cannot be recovered later.
+func (s *ChatService) CreateInvite(ctx context.Context, chatID, actorID uuid.UUID, expiresAt *time.Time, maxUses int) (*model.ChatInvite, error) {
+	if maxUses < 0 || (expiresAt != nil && !expiresAt.After(time.Now())) {
+		return nil, ErrInvalidInviteOpts
+	}
+	if _, err := s.auth.Authorize(ctx, chatID, actorID, PermInviteMember); err != nil {
+		return nil, err
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	invite := &model.ChatInvite{
+		ID:        uuid.New(),
+		ChatID:    chatID,
+		CreatedBy: actorID,
+		TokenHash: hashToken(token),
+		MaxUses:   maxUses,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	if err := s.repo.CreateInvite(ctx, invite); err != nil {
+		return nil, err
+	}
+
+	invite.Token = token
+	return invite, nil
+}
+
+// ListInvites returns the invites of a chat, including spent ones
+func (s *ChatService) ListInvites(ctx context.Context, chatID, actorID uuid.UUID) ([]*model.ChatInvite, error) {
+	if _, err := s.auth.Authorize(ctx, chatID, actorID, PermInviteMember); err != nil {
+		return nil, err
+	}
+	return s.repo.ListInvites(ctx, chatID)
+}
+
+// RevokeInvite stops an invite from being redeemed
+func (s *ChatService) RevokeInvite(ctx context.Context, chatID, actorID, inviteID uuid.UUID) error {
+	if _, err := s.auth.Authorize(ctx, chatID, actorID, PermInviteMember); err != nil {
+		return err
+	}
+	revoked, err := s.repo.RevokeInvite(ctx, chatID, inviteID)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+// AcceptInvite redeems an invite token, adding the user to its chat. Members
+// accepting an invite to their own chat do not use it up.
+func (s *ChatService) AcceptInvite(ctx context.Context, token string, userID uuid.UUID) (*model.Chat, error) {
+	invite, err := s.repo.GetInviteByTokenHash(ctx, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if invite == nil {
+		return nil, ErrInvalidInvite
+	}
+
+	member, err := s.repo.GetMember(ctx, invite.ChatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if member == nil {
+		if err := s.ensureNotBanned(ctx, invite.ChatID, userID); err != nil {
+			return nil, err
+		}
+		if err := s.requireVerified(ctx, userID, RestrictJoinChats); err != nil {
+			return nil, err
+		}
+		redeemed, err := s.repo.RedeemInvite(ctx, invite, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !redeemed {
+			return nil, ErrInvalidInvite
+		}
+		if s.listener != nil {
+			s.listener.ChatJoined(invite.ChatID, userID)
+		}
 	}
 
-	return s.repo.AddUserToChat(ctx, chatID, userID)
+	return s.repo.GetChat(ctx, invite.ChatID)
 }
 
 func (s *ChatService) LeaveChat(ctx context.Context, chatID, userID uuid.UUID) error {
@@ -65,17 +495,217 @@ func (s *ChatService) LeaveChat(ctx context.Context, chatID, userID uuid.UUID) e
 		return err
 	}
 	if chat == nil {
-		return errors.New("chat not found")
+		return ErrChatNotFound
+	}
+
+	member, err := s.repo.GetMember(ctx, chatID, userID)
+	if err != nil {
+		return err
+	}
+	if member == nil {
+		return ErrNotChatMember
+	}
+	if err := s.ensureAnotherOwner(ctx, member); err != nil {
+		return err
+	}
+
+	return s.removeMember(ctx, chatID, userID)
+}
+
+// KickMember removes another member from a chat
+func (s *ChatService) KickMember(ctx context.Context, chatID, actorID, targetID uuid.UUID) error {
+	if actorID == targetID {
+		return ErrSelfManagement
+	}
+	actor, err := s.auth.Authorize(ctx, chatID, actorID, PermKickMember)
+	if err != nil {
+		return err
+	}
+	target, err := s.repo.GetMember(ctx, chatID, targetID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return ErrNotChatMember
+	}
+	if !canManage(actor, target) {
+		return ErrPermissionDenied
+	}
+	if err := s.ensureAnotherOwner(ctx, target); err != nil {
+		return err
+	}
+
+	return s.removeMember(ctx, chatID, targetID)
+}
+
+// BanMember removes a user from a chat and keeps them from rejoining for
+// duration, or for good if duration is 0. Users who are not members can be
+// banned too. Banning again replaces the earlier ban.
+func (s *ChatService) BanMember(ctx context.Context, chatID, actorID, targetID uuid.UUID, duration time.Duration, reason string) (*model.ChatBan, error) {
+	if actorID == targetID {
+		return nil, ErrSelfManagement
+	}
+	if duration < 0 || len(reason) > 500 {
+		return nil, ErrInvalidBan
+	}
+	actor, err := s.auth.Authorize(ctx, chatID, actorID, PermBanMember)
+	if err != nil {
+		return nil, err
+	}
+	target, err := s.repo.GetMember(ctx, chatID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if target != nil {
+		if !canManage(actor, target) {
+			return nil, ErrPermissionDenied
+		}
+		if err := s.ensureAnotherOwner(ctx, target); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	ban := &model.ChatBan{
+		ChatID:    chatID,
+		UserID:    targetID,
+		BannedBy:  actorID,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+	if duration > 0 {
+		expiresAt := now.Add(duration)
+		ban.ExpiresAt = &expiresAt
+	}
+	removed, err := s.repo.BanMember(ctx, ban)
+	if err != nil {
+		return nil, err
+	}
+
+	// Leaving evicts the user's live connections from the chat's room
+	if removed && s.listener != nil {
+		s.listener.ChatLeft(chatID, targetID)
+	}
+	return ban, nil
+}
+
+// UnbanMember lifts a user's ban; they may then rejoin the chat
+func (s *ChatService) UnbanMember(ctx context.Context, chatID, actorID, targetID uuid.UUID) error {
+	if _, err := s.auth.Authorize(ctx, chatID, actorID, PermBanMember); err != nil {
+		return err
+	}
+	lifted, err := s.repo.LiftBan(ctx, chatID, targetID)
+	if err != nil {
+		return err
+	}
+	if !lifted {
+		return ErrBanNotFound
+	}
+	return nil
+}
+
+// SetMemberRole changes a member's role. Owners may assign any role; admins
+// may only move members below them between member and read-only.
+func (s *ChatService) SetMemberRole(ctx context.Context, chatID, actorID, targetID uuid.UUID, role model.ChatRole) error {
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+	actor, err := s.auth.Authorize(ctx, chatID, actorID, PermManageRoles)
+	if err != nil {
+		return err
+	}
+	target, err := s.repo.GetMember(ctx, chatID, targetID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return ErrNotChatMember
+	}
+	if target.Role == role {
+		return nil
 	}
+	if actor.Role != model.RoleOwner && (actorID == targetID || roleRank[role] >= roleRank[actor.Role]) {
+		return ErrPermissionDenied
+	}
+	if !canManage(actor, target) {
+		return ErrPermissionDenied
+	}
+	if err := s.ensureAnotherOwner(ctx, target); err != nil {
+		return err
+	}
+
+	return s.repo.UpdateMemberRole(ctx, chatID, targetID, role)
+}
+
+// TransferOwnership hands a chat over to another member; the previous owner
+// stays on as an admin
+func (s *ChatService) TransferOwnership(ctx context.Context, chatID, ownerID, newOwnerID uuid.UUID) error {
+	if ownerID == newOwnerID {
+		return ErrSelfManagement
+	}
+	if _, err := s.auth.Authorize(ctx, chatID, ownerID, PermTransferOwnership); err != nil {
+		return err
+	}
+	target, err := s.repo.GetMember(ctx, chatID, newOwnerID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return ErrNotChatMember
+	}
+
+	return s.repo.TransferOwnership(ctx, chatID, ownerID, newOwnerID)
+}
 
-	// Check if user is the creator by checking if they were the first to join
-	chatUsers, err := s.repo.ListChats(ctx, userID)
+// ensureAnotherOwner refuses changes that would leave a chat without owners
+func (s *ChatService) ensureAnotherOwner(ctx context.Context, member *model.ChatUser) error {
+	if member.Role != model.RoleOwner {
+		return nil
+	}
+	members, err := s.repo.ListMembers(ctx, member.ChatID)
+	if err != nil {
+		return err
+	}
+	for _, other := range members {
+		if other.Role == model.RoleOwner && other.UserID != member.UserID {
+			return nil
+		}
+	}
+	return ErrLastOwner
+}
+
+// ensureNotBanned refuses users with an active ban from the chat
+func (s *ChatService) ensureNotBanned(ctx context.Context, chatID, userID uuid.UUID) error {
+	ban, err := s.repo.GetBan(ctx, chatID, userID)
 	if err != nil {
 		return err
 	}
-	if len(chatUsers) > 0 && chatUsers[0].ID == chatID {
-		return errors.New("chat creator cannot leave the chat")
+	if ban != nil && ban.Active(time.Now()) {
+		return ErrBanned
+	}
+	return nil
+}
+
+func (s *ChatService) removeMember(ctx context.Context, chatID, userID uuid.UUID) error {
+	if err := s.repo.RemoveUserFromChat(ctx, chatID, userID); err != nil {
+		return err
+	}
+
+	if s.listener != nil {
+		s.listener.ChatLeft(chatID, userID)
 	}
+	return nil
+}
 
-	return s.repo.RemoveUserFromChat(ctx, chatID, userID)
+// directChatView names a direct message chat after the other participant
+func directChatView(chat *model.Chat, peer *model.User) *model.Chat {
+	if chat.Kind != model.KindDirect || peer == nil {
+		return chat
+	}
+	view := *chat
+	view.Name = peer.Username
+	if peer.DisplayName != "" {
+		view.Name = peer.DisplayName
+	}
+	return &view
 }
@@ -2,7 +2,9 @@ package service
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"rtcs/internal/model"
 	"rtcs/internal/repository"
@@ -14,6 +16,12 @@ type mockRepository struct {
 	repository.Repository
 	chats     map[uuid.UUID]*model.Chat
 	chatUsers map[uuid.UUID]map[uuid.UUID]bool
+	roles     map[uuid.UUID]map[uuid.UUID]model.ChatRole
+	messages  map[uuid.UUID]*model.Message
+	reads     map[uuid.UUID]map[uuid.UUID]*model.ChatRead
+	invites   map[string]*model.ChatInvite
+	users     map[uuid.UUID]*model.User
+	bans      map[uuid.UUID]map[uuid.UUID]*model.ChatBan
 	createErr error
 	getErr    error
 	listErr   error
@@ -49,15 +57,24 @@ func (m *mockRepository) ListChats(ctx context.Context, userID uuid.UUID) ([]*mo
 	return chats, nil
 }
 
+func (m *mockRepository) RenameChat(ctx context.Context, chatID uuid.UUID, name string) error {
+	m.chats[chatID].Name = name
+	return nil
+}
+
 func (m *mockRepository) AddUserToChat(ctx context.Context, chatID, userID uuid.UUID) error {
+	return m.AddMember(ctx, &model.ChatUser{ChatID: chatID, UserID: userID, Role: model.RoleMember})
+}
+
+func (m *mockRepository) AddMember(ctx context.Context, member *model.ChatUser) error {
 	if m.addErr != nil {
 		return m.addErr
 	}
-	if m.chatUsers[chatID] == nil {
-		m.chatUsers[chatID] = make(map[uuid.UUID]bool)
+	if m.chatUsers[member.ChatID] == nil {
+		m.chatUsers[member.ChatID] = make(map[uuid.UUID]bool)
 	}
-	m.chatUsers[chatID][userID] = true
-	return nil
+	m.chatUsers[member.ChatID][member.UserID] = true
+	return m.UpdateMemberRole(ctx, member.ChatID, member.UserID, member.Role)
 }
 
 func (m *mockRepository) RemoveUserFromChat(ctx context.Context, chatID, userID uuid.UUID) error {
@@ -65,9 +82,173 @@ func (m *mockRepository) RemoveUserFromChat(ctx context.Context, chatID, userID
 		return m.removeErr
 	}
 	delete(m.chatUsers[chatID], userID)
+	delete(m.roles[chatID], userID)
 	return nil
 }
 
+func (m *mockRepository) GetMember(ctx context.Context, chatID, userID uuid.UUID) (*model.ChatUser, error) {
+	if !m.chatUsers[chatID][userID] {
+		return nil, nil
+	}
+	return &model.ChatUser{ChatID: chatID, UserID: userID, Role: m.roles[chatID][userID]}, nil
+}
+
+func (m *mockRepository) ListMembers(ctx context.Context, chatID uuid.UUID) ([]*model.ChatUser, error) {
+	var members []*model.ChatUser
+	for userID := range m.chatUsers[chatID] {
+		member, _ := m.GetMember(ctx, chatID, userID)
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func (m *mockRepository) UpdateMemberRole(ctx context.Context, chatID, userID uuid.UUID, role model.ChatRole) error {
+	if m.roles == nil {
+		m.roles = make(map[uuid.UUID]map[uuid.UUID]model.ChatRole)
+	}
+	if m.roles[chatID] == nil {
+		m.roles[chatID] = make(map[uuid.UUID]model.ChatRole)
+	}
+	m.roles[chatID][userID] = role
+	return nil
+}
+
+func (m *mockRepository) TransferOwnership(ctx context.Context, chatID, fromID, toID uuid.UUID) error {
+	m.roles[chatID][toID] = model.RoleOwner
+	m.roles[chatID][fromID] = model.RoleAdmin
+	return nil
+}
+
+func (m *mockRepository) BanMember(ctx context.Context, ban *model.ChatBan) (bool, error) {
+	if m.bans == nil {
+		m.bans = make(map[uuid.UUID]map[uuid.UUID]*model.ChatBan)
+	}
+	if m.bans[ban.ChatID] == nil {
+		m.bans[ban.ChatID] = make(map[uuid.UUID]*model.ChatBan)
+	}
+	m.bans[ban.ChatID][ban.UserID] = ban
+	wasMember := m.chatUsers[ban.ChatID][ban.UserID]
+	return wasMember, m.RemoveUserFromChat(ctx, ban.ChatID, ban.UserID)
+}
+
+func (m *mockRepository) GetBan(ctx context.Context, chatID, userID uuid.UUID) (*model.ChatBan, error) {
+	if ban := m.bans[chatID][userID]; ban != nil && ban.Active(time.Now()) {
+		return ban, nil
+	}
+	return nil, nil
+}
+
+func (m *mockRepository) LiftBan(ctx context.Context, chatID, userID uuid.UUID) (bool, error) {
+	_, ok := m.bans[chatID][userID]
+	delete(m.bans[chatID], userID)
+	return ok, nil
+}
+
+func (m *mockRepository) GetUser(ctx context.Context, id uuid.UUID) (*model.User, error) {
+	return m.users[id], nil
+}
+
+func (m *mockRepository) GetDirectChat(ctx context.Context, directKey string) (*model.Chat, error) {
+	for _, chat := range m.chats {
+		if chat.DirectKey != nil && *chat.DirectKey == directKey {
+			return chat, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockRepository) CreateDirectChat(ctx context.Context, chat *model.Chat, memberIDs []uuid.UUID) (bool, error) {
+	m.chats[chat.ID] = chat
+	for _, userID := range memberIDs {
+		m.AddUserToChat(ctx, chat.ID, userID)
+	}
+	return true, nil
+}
+
+func (m *mockRepository) DirectChatPeers(ctx context.Context, userID uuid.UUID) (map[uuid.UUID]*model.User, error) {
+	peers := make(map[uuid.UUID]*model.User)
+	for id, chat := range m.chats {
+		if chat.Kind != model.KindDirect || !m.chatUsers[id][userID] {
+			continue
+		}
+		for memberID := range m.chatUsers[id] {
+			if memberID != userID {
+				peers[id] = m.users[memberID]
+			}
+		}
+	}
+	return peers, nil
+}
+
+func (m *mockRepository) CreateInvite(ctx context.Context, invite *model.ChatInvite) error {
+	if m.invites == nil {
+		m.invites = make(map[string]*model.ChatInvite)
+	}
+	m.invites[invite.TokenHash] = invite
+	return nil
+}
+
+func (m *mockRepository) GetInviteByTokenHash(ctx context.Context, tokenHash string) (*model.ChatInvite, error) {
+	return m.invites[tokenHash], nil
+}
+
+func (m *mockRepository) RevokeInvite(ctx context.Context, chatID, inviteID uuid.UUID) (bool, error) {
+	for _, invite := range m.invites {
+		if invite.ID == inviteID && invite.ChatID == chatID && invite.RevokedAt == nil {
+			now := time.Now()
+			invite.RevokedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockRepository) RedeemInvite(ctx context.Context, invite *model.ChatInvite, userID uuid.UUID) (bool, error) {
+	if invite.RevokedAt != nil || (invite.ExpiresAt != nil && !invite.ExpiresAt.After(time.Now())) ||
+		(invite.MaxUses > 0 && invite.Uses >= invite.MaxUses) {
+		return false, nil
+	}
+	invite.Uses++
+	return true, m.AddUserToChat(ctx, invite.ChatID, userID)
+}
+
+func (m *mockRepository) IsMember(ctx context.Context, chatID, userID uuid.UUID) (bool, error) {
+	return m.chatUsers[chatID][userID], nil
+}
+
+func (m *mockRepository) GetMessage(ctx context.Context, id uuid.UUID) (*model.Message, error) {
+	return m.messages[id], nil
+}
+
+func (m *mockRepository) MarkRead(ctx context.Context, read *model.ChatRead) (bool, error) {
+	if m.reads[read.ChatID] == nil {
+		m.reads[read.ChatID] = make(map[uuid.UUID]*model.ChatRead)
+	}
+	if current := m.reads[read.ChatID][read.UserID]; current != nil && current.LastReadSeq >= read.LastReadSeq {
+		return false, nil
+	}
+	m.reads[read.ChatID][read.UserID] = read
+	return true, nil
+}
+
+func (m *mockRepository) UnreadCounts(ctx context.Context, userID uuid.UUID, hiddenSenders []uuid.UUID) (map[uuid.UUID]int64, error) {
+	hidden := make(map[uuid.UUID]bool, len(hiddenSenders))
+	for _, id := range hiddenSenders {
+		hidden[id] = true
+	}
+	counts := make(map[uuid.UUID]int64)
+	for _, msg := range m.messages {
+		if !m.chatUsers[msg.ChatID][userID] || msg.SenderID == userID || hidden[msg.SenderID] {
+			continue
+		}
+		if read := m.reads[msg.ChatID][userID]; read != nil && msg.Seq <= read.LastReadSeq {
+			continue
+		}
+		counts[msg.ChatID]++
+	}
+	return counts, nil
+}
+
 func TestChatService_CreateChat(t *testing.T) {
 	ctx := context.Background()
 	repo := &mockRepository{
@@ -77,7 +258,7 @@ func TestChatService_CreateChat(t *testing.T) {
 	service := NewChatService(repo)
 
 	creatorID := uuid.New()
-	chat, err := service.CreateChat(ctx, "test chat", creatorID)
+	chat, err := service.CreateChat(ctx, "test chat", creatorID, "")
 	if err != nil {
 		t.Fatalf("CreateChat failed: %v", err)
 	}
@@ -102,7 +283,7 @@ func TestChatService_JoinLeaveChat(t *testing.T) {
 
 	// Create a chat
 	creatorID := uuid.New()
-	chat, _ := service.CreateChat(ctx, "test chat", creatorID)
+	chat, _ := service.CreateChat(ctx, "test chat", creatorID, "")
 
 	// Join chat
 	userID := uuid.New()
@@ -138,7 +319,7 @@ func TestChatService_CreatorCannotLeave(t *testing.T) {
 
 	// Create a chat
 	creatorID := uuid.New()
-	chat, _ := service.CreateChat(ctx, "test chat", creatorID)
+	chat, _ := service.CreateChat(ctx, "test chat", creatorID, "")
 
 	// Try to leave as creator
 	err := service.LeaveChat(ctx, chat.ID, creatorID)
@@ -146,3 +327,493 @@ func TestChatService_CreatorCannotLeave(t *testing.T) {
 		t.Error("Expected error when creator tries to leave chat")
 	}
 }
+
+type recordingReadListener struct {
+	reads []*model.ChatRead
+}
+
+func (l *recordingReadListener) MessagesRead(read *model.ChatRead) {
+	l.reads = append(l.reads, read)
+}
+
+func TestChatService_MarkReadAndUnreadCounts(t *testing.T) {
+	ctx := context.Background()
+	repo := &mockRepository{
+		chats:     make(map[uuid.UUID]*model.Chat),
+		chatUsers: make(map[uuid.UUID]map[uuid.UUID]bool),
+		messages:  make(map[uuid.UUID]*model.Message),
+		reads:     make(map[uuid.UUID]map[uuid.UUID]*model.ChatRead),
+	}
+	service := NewChatService(repo)
+	listener := &recordingReadListener{}
+	service.SetReadListener(listener)
+
+	alice, bob := uuid.New(), uuid.New()
+	chat, _ := service.CreateChat(ctx, "test chat", alice, "")
+	service.JoinChat(ctx, chat.ID, bob)
+
+	var sent []*model.Message
+	for seq := int64(1); seq <= 3; seq++ {
+		msg := &model.Message{ID: uuid.New(), ChatID: chat.ID, SenderID: alice, Seq: seq}
+		repo.messages[msg.ID] = msg
+		sent = append(sent, msg)
+	}
+
+	chats, err := service.ListChats(ctx, bob)
+	if err != nil {
+		t.Fatalf("ListChats failed: %v", err)
+	}
+	if len(chats) != 1 || chats[0].UnreadCount != 3 {
+		t.Fatalf("Expected 3 unread messages, got %+v", chats)
+	}
+
+	if _, err := service.MarkRead(ctx, chat.ID, bob, sent[1].ID); err != nil {
+		t.Fatalf("MarkRead failed: %v", err)
+	}
+	chats, _ = service.ListChats(ctx, bob)
+	if chats[0].UnreadCount != 1 {
+		t.Errorf("Expected 1 unread message, got %d", chats[0].UnreadCount)
+	}
+
+	// Marking an older message read does not move the pointer back
+	service.MarkRead(ctx, chat.ID, bob, sent[0].ID)
+	if len(listener.reads) != 1 {
+		t.Errorf("Expected 1 receipt, got %d", len(listener.reads))
+	}
+
+	if _, err := service.MarkRead(ctx, chat.ID, uuid.New(), sent[2].ID); err == nil {
+		t.Error("Expected error when a non-member marks a chat read")
+	}
+
+	other, _ := service.CreateChat(ctx, "other chat", bob, "")
+	if _, err := service.MarkRead(ctx, other.ID, bob, sent[2].ID); !errors.Is(err, ErrMessageNotInChat) {
+		t.Errorf("Expected ErrMessageNotInChat for a message from another chat, got %v", err)
+	}
+	if _, err := service.MarkRead(ctx, chat.ID, bob, uuid.New()); !errors.Is(err, ErrMessageNotInChat) {
+		t.Errorf("Expected ErrMessageNotInChat for a missing message, got %v", err)
+	}
+}
+
+func TestChatService_UnreadCountsHideBlockedSenders(t *testing.T) {
+	ctx := context.Background()
+	repo := &mockRepository{
+		chats:     make(map[uuid.UUID]*model.Chat),
+		chatUsers: make(map[uuid.UUID]map[uuid.UUID]bool),
+		messages:  make(map[uuid.UUID]*model.Message),
+		reads:     make(map[uuid.UUID]map[uuid.UUID]*model.ChatRead),
+	}
+	service := NewChatService(repo)
+
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	service.SetBlockList(staticBlocks{bob: {carol: true}})
+	chat, _ := service.CreateChat(ctx, "test chat", alice, "")
+	service.JoinChat(ctx, chat.ID, bob)
+	service.JoinChat(ctx, chat.ID, carol)
+
+	for seq, sender := range []uuid.UUID{alice, carol, carol} {
+		msg := &model.Message{ID: uuid.New(), ChatID: chat.ID, SenderID: sender, Seq: int64(seq + 1)}
+		repo.messages[msg.ID] = msg
+	}
+
+	// Bob does not see Carol's messages, so they do not count as unread
+	chats, err := service.ListChats(ctx, bob)
+	if err != nil {
+		t.Fatalf("ListChats failed: %v", err)
+	}
+	if len(chats) != 1 || chats[0].UnreadCount != 1 {
+		t.Errorf("Expected 1 unread message for the blocker, got %+v", chats)
+	}
+	chats, _ = service.ListChats(ctx, alice)
+	if len(chats) != 1 || chats[0].UnreadCount != 2 {
+		t.Errorf("Expected 2 unread messages for others, got %+v", chats)
+	}
+}
+
+func TestChatService_Roles(t *testing.T) {
+	ctx := context.Background()
+	repo := &mockRepository{
+		chats:     make(map[uuid.UUID]*model.Chat),
+		chatUsers: make(map[uuid.UUID]map[uuid.UUID]bool),
+	}
+	service := NewChatService(repo)
+
+	owner, admin, member := uuid.New(), uuid.New(), uuid.New()
+	chat, _ := service.CreateChat(ctx, "test chat", owner, "")
+	if chat.CreatedBy != owner || repo.roles[chat.ID][owner] != model.RoleOwner {
+		t.Fatalf("Expected creator to own the chat, got %+v", repo.roles[chat.ID])
+	}
+	service.JoinChat(ctx, chat.ID, admin)
+	service.JoinChat(ctx, chat.ID, member)
+
+	t.Run("Members cannot manage", func(t *testing.T) {
+		if _, err := service.RenameChat(ctx, chat.ID, member, "renamed"); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Expected ErrPermissionDenied for rename, got %v", err)
+		}
+		if err := service.KickMember(ctx, chat.ID, member, admin); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Expected ErrPermissionDenied for kick, got %v", err)
+		}
+	})
+
+	t.Run("Owner promotes an admin", func(t *testing.T) {
+		if err := service.SetMemberRole(ctx, chat.ID, owner, admin, model.RoleAdmin); err != nil {
+			t.Fatalf("SetMemberRole failed: %v", err)
+		}
+		if _, err := service.RenameChat(ctx, chat.ID, admin, "renamed"); err != nil {
+			t.Errorf("Expected admin to rename the chat, got %v", err)
+		}
+	})
+
+	t.Run("Admins cannot promote to their own rank or act on owners", func(t *testing.T) {
+		if err := service.SetMemberRole(ctx, chat.ID, admin, member, model.RoleAdmin); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Expected ErrPermissionDenied, got %v", err)
+		}
+		if err := service.KickMember(ctx, chat.ID, admin, owner); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Expected ErrPermissionDenied, got %v", err)
+		}
+		if err := service.SetMemberRole(ctx, chat.ID, admin, member, model.RoleReadOnly); err != nil {
+			t.Errorf("Expected admin to make a member read-only, got %v", err)
+		}
+	})
+
+	t.Run("Last owner cannot leave until ownership is transferred", func(t *testing.T) {
+		if err := service.LeaveChat(ctx, chat.ID, owner); !errors.Is(err, ErrLastOwner) {
+			t.Fatalf("Expected ErrLastOwner, got %v", err)
+		}
+		if err := service.TransferOwnership(ctx, chat.ID, owner, admin); err != nil {
+			t.Fatalf("TransferOwnership failed: %v", err)
+		}
+		if repo.roles[chat.ID][admin] != model.RoleOwner || repo.roles[chat.ID][owner] != model.RoleAdmin {
+			t.Errorf("Unexpected roles after transfer: %+v", repo.roles[chat.ID])
+		}
+		if err := service.LeaveChat(ctx, chat.ID, owner); err != nil {
+			t.Errorf("Expected former owner to leave, got %v", err)
+		}
+	})
+}
+
+func TestChatService_Invites(t *testing.T) {
+	ctx := context.Background()
+	repo := &mockRepository{
+		chats:     make(map[uuid.UUID]*model.Chat),
+		chatUsers: make(map[uuid.UUID]map[uuid.UUID]bool),
+	}
+	service := NewChatService(repo)
+
+	owner, member := uuid.New(), uuid.New()
+	chat, err := service.CreateChat(ctx, "private chat", owner, model.VisibilityPrivate)
+	if err != nil {
+		t.Fatalf("CreateChat failed: %v", err)
+	}
+
+	if err := service.JoinChat(ctx, chat.ID, member); !errors.Is(err, ErrPrivateChat) {
+		t.Fatalf("Expected ErrPrivateChat, got %v", err)
+	}
+
+	t.Run("Members cannot invite", func(t *testing.T) {
+		invite, _ := service.CreateInvite(ctx, chat.ID, owner, nil, 1)
+		if _, err := service.AcceptInvite(ctx, invite.Token, member); err != nil {
+			t.Fatalf("AcceptInvite failed: %v", err)
+		}
+		if _, err := service.CreateInvite(ctx, chat.ID, member, nil, 0); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Expected ErrPermissionDenied, got %v", err)
+		}
+	})
+
+	t.Run("Max uses", func(t *testing.T) {
+		invite, err := service.CreateInvite(ctx, chat.ID, owner, nil, 1)
+		if err != nil {
+			t.Fatalf("CreateInvite failed: %v", err)
+		}
+		if invite.Token == "" || invite.TokenHash == invite.Token {
+			t.Fatalf("Expected a token distinct from its stored hash")
+		}
+		if _, err := service.AcceptInvite(ctx, invite.Token, uuid.New()); err != nil {
+			t.Fatalf("AcceptInvite failed: %v", err)
+		}
+		if _, err := service.AcceptInvite(ctx, invite.Token, uuid.New()); !errors.Is(err, ErrInvalidInvite) {
+			t.Errorf("Expected ErrInvalidInvite once used up, got %v", err)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		past := time.Now().Add(-time.Minute)
+		if _, err := service.CreateInvite(ctx, chat.ID, owner, &past, 0); !errors.Is(err, ErrInvalidInviteOpts) {
+			t.Fatalf("Expected ErrInvalidInviteOpts, got %v", err)
+		}
+		soon := time.Now().Add(time.Minute)
+		invite, _ := service.CreateInvite(ctx, chat.ID, owner, &soon, 0)
+		*invite.ExpiresAt = past
+		if _, err := service.AcceptInvite(ctx, invite.Token, uuid.New()); !errors.Is(err, ErrInvalidInvite) {
+			t.Errorf("Expected ErrInvalidInvite once expired, got %v", err)
+		}
+	})
+
+	t.Run("Revocation", func(t *testing.T) {
+		invite, _ := service.CreateInvite(ctx, chat.ID, owner, nil, 0)
+		if err := service.RevokeInvite(ctx, chat.ID, owner, invite.ID); err != nil {
+			t.Fatalf("RevokeInvite failed: %v", err)
+		}
+		if _, err := service.AcceptInvite(ctx, invite.Token, uuid.New()); !errors.Is(err, ErrInvalidInvite) {
+			t.Errorf("Expected ErrInvalidInvite once revoked, got %v", err)
+		}
+		if err := service.RevokeInvite(ctx, chat.ID, owner, invite.ID); !errors.Is(err, ErrInviteNotFound) {
+			t.Errorf("Expected ErrInviteNotFound on second revoke, got %v", err)
+		}
+	})
+}
+
+func TestChatService_DirectChats(t *testing.T) {
+	ctx := context.Background()
+	alice := &model.User{ID: uuid.New(), Username: "alice"}
+	bob := &model.User{ID: uuid.New(), Username: "bob"}
+	repo := &mockRepository{
+		chats:     make(map[uuid.UUID]*model.Chat),
+		chatUsers: make(map[uuid.UUID]map[uuid.UUID]bool),
+		users:     map[uuid.UUID]*model.User{alice.ID: alice, bob.ID: bob},
+	}
+	service := NewChatService(repo)
+
+	chat, err := service.OpenDirectChat(ctx, alice.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("OpenDirectChat failed: %v", err)
+	}
+	if chat.Kind != model.KindDirect || chat.Name != "bob" {
+		t.Errorf("Expected a direct chat named after bob, got %q (%s)", chat.Name, chat.Kind)
+	}
+
+	again, err := service.OpenDirectChat(ctx, bob.ID, alice.ID)
+	if err != nil {
+		t.Fatalf("OpenDirectChat failed: %v", err)
+	}
+	if again.ID != chat.ID || again.Name != "alice" {
+		t.Errorf("Expected the same chat named after alice, got %s %q", again.ID, again.Name)
+	}
+
+	summaries, _ := service.ListChats(ctx, alice.ID)
+	if len(summaries) != 1 || summaries[0].Name != "bob" {
+		t.Errorf("Expected alice to list one chat named bob, got %+v", summaries)
+	}
+
+	if err := service.JoinChat(ctx, chat.ID, uuid.New()); !errors.Is(err, ErrPrivateChat) {
+		t.Errorf("Expected ErrPrivateChat for a third user, got %v", err)
+	}
+	if _, err := service.OpenDirectChat(ctx, alice.ID, alice.ID); !errors.Is(err, ErrDirectToSelf) {
+		t.Errorf("Expected ErrDirectToSelf, got %v", err)
+	}
+	if _, err := service.OpenDirectChat(ctx, alice.ID, uuid.New()); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestChatService_DirectChatsRefusedWhenBlocked(t *testing.T) {
+	ctx := context.Background()
+	alice := &model.User{ID: uuid.New(), Username: "alice"}
+	bob := &model.User{ID: uuid.New(), Username: "bob"}
+	repo := &mockRepository{
+		chats:     make(map[uuid.UUID]*model.Chat),
+		chatUsers: make(map[uuid.UUID]map[uuid.UUID]bool),
+		users:     map[uuid.UUID]*model.User{alice.ID: alice, bob.ID: bob},
+	}
+	service := NewChatService(repo)
+	service.SetBlockList(staticBlocks{alice.ID: {bob.ID: true}})
+
+	// The block works in both directions
+	if _, err := service.OpenDirectChat(ctx, alice.ID, bob.ID); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Expected ErrBlocked for the blocker, got %v", err)
+	}
+	if _, err := service.OpenDirectChat(ctx, bob.ID, alice.ID); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Expected ErrBlocked for the blocked user, got %v", err)
+	}
+}
+
+func TestChatService_DirectChatReopenedAfterLeaving(t *testing.T) {
+	ctx := context.Background()
+	alice := &model.User{ID: uuid.New(), Username: "alice"}
+	bob := &model.User{ID: uuid.New(), Username: "bob"}
+	repo := &mockRepository{
+		chats:     make(map[uuid.UUID]*model.Chat),
+		chatUsers: make(map[uuid.UUID]map[uuid.UUID]bool),
+		users:     map[uuid.UUID]*model.User{alice.ID: alice, bob.ID: bob},
+	}
+	service := NewChatService(repo)
+	listener := &recordingMembershipListener{}
+	service.SetMembershipListener(listener)
+
+	chat, err := service.OpenDirectChat(ctx, alice.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("OpenDirectChat failed: %v", err)
+	}
+	if err := service.LeaveChat(ctx, chat.ID, bob.ID); err != nil {
+		t.Fatalf("LeaveChat failed: %v", err)
+	}
+	listener.joined = nil
+
+	// Either side reopening the chat brings bob back
+	again, err := service.OpenDirectChat(ctx, alice.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("OpenDirectChat failed: %v", err)
+	}
+	if again.ID != chat.ID {
+		t.Errorf("Expected the same chat, got %s", again.ID)
+	}
+	if !repo.chatUsers[chat.ID][alice.ID] || !repo.chatUsers[chat.ID][bob.ID] {
+		t.Errorf("Expected both users to be members again, got %v", repo.chatUsers[chat.ID])
+	}
+	if len(listener.joined) != 1 || listener.joined[0] != bob.ID {
+		t.Errorf("Expected bob to rejoin the chat's room, got %v", listener.joined)
+	}
+	if _, err := service.auth.Authorize(ctx, chat.ID, bob.ID, PermSendMessage); err != nil {
+		t.Errorf("Expected bob to be able to send again, got %v", err)
+	}
+}
+
+type recordingMembershipListener struct {
+	joined []uuid.UUID
+	left   []uuid.UUID
+}
+
+func (l *recordingMembershipListener) ChatJoined(chatID, userID uuid.UUID) {
+	l.joined = append(l.joined, userID)
+}
+
+func (l *recordingMembershipListener) ChatLeft(chatID, userID uuid.UUID) {
+	l.left = append(l.left, userID)
+}
+
+func TestChatService_Bans(t *testing.T) {
+	ctx := context.Background()
+	repo := &mockRepository{
+		chats:     make(map[uuid.UUID]*model.Chat),
+		chatUsers: make(map[uuid.UUID]map[uuid.UUID]bool),
+	}
+	service := NewChatService(repo)
+	listener := &recordingMembershipListener{}
+	service.SetMembershipListener(listener)
+
+	owner, member, outsider := uuid.New(), uuid.New(), uuid.New()
+	chat, _ := service.CreateChat(ctx, "test chat", owner, "")
+	service.JoinChat(ctx, chat.ID, member)
+
+	if _, err := service.BanMember(ctx, chat.ID, member, owner, 0, ""); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("Expected ErrPermissionDenied for a member banning, got %v", err)
+	}
+
+	ban, err := service.BanMember(ctx, chat.ID, owner, member, 0, "spam")
+	if err != nil {
+		t.Fatalf("BanMember failed: %v", err)
+	}
+	if ban.ExpiresAt != nil || ban.Reason != "spam" {
+		t.Errorf("Expected a permanent ban for spam, got %+v", ban)
+	}
+	if repo.chatUsers[chat.ID][member] {
+		t.Error("Banned user is still a member")
+	}
+	if len(listener.left) != 1 || listener.left[0] != member {
+		t.Errorf("Expected the banned user's connections to leave the chat, got %v", listener.left)
+	}
+
+	if err := service.JoinChat(ctx, chat.ID, member); !errors.Is(err, ErrBanned) {
+		t.Errorf("Expected ErrBanned on rejoin, got %v", err)
+	}
+	if err := service.CanSubscribe(ctx, chat.ID, member); !errors.Is(err, ErrBanned) {
+		t.Errorf("Expected ErrBanned on subscribe, got %v", err)
+	}
+	invite, _ := service.CreateInvite(ctx, chat.ID, owner, nil, 0)
+	if _, err := service.AcceptInvite(ctx, invite.Token, member); !errors.Is(err, ErrBanned) {
+		t.Errorf("Expected ErrBanned through an invite, got %v", err)
+	}
+
+	t.Run("Non-members can be banned ahead of time", func(t *testing.T) {
+		if _, err := service.BanMember(ctx, chat.ID, owner, outsider, time.Hour, ""); err != nil {
+			t.Fatalf("BanMember failed: %v", err)
+		}
+		if err := service.JoinChat(ctx, chat.ID, outsider); !errors.Is(err, ErrBanned) {
+			t.Errorf("Expected ErrBanned, got %v", err)
+		}
+		if len(listener.left) != 1 {
+			t.Errorf("Expected no leave notification for a non-member, got %v", listener.left)
+		}
+	})
+
+	t.Run("Expired bans no longer apply", func(t *testing.T) {
+		past := time.Now().Add(-time.Minute)
+		repo.bans[chat.ID][outsider].ExpiresAt = &past
+		if err := service.JoinChat(ctx, chat.ID, outsider); err != nil {
+			t.Errorf("Expected to join after the ban expired, got %v", err)
+		}
+	})
+
+	t.Run("Unban", func(t *testing.T) {
+		if err := service.UnbanMember(ctx, chat.ID, owner, member); err != nil {
+			t.Fatalf("UnbanMember failed: %v", err)
+		}
+		if err := service.JoinChat(ctx, chat.ID, member); err != nil {
+			t.Errorf("Expected to rejoin after unban, got %v", err)
+		}
+		if err := service.UnbanMember(ctx, chat.ID, owner, member); !errors.Is(err, ErrBanNotFound) {
+			t.Errorf("Expected ErrBanNotFound, got %v", err)
+		}
+	})
+
+	members, err := service.ListMembers(ctx, chat.ID, member, false)
+	if err != nil {
+		t.Fatalf("ListMembers failed: %v", err)
+	}
+	if len(members) != 3 {
+		t.Errorf("Expected 3 members, got %d", len(members))
+	}
+	if _, err := service.ListMembers(ctx, chat.ID, uuid.New(), false); !errors.Is(err, ErrNotChatMember) {
+		t.Errorf("Expected ErrNotChatMember for a non-member, got %v", err)
+	}
+
+	service.SetProfileLookup(staticProfiles{owner: {ID: owner, Username: "owner@example.com"}})
+	members, err = service.ListMembers(ctx, chat.ID, member, true)
+	if err != nil {
+		t.Fatalf("ListMembers failed: %v", err)
+	}
+	for _, m := range members {
+		if (m.Profile != nil) != (m.UserID == owner) {
+			t.Errorf("Unexpected profile %+v for member %s", m.Profile, m.UserID)
+		}
+	}
+}
+
+// unverifiedUsers refuses every restricted action to the users in it
+type unverifiedUsers map[uuid.UUID]bool
+
+func (u unverifiedUsers) RequireVerified(ctx context.Context, userID uuid.UUID, action UnverifiedAction) error {
+	if u[userID] {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+func TestChatService_UnverifiedUsersRestricted(t *testing.T) {
+	ctx := context.Background()
+	alice := &model.User{ID: uuid.New(), Username: "alice@example.com", EmailVerified: true}
+	bob := &model.User{ID: uuid.New(), Username: "bob@example.com"}
+	repo := &mockRepository{
+		chats:     make(map[uuid.UUID]*model.Chat),
+		chatUsers: make(map[uuid.UUID]map[uuid.UUID]bool),
+		users:     map[uuid.UUID]*model.User{alice.ID: alice, bob.ID: bob},
+	}
+	service := NewChatService(repo)
+	service.SetVerificationGate(unverifiedUsers{bob.ID: true})
+
+	chat, err := service.CreateChat(ctx, "general", alice.ID, model.VisibilityPublic)
+	if err != nil {
+		t.Fatalf("CreateChat failed for a verified user: %v", err)
+	}
+	if _, err := service.CreateChat(ctx, "bob's", bob.ID, model.VisibilityPublic); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("Expected ErrEmailNotVerified creating a chat, got %v", err)
+	}
+	if err := service.JoinChat(ctx, chat.ID, bob.ID); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("Expected ErrEmailNotVerified joining a chat, got %v", err)
+	}
+	if _, err := service.OpenDirectChat(ctx, bob.ID, alice.ID); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("Expected ErrEmailNotVerified opening a direct chat, got %v", err)
+	}
+	if member, _ := repo.IsMember(ctx, chat.ID, bob.ID); member {
+		t.Error("Expected the unverified user to stay out of the chat")
+	}
+}
@@ -2,8 +2,14 @@ package service
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"rtcs/internal/model"
 
@@ -21,25 +27,89 @@ type Message struct {
 
 type MessageRepository interface {
 	SaveMessage(ctx context.Context, message *model.Message) error
-	GetMessages(ctx context.Context, chatID uuid.UUID, limit int) ([]*model.Message, error)
+	GetMessages(ctx context.Context, chatID uuid.UUID, before, after *model.MessageCursor, limit int) ([]*model.Message, error)
+	GetMessagesAfter(ctx context.Context, chatID uuid.UUID, afterSeq int64, limit int) ([]*model.Message, error)
 	GetMessage(ctx context.Context, messageID uuid.UUID) (*model.Message, error)
+	GetReplies(ctx context.Context, parentID uuid.UUID, limit int) ([]*model.Message, error)
+	GetReplyStats(ctx context.Context, parentIDs []uuid.UUID) ([]*model.ReplyStats, error)
+	EditMessage(ctx context.Context, message *model.Message, edit *model.MessageEdit) error
+	AddReaction(ctx context.Context, reaction *model.MessageReaction) (bool, error)
+	RemoveReaction(ctx context.Context, reaction *model.MessageReaction) (bool, error)
+	GetReactionCounts(ctx context.Context, messageIDs []uuid.UUID) ([]*model.ReactionCount, error)
+	GetMember(ctx context.Context, chatID, userID uuid.UUID) (*model.ChatUser, error)
 	DeleteMessage(ctx context.Context, messageID uuid.UUID) error
-	CreateChatIfNotExists(ctx context.Context, chat *model.Chat) error
-	AddUserToChat(ctx context.Context, chatID, userID uuid.UUID) error
 }
 
 type MessageCache interface {
 	SetMessage(ctx context.Context, message *model.Message) error
 	GetMessage(ctx context.Context, messageID string) (*model.Message, error)
 	DeleteMessage(ctx context.Context, messageID string) error
-	SetChatMessages(ctx context.Context, chatID string, messages []*model.Message) error
-	GetChatMessages(ctx context.Context, chatID string) ([]*model.Message, error)
+	SetChatPage(ctx context.Context, chatID, pageKey string, page *model.MessagePage) error
+	GetChatPage(ctx context.Context, chatID, pageKey string) (*model.MessagePage, error)
+	DeleteChatPages(ctx context.Context, chatID string) error
+}
+
+// ErrNotMessageOwner is returned when a user changes a message they did not send
+var ErrNotMessageOwner = errors.New("unauthorized: user does not own this message")
+
+// ErrMessageNotFound is returned for messages that do not exist
+var ErrMessageNotFound = errors.New("message not found")
+
+// ErrParentNotFound is returned when a reply refers to a missing message or
+// to a message in another chat
+var ErrParentNotFound = errors.New("parent message not found in this chat")
+
+// ErrInvalidEmoji is returned for reactions that are not a short emoji
+var ErrInvalidEmoji = errors.New("invalid emoji")
+
+// ErrInvalidCursor is returned for history cursors that were not issued by
+// GetChatHistory
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// History page sizes
+const (
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 100
+)
+
+// HistoryQuery selects a page of chat history. At most one of Before and
+// After may be set; without either the latest page is returned.
+type HistoryQuery struct {
+	UserID uuid.UUID // Must be a member of the chat
+	Before string
+	After  string
+	Limit  int
+
+	EmbedProfiles bool // Attach each sender's profile
+}
+
+// maxEmojiLength bounds a reaction in bytes; long enough for ZWJ sequences
+const maxEmojiLength = 64
+
+// Thread is a root message together with its replies
+type Thread struct {
+	Root    *model.Message   `json:"root"`
+	Replies []*model.Message `json:"replies"`
+}
+
+// MessageListener is notified after a message has been persisted so it can be
+// delivered to live connections, whichever transport it was sent through
+type MessageListener interface {
+	MessageSent(message *model.Message)
+	MessageEdited(message *model.Message)
+	ReactionAdded(chatID uuid.UUID, reaction *model.MessageReaction)
+	ReactionRemoved(chatID uuid.UUID, reaction *model.MessageReaction)
 }
 
 // MessageService defines the interface for message operations
 type MessageService struct {
-	repo  MessageRepository
-	cache MessageCache
+	repo     MessageRepository
+	cache    MessageCache
+	auth     *Authorizer
+	listener MessageListener
+	profiles ProfileLookup
+	blocks   BlockList
+	verified VerificationGate
 }
 
 // NewMessageService creates a new message service
@@ -47,11 +117,34 @@ func NewMessageService(repo MessageRepository, cache MessageCache) *MessageServi
 	return &MessageService{
 		repo:  repo,
 		cache: cache,
+		auth:  NewAuthorizer(repo),
 	}
 }
 
-// SendMessage creates a new message
-func (s *MessageService) SendMessage(ctx context.Context, chatIDStr, senderIDStr, text string) (*model.Message, error) {
+// SetMessageListener registers the listener notified about new messages
+func (s *MessageService) SetMessageListener(listener MessageListener) {
+	s.listener = listener
+}
+
+// SetProfileLookup lets chat history embed sender profiles
+func (s *MessageService) SetProfileLookup(profiles ProfileLookup) {
+	s.profiles = profiles
+}
+
+// SetBlockList hides messages from users the reader has blocked
+func (s *MessageService) SetBlockList(blocks BlockList) {
+	s.blocks = blocks
+}
+
+// SetVerificationGate keeps users who have not verified their email from
+// sending messages if the gate's policy says so
+func (s *MessageService) SetVerificationGate(gate VerificationGate) {
+	s.verified = gate
+}
+
+// SendMessage creates a new message. If parentIDStr is set the message is a
+// reply, filed under the root of the parent's thread.
+func (s *MessageService) SendMessage(ctx context.Context, chatIDStr, senderIDStr, text, parentIDStr string) (*model.Message, error) {
 	// Validate input
 	if text == "" {
 		return nil, fmt.Errorf("message text cannot be empty")
@@ -69,24 +162,28 @@ func (s *MessageService) SendMessage(ctx context.Context, chatIDStr, senderIDStr
 		return nil, fmt.Errorf("invalid sender ID: %w", err)
 	}
 
-	// Create a new chat if it doesn't exist
-	chat := &model.Chat{
-		ID:   chatID,
-		Name: fmt.Sprintf("Chat %s", chatID.String()),
+	// Only members may post; chats are created and joined through ChatService
+	if _, err := s.auth.Authorize(ctx, chatID, senderID, PermSendMessage); err != nil {
+		return nil, err
 	}
-	if err := s.repo.CreateChatIfNotExists(ctx, chat); err != nil {
-		return nil, fmt.Errorf("failed to create chat: %w", err)
+	if s.verified != nil {
+		if err := s.verified.RequireVerified(ctx, senderID, RestrictSendMessages); err != nil {
+			return nil, err
+		}
 	}
 
-	// Add user to chat if not already a member
-	if err := s.repo.AddUserToChat(ctx, chatID, senderID); err != nil {
-		return nil, fmt.Errorf("failed to add user to chat: %w", err)
+	var parentID *uuid.UUID
+	if parentIDStr != "" {
+		if parentID, err = s.threadRoot(ctx, chatID, parentIDStr); err != nil {
+			return nil, err
+		}
 	}
 
 	message := &model.Message{
 		ID:        uuid.New(),
 		ChatID:    chatID,
 		SenderID:  senderID,
+		ParentID:  parentID,
 		Text:      text,
 		CreatedAt: time.Now(),
 	}
@@ -100,37 +197,457 @@ func (s *MessageService) SendMessage(ctx context.Context, chatIDStr, senderIDStr
 		// TODO: Add proper logging
 	}
 
+	// The cached history no longer includes the newest message
+	if err := s.cache.DeleteChatPages(ctx, chatIDStr); err != nil {
+		// Log error but don't fail the request
+		// TODO: Add proper logging
+	}
+
+	if s.listener != nil {
+		s.listener.MessageSent(message)
+	}
+
 	return message, nil
 }
 
-// GetChatHistory retrieves messages for a chat
-func (s *MessageService) GetChatHistory(ctx context.Context, chatIDStr string, limit int) ([]*model.Message, error) {
+// GetChatHistory retrieves a page of messages for a chat, newest first
+func (s *MessageService) GetChatHistory(ctx context.Context, chatIDStr string, query HistoryQuery) (*model.MessagePage, error) {
 	chatID, err := uuid.Parse(chatIDStr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid chat ID: %w", err)
 	}
+	if _, err := s.auth.Authorize(ctx, chatID, query.UserID, PermReadMessages); err != nil {
+		return nil, err
+	}
+	if query.Before != "" && query.After != "" {
+		return nil, fmt.Errorf("%w: only one of before and after may be set", ErrInvalidCursor)
+	}
+	before, err := decodeCursor(query.Before)
+	if err != nil {
+		return nil, err
+	}
+	after, err := decodeCursor(query.After)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	if limit > MaxHistoryLimit {
+		limit = MaxHistoryLimit
+	}
+
+	pageKey := fmt.Sprintf("before:%s:after:%s:limit:%d", query.Before, query.After, limit)
 
 	// Try to get from cache first
-	if messages, err := s.cache.GetChatMessages(ctx, chatIDStr); err == nil && messages != nil {
-		return messages, nil
+	if page, err := s.cache.GetChatPage(ctx, chatID.String(), pageKey); err == nil && page != nil {
+		return s.pageFor(ctx, page, query)
 	}
 
-	// If not in cache, get from database
-	messages, err := s.repo.GetMessages(ctx, chatID, limit)
+	// If not in cache, get from database. One extra row tells whether
+	// another page follows.
+	messages, err := s.repo.GetMessages(ctx, chatID, before, after, limit+1)
 	if err != nil {
 		return nil, err
 	}
 
+	page := &model.MessagePage{}
+	if len(messages) > limit {
+		if after != nil {
+			// Newest first, so the extra row is the newest one
+			messages = messages[1:]
+			page.NextCursor = encodeCursor(messages[0])
+		} else {
+			messages = messages[:limit]
+			page.NextCursor = encodeCursor(messages[limit-1])
+		}
+	}
+	if messages == nil {
+		messages = []*model.Message{}
+	}
+	page.Messages = messages
+
+	if err := s.attachReplyStats(ctx, messages); err != nil {
+		return nil, err
+	}
+	if err := s.attachReactions(ctx, messages); err != nil {
+		return nil, err
+	}
+
 	// Update cache
-	if err := s.cache.SetChatMessages(ctx, chatIDStr, messages); err != nil {
+	if err := s.cache.SetChatPage(ctx, chatID.String(), pageKey, page); err != nil {
+		// Log error but don't fail the request
+		// TODO: Add proper logging
+	}
+
+	return s.pageFor(ctx, page, query)
+}
+
+// pageFor tailors a history page, which is cached for all readers, to one
+// reader: messages from users they blocked are left out and sender profiles
+// are attached if asked for. This happens after caching so that blocks and
+// profile changes apply at once. The cursor still points past the hidden
+// messages, so a page may hold fewer messages than the limit.
+func (s *MessageService) pageFor(ctx context.Context, page *model.MessagePage, query HistoryQuery) (*model.MessagePage, error) {
+	messages, err := s.withoutBlocked(ctx, query.UserID, page.Messages)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.attachSenders(ctx, messages, query.EmbedProfiles); err != nil {
+		return nil, err
+	}
+	return &model.MessagePage{Messages: messages, NextCursor: page.NextCursor}, nil
+}
+
+// withoutBlocked returns the messages not sent by users the reader blocked
+func (s *MessageService) withoutBlocked(ctx context.Context, readerID uuid.UUID, messages []*model.Message) ([]*model.Message, error) {
+	if s.blocks == nil {
+		return messages, nil
+	}
+	blocked, err := s.blocks.BlockedUsers(ctx, readerID)
+	if err != nil || len(blocked) == 0 {
+		return messages, err
+	}
+
+	visible := make([]*model.Message, 0, len(messages))
+	for _, message := range messages {
+		if !blocked[message.SenderID] {
+			visible = append(visible, message)
+		}
+	}
+	return visible, nil
+}
+
+// encodeCursor returns an opaque cursor pointing at a message
+func encodeCursor(message *model.Message) string {
+	raw := strconv.FormatInt(message.CreatedAt.UnixMicro(), 10) + ":" + message.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor parses a cursor from encodeCursor; an empty cursor yields nil
+func decodeCursor(cursor string) (*model.MessageCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	micros, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	usec, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	messageID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &model.MessageCursor{CreatedAt: time.UnixMicro(usec), ID: messageID}, nil
+}
+
+// GetThread retrieves the thread a message belongs to: its root and up to
+// limit replies, oldest first. limit is capped like history pages.
+func (s *MessageService) GetThread(ctx context.Context, messageIDStr, userIDStr string, limit int) (*Thread, error) {
+	messageID, err := uuid.Parse(messageIDStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message ID: %w", err)
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	root, err := s.repo.GetMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, ErrMessageNotFound
+	}
+	if root.ParentID != nil {
+		if root, err = s.repo.GetMessage(ctx, *root.ParentID); err != nil {
+			return nil, err
+		}
+		if root == nil {
+			return nil, ErrMessageNotFound
+		}
+	}
+	if _, err := s.auth.Authorize(ctx, root.ChatID, userID, PermReadMessages); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	if limit > MaxHistoryLimit {
+		limit = MaxHistoryLimit
+	}
+	replies, err := s.repo.GetReplies(ctx, root.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	if replies, err = s.withoutBlocked(ctx, userID, replies); err != nil {
+		return nil, err
+	}
+	if err := s.attachReplyStats(ctx, []*model.Message{root}); err != nil {
+		return nil, err
+	}
+
+	return &Thread{Root: root, Replies: replies}, nil
+}
+
+// threadRoot resolves the message a reply is filed under. Threads are one
+// level deep, so replying to a reply joins the original thread.
+func (s *MessageService) threadRoot(ctx context.Context, chatID uuid.UUID, parentIDStr string) (*uuid.UUID, error) {
+	parentID, err := uuid.Parse(parentIDStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parent message ID: %w", err)
+	}
+
+	parent, err := s.repo.GetMessage(ctx, parentID)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil || parent.ChatID != chatID {
+		return nil, ErrParentNotFound
+	}
+	if parent.ParentID != nil {
+		return parent.ParentID, nil
+	}
+	return &parent.ID, nil
+}
+
+// attachReplyStats fills in the thread summary of each message
+func (s *MessageService) attachReplyStats(ctx context.Context, messages []*model.Message) error {
+	ids := make([]uuid.UUID, 0, len(messages))
+	for _, message := range messages {
+		if message.ParentID == nil {
+			ids = append(ids, message.ID)
+		}
+	}
+
+	stats, err := s.repo.GetReplyStats(ctx, ids)
+	if err != nil {
+		return err
+	}
+	byParent := make(map[uuid.UUID]*model.ReplyStats, len(stats))
+	for _, st := range stats {
+		byParent[st.ParentID] = st
+	}
+
+	for _, message := range messages {
+		if st, ok := byParent[message.ID]; ok {
+			lastReplyAt := st.LastReplyAt
+			message.ReplyCount = st.Count
+			message.LastReplyAt = &lastReplyAt
+		}
+	}
+	return nil
+}
+
+// attachSenders fills in the sender profile of each message if asked to
+func (s *MessageService) attachSenders(ctx context.Context, messages []*model.Message, embed bool) error {
+	if !embed || s.profiles == nil {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(messages))
+	for i, message := range messages {
+		ids[i] = message.SenderID
+	}
+	profiles, err := s.profiles.GetProfiles(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		message.Sender = profiles[message.SenderID]
+	}
+	return nil
+}
+
+// attachReactions fills in the per-emoji reaction counts of each message
+func (s *MessageService) attachReactions(ctx context.Context, messages []*model.Message) error {
+	ids := make([]uuid.UUID, len(messages))
+	byID := make(map[uuid.UUID]*model.Message, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+		byID[message.ID] = message
+		message.Reactions = nil
+	}
+
+	counts, err := s.repo.GetReactionCounts(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, count := range counts {
+		if message, ok := byID[count.MessageID]; ok {
+			message.Reactions = append(message.Reactions, *count)
+		}
+	}
+	return nil
+}
+
+// GetMessagesSince retrieves up to limit messages of a chat newer than
+// afterSeq, oldest first, for replaying to a resuming client
+func (s *MessageService) GetMessagesSince(ctx context.Context, chatIDStr string, afterSeq int64, limit int) ([]*model.Message, error) {
+	chatID, err := uuid.Parse(chatIDStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chat ID: %w", err)
+	}
+	if afterSeq < 0 {
+		afterSeq = 0
+	}
+
+	return s.repo.GetMessagesAfter(ctx, chatID, afterSeq, limit)
+}
+
+// EditMessage replaces the text of a message, keeping the old text as a revision
+func (s *MessageService) EditMessage(ctx context.Context, messageIDStr, userIDStr, text string) (*model.Message, error) {
+	if text == "" {
+		return nil, fmt.Errorf("message text cannot be empty")
+	}
+	messageID, err := uuid.Parse(messageIDStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message ID: %w", err)
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	message, err := s.repo.GetMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message == nil {
+		return nil, ErrMessageNotFound
+	}
+
+	// Only the sender may edit, and only while they may still send
+	if message.SenderID != userID {
+		return nil, ErrNotMessageOwner
+	}
+	if _, err := s.auth.Authorize(ctx, message.ChatID, userID, PermSendMessage); err != nil {
+		return nil, err
+	}
+	if message.Text == text {
+		return message, nil
+	}
+
+	now := time.Now()
+	edit := &model.MessageEdit{
+		ID:        uuid.New(),
+		MessageID: message.ID,
+		Text:      message.Text,
+		EditedAt:  now,
+	}
+	message.Text = text
+	message.UpdatedAt = now
+
+	if err := s.repo.EditMessage(ctx, message, edit); err != nil {
+		return nil, err
+	}
+
+	// Patch the cached message and drop cached history holding the old text
+	if err := s.cache.SetMessage(ctx, message); err != nil {
 		// Log error but don't fail the request
 		// TODO: Add proper logging
 	}
+	if err := s.cache.DeleteChatPages(ctx, message.ChatID.String()); err != nil {
+		// Log error but don't fail the request
+		// TODO: Add proper logging
+	}
+
+	if s.listener != nil {
+		s.listener.MessageEdited(message)
+	}
 
-	return messages, nil
+	return message, nil
 }
 
-// DeleteMessage removes a message
+// AddReaction reacts to a message with an emoji. Reacting twice with the same
+// emoji is a no-op.
+func (s *MessageService) AddReaction(ctx context.Context, messageIDStr, userIDStr, emoji string) error {
+	message, reaction, err := s.reaction(ctx, messageIDStr, userIDStr, emoji)
+	if err != nil {
+		return err
+	}
+	reaction.CreatedAt = time.Now()
+
+	added, err := s.repo.AddReaction(ctx, reaction)
+	if err != nil || !added {
+		return err
+	}
+
+	if err := s.cache.DeleteChatPages(ctx, message.ChatID.String()); err != nil {
+		// Log error but don't fail the request
+		// TODO: Add proper logging
+	}
+	if s.listener != nil {
+		s.listener.ReactionAdded(message.ChatID, reaction)
+	}
+	return nil
+}
+
+// RemoveReaction takes back a reaction. Removing a missing reaction is a no-op.
+func (s *MessageService) RemoveReaction(ctx context.Context, messageIDStr, userIDStr, emoji string) error {
+	message, reaction, err := s.reaction(ctx, messageIDStr, userIDStr, emoji)
+	if err != nil {
+		return err
+	}
+
+	removed, err := s.repo.RemoveReaction(ctx, reaction)
+	if err != nil || !removed {
+		return err
+	}
+
+	if err := s.cache.DeleteChatPages(ctx, message.ChatID.String()); err != nil {
+		// Log error but don't fail the request
+		// TODO: Add proper logging
+	}
+	if s.listener != nil {
+		s.listener.ReactionRemoved(message.ChatID, reaction)
+	}
+	return nil
+}
+
+// reaction validates a reaction request and resolves the message it targets.
+// Only members of the message's chat whose role allows it may react.
+func (s *MessageService) reaction(ctx context.Context, messageIDStr, userIDStr, emoji string) (*model.Message, *model.MessageReaction, error) {
+	if emoji == "" || len(emoji) > maxEmojiLength || !utf8.ValidString(emoji) ||
+		strings.IndexFunc(emoji, unicode.IsSpace) >= 0 {
+		return nil, nil, ErrInvalidEmoji
+	}
+	messageID, err := uuid.Parse(messageIDStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid message ID: %w", err)
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	message, err := s.repo.GetMessage(ctx, messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if message == nil {
+		return nil, nil, ErrMessageNotFound
+	}
+
+	if _, err := s.auth.Authorize(ctx, message.ChatID, userID, PermReact); err != nil {
+		return nil, nil, err
+	}
+
+	return message, &model.MessageReaction{MessageID: messageID, UserID: userID, Emoji: emoji}, nil
+}
+
+// DeleteMessage removes a message. Senders may delete their own messages;
+// deleting someone else's needs PermDeleteAnyMessage.
 func (s *MessageService) DeleteMessage(ctx context.Context, messageIDStr string, userIDStr string) error {
 	messageID, err := uuid.Parse(messageIDStr)
 	if err != nil {
@@ -146,10 +663,19 @@ func (s *MessageService) DeleteMessage(ctx context.Context, messageIDStr string,
 	if err != nil {
 		return err
 	}
+	if message == nil {
+		return ErrMessageNotFound
+	}
 
-	// Check if the user owns the message
+	perm := PermReadMessages
 	if message.SenderID != userID {
-		return fmt.Errorf("unauthorized: user does not own this message")
+		perm = PermDeleteAnyMessage
+	}
+	if _, err := s.auth.Authorize(ctx, message.ChatID, userID, perm); err != nil {
+		if errors.Is(err, ErrPermissionDenied) {
+			return ErrNotMessageOwner
+		}
+		return err
 	}
 
 	// Delete from database first
@@ -162,6 +688,10 @@ func (s *MessageService) DeleteMessage(ctx context.Context, messageIDStr string,
 		// Log error but don't fail the request
 		// TODO: Add proper logging
 	}
+	if err := s.cache.DeleteChatPages(ctx, message.ChatID.String()); err != nil {
+		// Log error but don't fail the request
+		// TODO: Add proper logging
+	}
 
 	return nil
 }
@@ -2,7 +2,11 @@ package service
 
 import (
 	"context"
+	"errors"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"rtcs/internal/model"
 
@@ -11,25 +15,71 @@ import (
 
 // MockRepository implements the MessageRepository interface for testing
 type MockRepository struct {
-	messages map[string]*model.Message
+	messages  map[string]*model.Message
+	seqs      map[uuid.UUID]int64
+	edits     []*model.MessageEdit
+	reactions map[model.MessageReaction]bool
+	members   map[uuid.UUID]map[uuid.UUID]model.ChatRole
+	// repliesLimit is the limit GetReplies was last asked for
+	repliesLimit int
 }
 
 func NewMockRepository() *MockRepository {
 	return &MockRepository{
-		messages: make(map[string]*model.Message),
+		messages:  make(map[string]*model.Message),
+		seqs:      make(map[uuid.UUID]int64),
+		reactions: make(map[model.MessageReaction]bool),
+		members:   make(map[uuid.UUID]map[uuid.UUID]model.ChatRole),
 	}
 }
 
 func (m *MockRepository) SaveMessage(ctx context.Context, message *model.Message) error {
+	m.seqs[message.ChatID]++
+	message.Seq = m.seqs[message.ChatID]
 	m.messages[message.ID.String()] = message
 	return nil
 }
 
-func (m *MockRepository) GetMessages(ctx context.Context, chatID uuid.UUID, limit int) ([]*model.Message, error) {
+func (m *MockRepository) GetMessagesAfter(ctx context.Context, chatID uuid.UUID, afterSeq int64, limit int) ([]*model.Message, error) {
+	var messages []*model.Message
+	for seq := afterSeq + 1; seq <= m.seqs[chatID] && len(messages) < limit; seq++ {
+		for _, msg := range m.messages {
+			if msg.ChatID == chatID && msg.Seq == seq {
+				messages = append(messages, msg)
+			}
+		}
+	}
+	return messages, nil
+}
+
+func (m *MockRepository) GetMessages(ctx context.Context, chatID uuid.UUID, before, after *model.MessageCursor, limit int) ([]*model.Message, error) {
+	newer := func(a *model.Message, createdAt time.Time, id uuid.UUID) bool {
+		if !a.CreatedAt.Equal(createdAt) {
+			return a.CreatedAt.After(createdAt)
+		}
+		return a.ID.String() > id.String()
+	}
+
 	var messages []*model.Message
 	for _, msg := range m.messages {
-		if msg.ChatID == chatID {
-			messages = append(messages, msg)
+		if msg.ChatID != chatID {
+			continue
+		}
+		if before != nil && !newer(&model.Message{CreatedAt: before.CreatedAt, ID: before.ID}, msg.CreatedAt, msg.ID) {
+			continue
+		}
+		if after != nil && !newer(msg, after.CreatedAt, after.ID) {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	sort.Slice(messages, func(i, j int) bool { return newer(messages[i], messages[j].CreatedAt, messages[j].ID) })
+
+	if len(messages) > limit {
+		if after != nil {
+			messages = messages[len(messages)-limit:]
+		} else {
+			messages = messages[:limit]
 		}
 	}
 	return messages, nil
@@ -42,27 +92,111 @@ func (m *MockRepository) GetMessage(ctx context.Context, messageID uuid.UUID) (*
 	return nil, nil
 }
 
-func (m *MockRepository) DeleteMessage(ctx context.Context, messageID uuid.UUID) error {
-	delete(m.messages, messageID.String())
+func (m *MockRepository) GetReplies(ctx context.Context, parentID uuid.UUID, limit int) ([]*model.Message, error) {
+	m.repliesLimit = limit
+	var replies []*model.Message
+	for _, msg := range m.messages {
+		if msg.ParentID != nil && *msg.ParentID == parentID {
+			replies = append(replies, msg)
+		}
+	}
+	return replies, nil
+}
+
+func (m *MockRepository) GetReplyStats(ctx context.Context, parentIDs []uuid.UUID) ([]*model.ReplyStats, error) {
+	var stats []*model.ReplyStats
+	for _, parentID := range parentIDs {
+		st := &model.ReplyStats{ParentID: parentID}
+		for _, msg := range m.messages {
+			if msg.ParentID == nil || *msg.ParentID != parentID {
+				continue
+			}
+			st.Count++
+			if msg.CreatedAt.After(st.LastReplyAt) {
+				st.LastReplyAt = msg.CreatedAt
+			}
+		}
+		if st.Count == 0 {
+			continue
+		}
+		stats = append(stats, st)
+	}
+	return stats, nil
+}
+
+func (m *MockRepository) AddReaction(ctx context.Context, reaction *model.MessageReaction) (bool, error) {
+	key := model.MessageReaction{MessageID: reaction.MessageID, UserID: reaction.UserID, Emoji: reaction.Emoji}
+	if m.reactions[key] {
+		return false, nil
+	}
+	m.reactions[key] = true
+	return true, nil
+}
+
+func (m *MockRepository) RemoveReaction(ctx context.Context, reaction *model.MessageReaction) (bool, error) {
+	key := model.MessageReaction{MessageID: reaction.MessageID, UserID: reaction.UserID, Emoji: reaction.Emoji}
+	if !m.reactions[key] {
+		return false, nil
+	}
+	delete(m.reactions, key)
+	return true, nil
+}
+
+func (m *MockRepository) GetReactionCounts(ctx context.Context, messageIDs []uuid.UUID) ([]*model.ReactionCount, error) {
+	var counts []*model.ReactionCount
+	for _, messageID := range messageIDs {
+		byEmoji := make(map[string]int64)
+		for reaction := range m.reactions {
+			if reaction.MessageID == messageID {
+				byEmoji[reaction.Emoji]++
+			}
+		}
+		for emoji, count := range byEmoji {
+			counts = append(counts, &model.ReactionCount{MessageID: messageID, Emoji: emoji, Count: count})
+		}
+	}
+	return counts, nil
+}
+
+func (m *MockRepository) GetMember(ctx context.Context, chatID, userID uuid.UUID) (*model.ChatUser, error) {
+	role, ok := m.members[chatID][userID]
+	if !ok {
+		return nil, nil
+	}
+	return &model.ChatUser{ChatID: chatID, UserID: userID, Role: role}, nil
+}
+
+func (m *MockRepository) EditMessage(ctx context.Context, message *model.Message, edit *model.MessageEdit) error {
+	m.edits = append(m.edits, edit)
+	m.messages[message.ID.String()] = message
 	return nil
 }
 
-func (m *MockRepository) CreateChatIfNotExists(ctx context.Context, chat *model.Chat) error {
+func (m *MockRepository) DeleteMessage(ctx context.Context, messageID uuid.UUID) error {
+	delete(m.messages, messageID.String())
 	return nil
 }
 
 func (m *MockRepository) AddUserToChat(ctx context.Context, chatID, userID uuid.UUID) error {
+	if m.members[chatID] == nil {
+		m.members[chatID] = make(map[uuid.UUID]model.ChatRole)
+	}
+	if _, ok := m.members[chatID][userID]; !ok {
+		m.members[chatID][userID] = model.RoleMember
+	}
 	return nil
 }
 
 // MockCache implements the MessageCache interface for testing
 type MockCache struct {
-	cache map[string][]*model.Message
+	cache   map[string][]*model.Message
+	history map[string]map[string]*model.MessagePage
 }
 
 func NewMockCache() *MockCache {
 	return &MockCache{
-		cache: make(map[string][]*model.Message),
+		cache:   make(map[string][]*model.Message),
+		history: make(map[string]map[string]*model.MessagePage),
 	}
 }
 
@@ -100,16 +234,44 @@ func (m *MockCache) DeleteMessage(ctx context.Context, messageID string) error {
 	return nil
 }
 
-func (m *MockCache) SetChatMessages(ctx context.Context, chatID string, messages []*model.Message) error {
-	m.cache[chatID] = messages
+func (m *MockCache) SetChatPage(ctx context.Context, chatID, pageKey string, page *model.MessagePage) error {
+	if m.history[chatID] == nil {
+		m.history[chatID] = make(map[string]*model.MessagePage)
+	}
+	m.history[chatID][pageKey] = page
 	return nil
 }
 
-func (m *MockCache) GetChatMessages(ctx context.Context, chatID string) ([]*model.Message, error) {
-	if messages, ok := m.cache[chatID]; ok {
-		return messages, nil
-	}
-	return nil, nil
+func (m *MockCache) GetChatPage(ctx context.Context, chatID, pageKey string) (*model.MessagePage, error) {
+	return m.history[chatID][pageKey], nil
+}
+
+func (m *MockCache) DeleteChatPages(ctx context.Context, chatID string) error {
+	delete(m.history, chatID)
+	return nil
+}
+
+// recordingListener collects messages passed to MessageSent
+type recordingListener struct {
+	sent      []*model.Message
+	edited    []*model.Message
+	reactions []string
+}
+
+func (l *recordingListener) MessageSent(message *model.Message) {
+	l.sent = append(l.sent, message)
+}
+
+func (l *recordingListener) MessageEdited(message *model.Message) {
+	l.edited = append(l.edited, message)
+}
+
+func (l *recordingListener) ReactionAdded(chatID uuid.UUID, reaction *model.MessageReaction) {
+	l.reactions = append(l.reactions, "+"+reaction.Emoji)
+}
+
+func (l *recordingListener) ReactionRemoved(chatID uuid.UUID, reaction *model.MessageReaction) {
+	l.reactions = append(l.reactions, "-"+reaction.Emoji)
 }
 
 func TestSendMessage(t *testing.T) {
@@ -124,7 +286,8 @@ func TestSendMessage(t *testing.T) {
 	t.Run("Send valid message", func(t *testing.T) {
 		chatID := uuid.New().String()
 		userID := uuid.New().String()
-		message, err := svc.SendMessage(ctx, chatID, userID, "Hello, world!")
+		repo.AddUserToChat(ctx, uuid.MustParse(chatID), uuid.MustParse(userID))
+		message, err := svc.SendMessage(ctx, chatID, userID, "Hello, world!", "")
 		if err != nil {
 			t.Fatalf("SendMessage failed: %v", err)
 		}
@@ -168,9 +331,17 @@ func TestSendMessage(t *testing.T) {
 		}
 	})
 
-	// Test case 2: Send message with empty text
+	// Test case 2: Chats are not created on the fly
+	t.Run("Send message to unknown chat", func(t *testing.T) {
+		_, err := svc.SendMessage(ctx, uuid.New().String(), uuid.New().String(), "Hello?", "")
+		if !errors.Is(err, ErrNotChatMember) {
+			t.Errorf("Expected ErrNotChatMember, got %v", err)
+		}
+	})
+
+	// Test case 3: Send message with empty text
 	t.Run("Send message with empty text", func(t *testing.T) {
-		message, err := svc.SendMessage(ctx, uuid.New().String(), uuid.New().String(), "")
+		message, err := svc.SendMessage(ctx, uuid.New().String(), uuid.New().String(), "", "")
 		if err == nil {
 			t.Error("Expected error for empty text, got nil")
 		}
@@ -179,3 +350,512 @@ func TestSendMessage(t *testing.T) {
 		}
 	})
 }
+
+func TestSendMessage_NotifiesListenerAndInvalidatesHistory(t *testing.T) {
+	repo := NewMockRepository()
+	cache := NewMockCache()
+	listener := &recordingListener{}
+	svc := NewMessageService(repo, cache)
+	svc.SetMessageListener(listener)
+
+	ctx := context.Background()
+	chatID := uuid.New().String()
+	senderID := uuid.New()
+	repo.AddUserToChat(ctx, uuid.MustParse(chatID), senderID)
+
+	// Prime the history cache with a stale page
+	cache.SetChatPage(ctx, chatID, "before::after::limit:50", &model.MessagePage{})
+
+	message, err := svc.SendMessage(ctx, chatID, senderID.String(), "Hello", "")
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	if len(listener.sent) != 1 || listener.sent[0].ID != message.ID {
+		t.Errorf("Expected listener to receive message %s, got %v", message.ID, listener.sent)
+	}
+
+	history, err := svc.GetChatHistory(ctx, chatID, HistoryQuery{UserID: senderID})
+	if err != nil {
+		t.Fatalf("GetChatHistory failed: %v", err)
+	}
+	if len(history.Messages) != 1 {
+		t.Errorf("Expected 1 message in history, got %d", len(history.Messages))
+	}
+}
+
+func TestGetMessagesSince(t *testing.T) {
+	repo := NewMockRepository()
+	svc := NewMessageService(repo, NewMockCache())
+	ctx := context.Background()
+
+	chatID := uuid.New().String()
+	senderID := uuid.New().String()
+	repo.AddUserToChat(ctx, uuid.MustParse(chatID), uuid.MustParse(senderID))
+	for _, text := range []string{"one", "two", "three"} {
+		if _, err := svc.SendMessage(ctx, chatID, senderID, text, ""); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+	}
+
+	messages, err := svc.GetMessagesSince(ctx, chatID, 1, 10)
+	if err != nil {
+		t.Fatalf("GetMessagesSince failed: %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages after seq 1, got %d", len(messages))
+	}
+	if messages[0].Text != "two" || messages[0].Seq != 2 {
+		t.Errorf("Expected 'two' with seq 2 first, got '%s' with seq %d", messages[0].Text, messages[0].Seq)
+	}
+
+	if _, err := svc.GetMessagesSince(ctx, "not-a-uuid", 0, 10); err == nil {
+		t.Error("Expected error for invalid chat ID")
+	}
+}
+
+func TestEditMessage(t *testing.T) {
+	repo := NewMockRepository()
+	cache := NewMockCache()
+	listener := &recordingListener{}
+	svc := NewMessageService(repo, cache)
+	svc.SetMessageListener(listener)
+	ctx := context.Background()
+
+	chatID := uuid.New().String()
+	senderID := uuid.New().String()
+	repo.AddUserToChat(ctx, uuid.MustParse(chatID), uuid.MustParse(senderID))
+	message, err := svc.SendMessage(ctx, chatID, senderID, "helo", "")
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	t.Run("Owner edits message", func(t *testing.T) {
+		cache.SetChatPage(ctx, chatID, "latest", &model.MessagePage{Messages: []*model.Message{message}})
+
+		edited, err := svc.EditMessage(ctx, message.ID.String(), senderID, "hello")
+		if err != nil {
+			t.Fatalf("EditMessage failed: %v", err)
+		}
+		if edited.Text != "hello" {
+			t.Errorf("Expected text 'hello', got '%s'", edited.Text)
+		}
+		if len(repo.edits) != 1 || repo.edits[0].Text != "helo" {
+			t.Errorf("Expected the old text to be kept as a revision, got %v", repo.edits)
+		}
+		if len(listener.edited) != 1 {
+			t.Errorf("Expected 1 edit notification, got %d", len(listener.edited))
+		}
+		if page, _ := cache.GetChatPage(ctx, chatID, "latest"); page != nil {
+			t.Error("Expected cached history to be invalidated")
+		}
+	})
+
+	t.Run("Edits invalidate history read by any spelling of the chat ID", func(t *testing.T) {
+		query := HistoryQuery{UserID: uuid.MustParse(senderID)}
+		for _, spelling := range []string{strings.ToUpper(chatID), "{" + chatID + "}"} {
+			if _, err := svc.GetChatHistory(ctx, spelling, query); err != nil {
+				t.Fatalf("GetChatHistory failed: %v", err)
+			}
+		}
+		if _, err := svc.EditMessage(ctx, message.ID.String(), senderID, "hello again"); err != nil {
+			t.Fatalf("EditMessage failed: %v", err)
+		}
+		if len(cache.history) != 0 {
+			t.Errorf("Expected no cached history after the edit, got pages for %d chat keys", len(cache.history))
+		}
+	})
+
+	t.Run("Other user cannot edit", func(t *testing.T) {
+		_, err := svc.EditMessage(ctx, message.ID.String(), uuid.New().String(), "hijacked")
+		if !errors.Is(err, ErrNotMessageOwner) {
+			t.Errorf("Expected ErrNotMessageOwner, got %v", err)
+		}
+	})
+
+	t.Run("Empty text is rejected", func(t *testing.T) {
+		if _, err := svc.EditMessage(ctx, message.ID.String(), senderID, ""); err == nil {
+			t.Error("Expected error for empty text")
+		}
+	})
+}
+
+func TestThreads(t *testing.T) {
+	repo := NewMockRepository()
+	svc := NewMessageService(repo, NewMockCache())
+	ctx := context.Background()
+
+	chatID := uuid.New().String()
+	userID := uuid.New().String()
+	repo.AddUserToChat(ctx, uuid.MustParse(chatID), uuid.MustParse(userID))
+	root, err := svc.SendMessage(ctx, chatID, userID, "Lunch?", "")
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	reply, err := svc.SendMessage(ctx, chatID, userID, "Sure", root.ID.String())
+	if err != nil {
+		t.Fatalf("Reply failed: %v", err)
+	}
+	if reply.ParentID == nil || *reply.ParentID != root.ID {
+		t.Fatalf("Expected reply to point at %s, got %v", root.ID, reply.ParentID)
+	}
+
+	t.Run("Reply to a reply joins the root thread", func(t *testing.T) {
+		nested, err := svc.SendMessage(ctx, chatID, userID, "Where?", reply.ID.String())
+		if err != nil {
+			t.Fatalf("Reply failed: %v", err)
+		}
+		if nested.ParentID == nil || *nested.ParentID != root.ID {
+			t.Errorf("Expected nested reply to point at root %s, got %v", root.ID, nested.ParentID)
+		}
+	})
+
+	t.Run("Parent must be in the same chat", func(t *testing.T) {
+		otherChat := uuid.New()
+		repo.AddUserToChat(ctx, otherChat, uuid.MustParse(userID))
+		_, err := svc.SendMessage(ctx, otherChat.String(), userID, "Hijack", root.ID.String())
+		if !errors.Is(err, ErrParentNotFound) {
+			t.Errorf("Expected ErrParentNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Thread has root and replies", func(t *testing.T) {
+		thread, err := svc.GetThread(ctx, reply.ID.String(), userID, 50)
+		if err != nil {
+			t.Fatalf("GetThread failed: %v", err)
+		}
+		if thread.Root.ID != root.ID {
+			t.Errorf("Expected root %s, got %s", root.ID, thread.Root.ID)
+		}
+		if len(thread.Replies) != 2 || thread.Root.ReplyCount != 2 {
+			t.Errorf("Expected 2 replies, got %d (count %d)", len(thread.Replies), thread.Root.ReplyCount)
+		}
+	})
+
+	t.Run("Thread limit is capped", func(t *testing.T) {
+		for limit, want := range map[int]int{0: DefaultHistoryLimit, -5: DefaultHistoryLimit, 10: 10, 1 << 30: MaxHistoryLimit} {
+			if _, err := svc.GetThread(ctx, root.ID.String(), userID, limit); err != nil {
+				t.Fatalf("GetThread failed: %v", err)
+			}
+			if repo.repliesLimit != want {
+				t.Errorf("GetThread(limit %d) asked for %d replies, want %d", limit, repo.repliesLimit, want)
+			}
+		}
+	})
+
+	t.Run("Thread of a missing message", func(t *testing.T) {
+		if _, err := svc.GetThread(ctx, uuid.New().String(), userID, 50); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("Expected ErrMessageNotFound, got %v", err)
+		}
+	})
+
+	t.Run("History carries reply counts", func(t *testing.T) {
+		history, err := svc.GetChatHistory(ctx, chatID, HistoryQuery{UserID: uuid.MustParse(userID)})
+		if err != nil {
+			t.Fatalf("GetChatHistory failed: %v", err)
+		}
+		for _, msg := range history.Messages {
+			if msg.ID != root.ID {
+				continue
+			}
+			if msg.ReplyCount != 2 || msg.LastReplyAt == nil {
+				t.Errorf("Expected 2 replies with a last reply time, got %d, %v", msg.ReplyCount, msg.LastReplyAt)
+			}
+			return
+		}
+		t.Error("Root message missing from history")
+	})
+}
+
+func TestReactions(t *testing.T) {
+	repo := NewMockRepository()
+	cache := NewMockCache()
+	listener := &recordingListener{}
+	svc := NewMessageService(repo, cache)
+	svc.SetMessageListener(listener)
+	ctx := context.Background()
+
+	chatID := uuid.New().String()
+	alice := uuid.New().String()
+	bob := uuid.New().String()
+	repo.AddUserToChat(ctx, uuid.MustParse(chatID), uuid.MustParse(alice))
+	message, err := svc.SendMessage(ctx, chatID, alice, "Release is out", "")
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	repo.AddUserToChat(ctx, message.ChatID, uuid.MustParse(bob))
+
+	t.Run("Members react once per emoji", func(t *testing.T) {
+		for _, userID := range []string{alice, bob, bob} {
+			if err := svc.AddReaction(ctx, message.ID.String(), userID, "🎉"); err != nil {
+				t.Fatalf("AddReaction failed: %v", err)
+			}
+		}
+		if len(listener.reactions) != 2 {
+			t.Errorf("Expected 2 reaction events, got %v", listener.reactions)
+		}
+
+		history, err := svc.GetChatHistory(ctx, chatID, HistoryQuery{UserID: uuid.MustParse(alice)})
+		if err != nil {
+			t.Fatalf("GetChatHistory failed: %v", err)
+		}
+		if len(history.Messages) != 1 || len(history.Messages[0].Reactions) != 1 || history.Messages[0].Reactions[0].Count != 2 {
+			t.Errorf("Expected one emoji with 2 reactions, got %+v", history.Messages[0].Reactions)
+		}
+	})
+
+	t.Run("Removing updates history", func(t *testing.T) {
+		if err := svc.RemoveReaction(ctx, message.ID.String(), bob, "🎉"); err != nil {
+			t.Fatalf("RemoveReaction failed: %v", err)
+		}
+		history, _ := svc.GetChatHistory(ctx, chatID, HistoryQuery{UserID: uuid.MustParse(alice)})
+		if history.Messages[0].Reactions[0].Count != 1 {
+			t.Errorf("Expected 1 reaction after removal, got %+v", history.Messages[0].Reactions)
+		}
+	})
+
+	t.Run("Non-members cannot react", func(t *testing.T) {
+		err := svc.AddReaction(ctx, message.ID.String(), uuid.New().String(), "🎉")
+		if !errors.Is(err, ErrNotChatMember) {
+			t.Errorf("Expected ErrNotChatMember, got %v", err)
+		}
+	})
+
+	t.Run("Invalid emoji is rejected", func(t *testing.T) {
+		for _, emoji := range []string{"", "two words", strings.Repeat("x", maxEmojiLength+1)} {
+			if err := svc.AddReaction(ctx, message.ID.String(), alice, emoji); !errors.Is(err, ErrInvalidEmoji) {
+				t.Errorf("Expected ErrInvalidEmoji for %q, got %v", emoji, err)
+			}
+		}
+	})
+}
+
+func TestGetChatHistory_Pagination(t *testing.T) {
+	repo := NewMockRepository()
+	cache := NewMockCache()
+	svc := NewMessageService(repo, cache)
+	ctx := context.Background()
+
+	chatID, reader := uuid.New(), uuid.New()
+	repo.AddUserToChat(ctx, chatID, reader)
+	start := time.Now().Truncate(time.Microsecond)
+	for i := 0; i < 5; i++ {
+		repo.SaveMessage(ctx, &model.Message{
+			ID:        uuid.New(),
+			ChatID:    chatID,
+			SenderID:  uuid.New(),
+			Text:      string(rune('a' + i)),
+			CreatedAt: start.Add(time.Duration(i) * time.Second),
+		})
+	}
+
+	texts := func(page *model.MessagePage) string {
+		var s string
+		for _, msg := range page.Messages {
+			s += msg.Text
+		}
+		return s
+	}
+
+	latest, err := svc.GetChatHistory(ctx, chatID.String(), HistoryQuery{UserID: reader, Limit: 2})
+	if err != nil {
+		t.Fatalf("GetChatHistory failed: %v", err)
+	}
+	if texts(latest) != "ed" || latest.NextCursor == "" {
+		t.Fatalf("Expected latest page 'ed' with a cursor, got %q (%q)", texts(latest), latest.NextCursor)
+	}
+
+	older, err := svc.GetChatHistory(ctx, chatID.String(), HistoryQuery{UserID: reader, Before: latest.NextCursor, Limit: 2})
+	if err != nil {
+		t.Fatalf("GetChatHistory failed: %v", err)
+	}
+	if texts(older) != "cb" {
+		t.Errorf("Expected older page 'cb', got %q", texts(older))
+	}
+
+	oldest, _ := svc.GetChatHistory(ctx, chatID.String(), HistoryQuery{UserID: reader, Before: older.NextCursor, Limit: 2})
+	if texts(oldest) != "a" || oldest.NextCursor != "" {
+		t.Errorf("Expected last page 'a' without a cursor, got %q (%q)", texts(oldest), oldest.NextCursor)
+	}
+
+	// Scrolling forward again from the oldest page
+	newer, _ := svc.GetChatHistory(ctx, chatID.String(), HistoryQuery{UserID: reader, After: encodeCursor(oldest.Messages[0]), Limit: 2})
+	if texts(newer) != "cb" || newer.NextCursor == "" {
+		t.Errorf("Expected newer page 'cb' with a cursor, got %q (%q)", texts(newer), newer.NextCursor)
+	}
+
+	t.Run("Pages are cached separately", func(t *testing.T) {
+		if len(cache.history[chatID.String()]) != 4 {
+			t.Errorf("Expected 4 cached pages, got %d", len(cache.history[chatID.String()]))
+		}
+	})
+
+	t.Run("Page size is capped", func(t *testing.T) {
+		page, _ := svc.GetChatHistory(ctx, chatID.String(), HistoryQuery{UserID: reader, Limit: MaxHistoryLimit + 1})
+		if len(page.Messages) != 5 {
+			t.Errorf("Expected 5 messages, got %d", len(page.Messages))
+		}
+	})
+
+	t.Run("Invalid cursors are rejected", func(t *testing.T) {
+		for _, query := range []HistoryQuery{
+			{UserID: reader, Before: "not-a-cursor"},
+			{UserID: reader, Before: latest.NextCursor, After: latest.NextCursor},
+		} {
+			if _, err := svc.GetChatHistory(ctx, chatID.String(), query); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("Expected ErrInvalidCursor for %+v, got %v", query, err)
+			}
+		}
+	})
+}
+
+// staticProfiles serves profiles from a map
+type staticProfiles map[uuid.UUID]*model.UserProfile
+
+func (p staticProfiles) GetProfiles(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*model.UserProfile, error) {
+	found := make(map[uuid.UUID]*model.UserProfile)
+	for _, id := range ids {
+		if profile, ok := p[id]; ok {
+			found[id] = profile
+		}
+	}
+	return found, nil
+}
+
+func TestGetChatHistory_EmbedProfiles(t *testing.T) {
+	repo := NewMockRepository()
+	svc := NewMessageService(repo, NewMockCache())
+	ctx := context.Background()
+
+	chatID, alice := uuid.New(), uuid.New()
+	repo.AddUserToChat(ctx, chatID, alice)
+	profiles := staticProfiles{alice: {ID: alice, Username: "alice@example.com", DisplayName: "Alice"}}
+	svc.SetProfileLookup(profiles)
+	if _, err := svc.SendMessage(ctx, chatID.String(), alice.String(), "hello", ""); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	page, err := svc.GetChatHistory(ctx, chatID.String(), HistoryQuery{UserID: alice})
+	if err != nil {
+		t.Fatalf("GetChatHistory failed: %v", err)
+	}
+	if page.Messages[0].Sender != nil {
+		t.Error("Expected no sender profile unless asked for")
+	}
+
+	// Served from the cache now; a profile change must still show
+	profiles[alice] = &model.UserProfile{ID: alice, Username: "alice@example.com", DisplayName: "Alice B."}
+	page, err = svc.GetChatHistory(ctx, chatID.String(), HistoryQuery{UserID: alice, EmbedProfiles: true})
+	if err != nil {
+		t.Fatalf("GetChatHistory failed: %v", err)
+	}
+	if sender := page.Messages[0].Sender; sender == nil || sender.DisplayName != "Alice B." {
+		t.Errorf("Expected the current sender profile, got %+v", sender)
+	}
+}
+
+// staticBlocks maps each blocker to the users they blocked
+type staticBlocks map[uuid.UUID]map[uuid.UUID]bool
+
+func (b staticBlocks) BlockedUsers(ctx context.Context, blockerID uuid.UUID) (map[uuid.UUID]bool, error) {
+	return b[blockerID], nil
+}
+
+func (b staticBlocks) IsBlockedEitherWay(ctx context.Context, a, c uuid.UUID) (bool, error) {
+	return b[a][c] || b[c][a], nil
+}
+
+func TestGetChatHistory_HidesBlockedSenders(t *testing.T) {
+	repo := NewMockRepository()
+	svc := NewMessageService(repo, NewMockCache())
+	ctx := context.Background()
+
+	chatID, alice, bob := uuid.New(), uuid.New(), uuid.New()
+	repo.AddUserToChat(ctx, chatID, alice)
+	repo.AddUserToChat(ctx, chatID, bob)
+	svc.SetBlockList(staticBlocks{alice: {bob: true}})
+	svc.SendMessage(ctx, chatID.String(), alice.String(), "from alice", "")
+	svc.SendMessage(ctx, chatID.String(), bob.String(), "from bob", "")
+
+	// Bob's view fills the cache first; alice must not be served it
+	page, err := svc.GetChatHistory(ctx, chatID.String(), HistoryQuery{UserID: bob})
+	if err != nil {
+		t.Fatalf("GetChatHistory failed: %v", err)
+	}
+	if len(page.Messages) != 2 {
+		t.Errorf("Expected bob to see both messages, got %d", len(page.Messages))
+	}
+
+	page, err = svc.GetChatHistory(ctx, chatID.String(), HistoryQuery{UserID: alice})
+	if err != nil {
+		t.Fatalf("GetChatHistory failed: %v", err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].SenderID != alice {
+		t.Errorf("Expected alice to see only her own message, got %+v", page.Messages)
+	}
+}
+
+func TestMessagePermissions(t *testing.T) {
+	repo := NewMockRepository()
+	svc := NewMessageService(repo, NewMockCache())
+	ctx := context.Background()
+
+	chatID := uuid.New()
+	admin, member, reader := uuid.New(), uuid.New(), uuid.New()
+	repo.members[chatID] = map[uuid.UUID]model.ChatRole{
+		admin:  model.RoleAdmin,
+		member: model.RoleMember,
+		reader: model.RoleReadOnly,
+	}
+
+	message, err := svc.SendMessage(ctx, chatID.String(), member.String(), "hello", "")
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	t.Run("Read-only members cannot send or react", func(t *testing.T) {
+		if _, err := svc.SendMessage(ctx, chatID.String(), reader.String(), "hi", ""); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Expected ErrPermissionDenied, got %v", err)
+		}
+		if err := svc.AddReaction(ctx, message.ID.String(), reader.String(), "👍"); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Expected ErrPermissionDenied, got %v", err)
+		}
+		if _, err := svc.GetChatHistory(ctx, chatID.String(), HistoryQuery{UserID: reader}); err != nil {
+			t.Errorf("Expected read-only member to read history, got %v", err)
+		}
+	})
+
+	t.Run("Non-members cannot read", func(t *testing.T) {
+		if _, err := svc.GetChatHistory(ctx, chatID.String(), HistoryQuery{UserID: uuid.New()}); !errors.Is(err, ErrNotChatMember) {
+			t.Errorf("Expected ErrNotChatMember, got %v", err)
+		}
+	})
+
+	t.Run("Only admins delete others' messages", func(t *testing.T) {
+		if err := svc.DeleteMessage(ctx, message.ID.String(), reader.String()); !errors.Is(err, ErrNotMessageOwner) {
+			t.Errorf("Expected ErrNotMessageOwner, got %v", err)
+		}
+		if err := svc.DeleteMessage(ctx, message.ID.String(), admin.String()); err != nil {
+			t.Errorf("Expected admin to delete the message, got %v", err)
+		}
+	})
+}
+
+func TestSendMessage_UnverifiedSenderRefused(t *testing.T) {
+	repo := NewMockRepository()
+	svc := NewMessageService(repo, NewMockCache())
+	ctx := context.Background()
+
+	chatID, alice, bob := uuid.New(), uuid.New(), uuid.New()
+	repo.AddUserToChat(ctx, chatID, alice)
+	repo.AddUserToChat(ctx, chatID, bob)
+	svc.SetVerificationGate(unverifiedUsers{bob: true})
+
+	if _, err := svc.SendMessage(ctx, chatID.String(), alice.String(), "hello", ""); err != nil {
+		t.Fatalf("SendMessage failed for a verified sender: %v", err)
+	}
+	if _, err := svc.SendMessage(ctx, chatID.String(), bob.String(), "hello", ""); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("Expected ErrEmailNotVerified, got %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"rtcs/internal/metrics"
+	"rtcs/internal/middleware"
+	"rtcs/internal/model"
+	"rtcs/internal/totp"
+
+	"github.com/google/uuid"
+)
+
+// totpIssuer names the service in authenticator apps
+const totpIssuer = "RTCS"
+
+// recoveryCodeCount is how many recovery codes a user gets when enabling 2FA
+const recoveryCodeCount = 10
+
+// Two-factor authentication errors
+var (
+	ErrMFARequired       = errors.New("two-factor authentication required")
+	ErrInvalidMFAToken   = errors.New("two-factor login token is invalid or expired")
+	ErrInvalidMFACode    = errors.New("invalid two-factor code")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+)
+
+// MFARequiredError is returned by Login when the password was right but the
+// user has 2FA on. Token must be exchanged with VerifyMFA for a token pair.
+// It matches ErrMFARequired.
+type MFARequiredError struct {
+	Token     string
+	ExpiresIn int64 // Seconds
+}
+
+func (e *MFARequiredError) Error() string {
+	return ErrMFARequired.Error()
+}
+
+func (e *MFARequiredError) Is(target error) bool {
+	return target == ErrMFARequired
+}
+
+// TOTPEnrollment is what a user needs to add the account to an authenticator
+// app
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// EnrollTOTP starts 2FA enrollment with a new secret. Enrollment only takes
+// effect once ConfirmTOTP is called with a code from the app; until then it
+// can be restarted.
+func (s *AuthService) EnrollTOTP(ctx context.Context, userID uuid.UUID) (*TOTPEnrollment, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	saved, err := s.mfaRepo.SaveTOTP(ctx, &model.UserTOTP{
+		UserID:    userID,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !saved {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmTOTP turns 2FA on after checking a first code from the app, and
+// returns the recovery codes. They are shown this once; only hashes are kept.
+func (s *AuthService) ConfirmTOTP(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	enrollment, err := s.mfaRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if enrollment == nil {
+		return nil, ErrMFANotEnabled
+	}
+	if enrollment.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	step, ok := totp.Verify(enrollment.Secret, strings.TrimSpace(code), time.Now(), 1)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]*model.RecoveryCode, recoveryCodeCount)
+	now := time.Now()
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			return nil, err
+		}
+		records[i] = &model.RecoveryCode{
+			ID:        uuid.New(),
+			UserID:    userID,
+			CodeHash:  hashToken(normalizeRecoveryCode(codes[i])),
+			CreatedAt: now,
+		}
+	}
+
+	confirmed, err := s.mfaRepo.ConfirmTOTP(ctx, userID, step, records)
+	if err != nil {
+		return nil, err
+	}
+	if !confirmed {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns 2FA off. It takes a current code from the app or a
+// recovery code, so a stolen access token alone cannot remove the second
+// factor.
+func (s *AuthService) DisableTOTP(ctx context.Context, userID uuid.UUID, code, ip string) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrInvalidCredentials
+	}
+	enrollment, err := s.mfaRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if enrollment == nil || enrollment.ConfirmedAt == nil {
+		return ErrMFANotEnabled
+	}
+
+	if err := s.verifySecondFactor(ctx, user, code, ip); err != nil {
+		return err
+	}
+	return s.mfaRepo.DeleteTOTP(ctx, userID)
+}
+
+// VerifyMFA completes a login that Login answered with an MFARequiredError.
+// code is a current code from the app or an unused recovery code.
+func (s *AuthService) VerifyMFA(ctx context.Context, mfaToken, code, ip string) (*TokenPair, error) {
+	claims, err := middleware.ValidateMFAToken(mfaToken)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+	if s.denylist != nil {
+		if err := middleware.IsTokenRevoked(ctx, s.denylist, claims); errors.Is(err, middleware.ErrTokenRevoked) {
+			return nil, ErrInvalidMFAToken
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	if err := s.verifySecondFactor(ctx, user, code, ip); err != nil {
+		return nil, err
+	}
+
+	// The challenge is used up
+	if s.denylist != nil {
+		if err := s.denylist.Revoke(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+			return nil, err
+		}
+	}
+	return s.startSession(ctx, user.ID)
+}
+
+// mfaEnabled reports whether the user has confirmed 2FA
+func (s *AuthService) mfaEnabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	if s.mfaRepo == nil {
+		return false, nil
+	}
+	enrollment, err := s.mfaRepo.GetTOTP(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return enrollment != nil && enrollment.ConfirmedAt != nil, nil
+}
+
+// verifySecondFactor checks a TOTP or recovery code, using each code up. Wrong
+// codes count as failed logins, so codes cannot be guessed faster than
+// passwords.
+func (s *AuthService) verifySecondFactor(ctx context.Context, user *model.User, code, ip string) error {
+	if s.limiter != nil {
+		retryAfter, scope, err := s.limiter.Check(ctx, user.Username, ip)
+		if err != nil {
+			return err
+		}
+		if retryAfter > 0 {
+			metrics.LoginBlockedTotal.WithLabelValues(scope).Inc()
+			return &LoginThrottledError{RetryAfter: retryAfter}
+		}
+	}
+
+	ok, err := s.useSecondFactor(ctx, user.ID, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		metrics.LoginFailuresTotal.Inc()
+		if s.limiter != nil {
+			if err := s.limiter.RecordFailure(ctx, user.Username, ip); err != nil {
+				return err
+			}
+		}
+		return ErrInvalidMFACode
+	}
+
+	if s.limiter != nil {
+		return s.limiter.RecordSuccess(ctx, user.Username, ip)
+	}
+	return nil
+}
+
+func (s *AuthService) useSecondFactor(ctx context.Context, userID uuid.UUID, code string) (bool, error) {
+	enrollment, err := s.mfaRepo.GetTOTP(ctx, userID)
+	if err != nil || enrollment == nil || enrollment.ConfirmedAt == nil {
+		return false, err
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		step, ok := totp.Verify(enrollment.Secret, code, time.Now(), 1)
+		if !ok {
+			return false, nil
+		}
+		// A code seen once is refused, even within its 30 seconds
+		return s.mfaRepo.UseTOTPStep(ctx, userID, step)
+	}
+	return s.mfaRepo.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(code)))
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCode returns 80 random bits as four groups of four characters
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(recoveryEncoding.EncodeToString(buf))
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+// normalizeRecoveryCode accepts codes typed with any case, dashes or spaces
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
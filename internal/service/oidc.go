@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"rtcs/internal/middleware"
+	"rtcs/internal/model"
+	"rtcs/internal/oidc"
+	"rtcs/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// OIDCStateTTL is how long a user has to log in at the provider
+const OIDCStateTTL = 10 * time.Minute
+
+// OpenID Connect login errors
+var (
+	ErrUnknownProvider          = errors.New("unknown login provider")
+	ErrInvalidOIDCState         = errors.New("login request is invalid, expired or already used")
+	ErrOIDCLoginFailed          = errors.New("login with the provider failed")
+	ErrProviderEmailNotVerified = errors.New("the provider has not verified the email address")
+	ErrAccountNotLinkable       = errors.New("an account with this email exists but its address is not verified; verify it or log in with its password first")
+)
+
+// OIDCStateStore keeps logins in flight between the redirect to the provider
+// and its callback
+type OIDCStateStore interface {
+	Save(ctx context.Context, req *oidc.AuthRequest, ttl time.Duration) error
+	// Take removes and returns the login with the given state, or nil if
+	// there is none
+	Take(ctx context.Context, state string) (*oidc.AuthRequest, error)
+}
+
+// SetOIDC turns on login through OpenID Connect providers, which are added
+// with AddOIDCProvider
+func (s *AuthService) SetOIDC(identities repository.IdentityRepository, states OIDCStateStore) {
+	s.identities = identities
+	s.oidcStates = states
+}
+
+// AddOIDCProvider accepts logins from provider under its name
+func (s *AuthService) AddOIDCProvider(provider *oidc.Provider) {
+	if s.providers == nil {
+		s.providers = make(map[string]*oidc.Provider)
+	}
+	s.providers[provider.Name()] = provider
+}
+
+// StartOIDCLogin begins a login with the named provider. It returns the URL
+// to send the user to and the state the callback has to carry.
+func (s *AuthService) StartOIDCLogin(ctx context.Context, providerName string) (string, string, error) {
+	provider := s.providers[providerName]
+	if provider == nil || s.oidcStates == nil {
+		return "", "", ErrUnknownProvider
+	}
+
+	req, err := oidc.NewAuthRequest(providerName)
+	if err != nil {
+		return "", "", err
+	}
+	authURL, err := provider.AuthCodeURL(ctx, req)
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.oidcStates.Save(ctx, req, OIDCStateTTL); err != nil {
+		return "", "", err
+	}
+	return authURL, req.State, nil
+}
+
+// FinishOIDCLogin completes a login at the provider's callback and starts a
+// session. A provider account already linked logs in its user. Otherwise it
+// is linked by the address the provider verified: to the local account with
+// that username if the address is verified there too, or to a new account.
+// Like Login, it returns an MFARequiredError if the user has 2FA on.
+func (s *AuthService) FinishOIDCLogin(ctx context.Context, providerName, state, code string) (*TokenPair, error) {
+	provider := s.providers[providerName]
+	if provider == nil || s.oidcStates == nil {
+		return nil, ErrUnknownProvider
+	}
+	req, err := s.oidcStates.Take(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+	if req == nil || req.Provider != providerName {
+		return nil, ErrInvalidOIDCState
+	}
+
+	idToken, err := provider.Exchange(ctx, req, code)
+	if errors.Is(err, oidc.ErrTokenExchange) || errors.Is(err, oidc.ErrInvalidIDToken) {
+		log.Printf("OIDC login with %s failed: %v", providerName, err)
+		return nil, ErrOIDCLoginFailed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.oidcUser(ctx, providerName, idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.mfaEnabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa {
+		token, err := middleware.GenerateMFAToken(user.ID.String())
+		if err != nil {
+			return nil, err
+		}
+		return nil, &MFARequiredError{Token: token, ExpiresIn: int64(middleware.MFATokenTTL / time.Second)}
+	}
+	return s.startSession(ctx, user.ID)
+}
+
+// oidcUser returns the user the provider account belongs to, linking or
+// creating one on its first login
+func (s *AuthService) oidcUser(ctx context.Context, providerName string, idToken *oidc.IDToken) (*model.User, error) {
+	identity, err := s.identities.GetIdentity(ctx, providerName, idToken.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		user, err := s.userRepo.GetByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil || user.DeletedAt != nil {
+			return nil, ErrOIDCLoginFailed
+		}
+		return user, nil
+	}
+
+	// Accounts are only ever matched by an address both sides verified
+	if !idToken.EmailVerified || idToken.Email == "" {
+		return nil, ErrProviderEmailNotVerified
+	}
+	if err := ValidateEmail(idToken.Email); err != nil {
+		return nil, err
+	}
+	identity = &model.UserIdentity{
+		ID:        uuid.New(),
+		Provider:  providerName,
+		Subject:   idToken.Subject,
+		Email:     idToken.Email,
+		CreatedAt: time.Now(),
+	}
+
+	user, err := s.userRepo.GetByUsername(ctx, idToken.Email)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		// Anyone can register an address they do not own. Linking such an
+		// account would let whoever registered it keep a way in with the
+		// password, next to the address's real owner.
+		if !user.EmailVerified {
+			return nil, ErrAccountNotLinkable
+		}
+		identity.UserID = user.ID
+		linked, err := s.identities.LinkIdentity(ctx, identity)
+		if err != nil {
+			return nil, err
+		}
+		if !linked {
+			// Linked by a concurrent login; that one decided where it goes
+			return s.oidcUser(ctx, providerName, idToken)
+		}
+		return user, nil
+	}
+
+	// A new account has no password until the user resets it
+	user = &model.User{
+		ID:            uuid.New(),
+		Username:      idToken.Email,
+		EmailVerified: true,
+		DisplayName:   truncateRunes(strings.TrimSpace(idToken.Name), maxDisplayNameLength),
+	}
+	created, err := s.identities.CreateUserWithIdentity(ctx, user, identity)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		// The address or the provider account was taken meanwhile
+		return nil, ErrOIDCLoginFailed
+	}
+	return user, nil
+}
+
+// truncateRunes cuts value to at most max characters
+func truncateRunes(value string, max int) string {
+	if utf8.RuneCountInString(value) <= max {
+		return value
+	}
+	return string([]rune(value)[:max])
+}
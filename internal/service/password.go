@@ -0,0 +1,52 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"unicode"
+)
+
+// ErrWeakPassword is returned for passwords the password policy rejects
+var ErrWeakPassword = errors.New("password does not meet the password policy")
+
+// maxPasswordBytes is the most bcrypt hashes; anything longer is refused
+// rather than silently truncated
+const maxPasswordBytes = 72
+
+// PasswordPolicy is checked whenever a password is set
+type PasswordPolicy struct {
+	MinLength     int  // In characters
+	RequireDigit  bool // At least one 0-9
+	RequireSymbol bool // At least one character that is not a letter, digit or space
+}
+
+// DefaultPasswordPolicy only asks for a minimum length
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 8}
+
+// Validate returns an error wrapping ErrWeakPassword that says what the
+// password lacks
+func (p PasswordPolicy) Validate(password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("%w: must be at least %d characters", ErrWeakPassword, p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: must be at most %d bytes", ErrWeakPassword, maxPasswordBytes)
+	}
+
+	var digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r) && !unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireDigit && !digit {
+		return fmt.Errorf("%w: must contain a digit", ErrWeakPassword)
+	}
+	if p.RequireSymbol && !symbol {
+		return fmt.Errorf("%w: must contain a symbol", ErrWeakPassword)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"rtcs/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Permission is an action in a chat that depends on the member's role
+type Permission int
+
+const (
+	PermReadMessages Permission = iota
+	PermSendMessage
+	PermReact
+	PermRenameChat
+	PermDeleteAnyMessage
+	PermKickMember
+	PermBanMember
+	PermInviteMember
+	PermManageRoles
+	PermTransferOwnership
+)
+
+var rolePermissions = map[model.ChatRole][]Permission{
+	model.RoleOwner: {
+		PermReadMessages, PermSendMessage, PermReact, PermRenameChat, PermDeleteAnyMessage,
+		PermKickMember, PermBanMember, PermInviteMember, PermManageRoles, PermTransferOwnership,
+	},
+	model.RoleAdmin: {
+		PermReadMessages, PermSendMessage, PermReact, PermRenameChat, PermDeleteAnyMessage,
+		PermKickMember, PermBanMember, PermInviteMember, PermManageRoles,
+	},
+	model.RoleMember:   {PermReadMessages, PermSendMessage, PermReact},
+	model.RoleReadOnly: {PermReadMessages},
+}
+
+// roleRank orders roles for deciding who may manage whom
+var roleRank = map[model.ChatRole]int{
+	model.RoleReadOnly: 0,
+	model.RoleMember:   1,
+	model.RoleAdmin:    2,
+	model.RoleOwner:    3,
+}
+
+// Permission errors
+var (
+	ErrNotChatMember    = errors.New("user is not a member of this chat")
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Can reports whether role grants perm
+func Can(role model.ChatRole, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// canManage reports whether actor may kick target or change their role.
+// Owners manage everyone; others only members ranked below them.
+func canManage(actor, target *model.ChatUser) bool {
+	return actor.Role == model.RoleOwner || roleRank[actor.Role] > roleRank[target.Role]
+}
+
+// MemberLookup finds a user's membership in a chat, or nil if they are not a
+// member
+type MemberLookup interface {
+	GetMember(ctx context.Context, chatID, userID uuid.UUID) (*model.ChatUser, error)
+}
+
+// Authorizer checks chat operations against the caller's role
+type Authorizer struct {
+	members MemberLookup
+}
+
+// NewAuthorizer creates a new authorizer
+func NewAuthorizer(members MemberLookup) *Authorizer {
+	return &Authorizer{members: members}
+}
+
+// Authorize returns the user's membership of the chat if their role grants
+// perm, ErrNotChatMember if they are not a member and ErrPermissionDenied
+// otherwise
+func (a *Authorizer) Authorize(ctx context.Context, chatID, userID uuid.UUID, perm Permission) (*model.ChatUser, error) {
+	member, err := a.members.GetMember(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if member == nil {
+		return nil, ErrNotChatMember
+	}
+	if !Can(member.Role, perm) {
+		return nil, ErrPermissionDenied
+	}
+	return member, nil
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"rtcs/internal/model"
+)
+
+// MessageSearcher is a full-text search backend for messages. Implementations
+// must only return messages from chats query.UserID belongs to and must skip
+// deleted messages.
+type MessageSearcher interface {
+	SearchMessages(ctx context.Context, query *model.SearchQuery) ([]*model.SearchResult, error)
+}
+
+// Search errors
+var (
+	ErrEmptySearch        = errors.New("search text cannot be empty")
+	ErrSearchTooLong      = errors.New("search text is too long")
+	ErrInvalidSearchRange = errors.New("search range ends before it starts")
+)
+
+// Search limits
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 50
+	maxSearchLength    = 256
+)
+
+// SearchService searches messages through a pluggable backend
+type SearchService struct {
+	searcher MessageSearcher
+}
+
+// NewSearchService creates a new search service
+func NewSearchService(searcher MessageSearcher) *SearchService {
+	return &SearchService{searcher: searcher}
+}
+
+// SearchMessages validates a query and runs it against the backend
+func (s *SearchService) SearchMessages(ctx context.Context, query *model.SearchQuery) ([]*model.SearchResult, error) {
+	query.Text = strings.TrimSpace(query.Text)
+	if query.Text == "" {
+		return nil, ErrEmptySearch
+	}
+	if len(query.Text) > maxSearchLength {
+		return nil, ErrSearchTooLong
+	}
+	if query.From != nil && query.To != nil && query.To.Before(*query.From) {
+		return nil, ErrInvalidSearchRange
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = DefaultSearchLimit
+	}
+	if query.Limit > MaxSearchLimit {
+		query.Limit = MaxSearchLimit
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	results, err := s.searcher.SearchMessages(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if results == nil {
+		results = []*model.SearchResult{}
+	}
+	return results, nil
+}
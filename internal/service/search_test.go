@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"rtcs/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// recordingSearcher captures the query handed to the backend
+type recordingSearcher struct {
+	query *model.SearchQuery
+}
+
+func (s *recordingSearcher) SearchMessages(ctx context.Context, query *model.SearchQuery) ([]*model.SearchResult, error) {
+	s.query = query
+	return nil, nil
+}
+
+func TestSearchMessages(t *testing.T) {
+	searcher := &recordingSearcher{}
+	svc := NewSearchService(searcher)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	t.Run("Query is normalised", func(t *testing.T) {
+		results, err := svc.SearchMessages(ctx, &model.SearchQuery{UserID: userID, Text: "  deploy  ", Limit: 1000})
+		if err != nil {
+			t.Fatalf("SearchMessages failed: %v", err)
+		}
+		if results == nil {
+			t.Error("Expected an empty result list, got nil")
+		}
+		if searcher.query.Text != "deploy" || searcher.query.Limit != MaxSearchLimit {
+			t.Errorf("Unexpected query passed to backend: %+v", searcher.query)
+		}
+		if searcher.query.UserID != userID {
+			t.Errorf("Expected search scoped to %s, got %s", userID, searcher.query.UserID)
+		}
+	})
+
+	t.Run("Invalid queries are rejected", func(t *testing.T) {
+		now := time.Now()
+		earlier := now.Add(-time.Hour)
+		tests := []struct {
+			query *model.SearchQuery
+			err   error
+		}{
+			{&model.SearchQuery{UserID: userID, Text: "   "}, ErrEmptySearch},
+			{&model.SearchQuery{UserID: userID, Text: strings.Repeat("a", maxSearchLength+1)}, ErrSearchTooLong},
+			{&model.SearchQuery{UserID: userID, Text: "deploy", From: &now, To: &earlier}, ErrInvalidSearchRange},
+		}
+		for _, tt := range tests {
+			if _, err := svc.SearchMessages(ctx, tt.query); !errors.Is(err, tt.err) {
+				t.Errorf("Expected %v, got %v", tt.err, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,283 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	// Timezone names are checked against the embedded database, so that
+	// validation does not depend on the host having zoneinfo installed
+	_ "time/tzdata"
+	"unicode/utf8"
+
+	"rtcs/internal/model"
+	"rtcs/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// User errors
+var (
+	// ErrInvalidProfile is returned for profile updates with a field that is
+	// too long or malformed
+	ErrInvalidProfile = errors.New("invalid profile")
+	ErrBlockSelf      = errors.New("cannot block yourself")
+	ErrNotBlocked     = errors.New("user is not blocked")
+	ErrBlocked        = errors.New("one of the users has blocked the other")
+)
+
+// Directory search page sizes
+const (
+	DefaultUserSearchLimit = 20
+	MaxUserSearchLimit     = 50
+)
+
+// Profile field limits, in characters
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 1000
+	maxAvatarLength      = 512
+	maxStatusTextLength  = 140
+)
+
+// ProfileLookup fetches the profiles embedded in message and member lists
+type ProfileLookup interface {
+	GetProfiles(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*model.UserProfile, error)
+}
+
+// BlockList tells who blocked whom. Messages from blocked users are hidden
+// from the blocker, and the two cannot start direct messages.
+type BlockList interface {
+	BlockedUsers(ctx context.Context, blockerID uuid.UUID) (map[uuid.UUID]bool, error)
+	IsBlockedEitherWay(ctx context.Context, a, b uuid.UUID) (bool, error)
+}
+
+// BlockListener is notified when a user blocks or unblocks another, so that
+// live connections can start or stop filtering
+type BlockListener interface {
+	UserBlocked(blockerID, blockedID uuid.UUID)
+	UserUnblocked(blockerID, blockedID uuid.UUID)
+}
+
+// ProfileUpdate changes the profile fields that are set
+type ProfileUpdate struct {
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	Avatar      *string `json:"avatar"`
+	Timezone    *string `json:"timezone"`
+	StatusText  *string `json:"status_text"`
+}
+
+// UserService handles user profiles and the user directory
+type UserService struct {
+	repo          repository.UserRepository
+	blockListener BlockListener
+	unverified    UnverifiedPolicy
+}
+
+// NewUserService creates a new user service
+func NewUserService(repo repository.UserRepository) *UserService {
+	return &UserService{repo: repo}
+}
+
+// SetBlockListener registers the listener notified about blocks
+func (s *UserService) SetBlockListener(listener BlockListener) {
+	s.blockListener = listener
+}
+
+// SetUnverifiedPolicy sets the actions RequireVerified refuses to users who
+// have not verified their email
+func (s *UserService) SetUnverifiedPolicy(policy UnverifiedPolicy) {
+	s.unverified = policy
+}
+
+// RequireVerified returns ErrEmailNotVerified if action is restricted and
+// the user has not verified their email
+func (s *UserService) RequireVerified(ctx context.Context, userID uuid.UUID, action UnverifiedAction) error {
+	if !s.unverified.Restricts(action) {
+		return nil
+	}
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if !user.EmailVerified {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// GetUser returns a user's profile
+func (s *UserService) GetUser(ctx context.Context, id uuid.UUID) (*model.User, error) {
+	user, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.DeletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// UpdateProfile applies an update to the user's own profile
+func (s *UserService) UpdateProfile(ctx context.Context, userID uuid.UUID, update ProfileUpdate) (*model.User, error) {
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []struct {
+		name  string
+		value *string
+		dest  *string
+		max   int
+	}{
+		{"display_name", update.DisplayName, &user.DisplayName, maxDisplayNameLength},
+		{"bio", update.Bio, &user.Bio, maxBioLength},
+		{"avatar", update.Avatar, &user.Avatar, maxAvatarLength},
+		{"status_text", update.StatusText, &user.StatusText, maxStatusTextLength},
+	}
+	for _, field := range fields {
+		if field.value == nil {
+			continue
+		}
+		value := strings.TrimSpace(*field.value)
+		if utf8.RuneCountInString(value) > field.max {
+			return nil, fmt.Errorf("%w: %s must be at most %d characters", ErrInvalidProfile, field.name, field.max)
+		}
+		*field.dest = value
+	}
+
+	if update.Timezone != nil {
+		timezone := strings.TrimSpace(*update.Timezone)
+		// An empty name would load as UTC; keep it meaning "not set"
+		if timezone != "" {
+			if _, err := time.LoadLocation(timezone); err != nil {
+				return nil, fmt.Errorf("%w: unknown timezone %q", ErrInvalidProfile, timezone)
+			}
+		}
+		user.Timezone = timezone
+	}
+
+	user.UpdatedAt = time.Now()
+	if err := s.repo.UpdateProfile(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SearchUsers returns users whose username or display name starts with
+// query, for finding someone to message
+func (s *UserService) SearchUsers(ctx context.Context, query string, limit int) ([]*model.UserProfile, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return []*model.UserProfile{}, nil
+	}
+	if limit <= 0 {
+		limit = DefaultUserSearchLimit
+	}
+	if limit > MaxUserSearchLimit {
+		limit = MaxUserSearchLimit
+	}
+
+	users, err := s.repo.Search(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	profiles := make([]*model.UserProfile, len(users))
+	for i, user := range users {
+		profiles[i] = user.Profile()
+	}
+	return profiles, nil
+}
+
+// GetProfiles returns the profiles of the given users by ID
+func (s *UserService) GetProfiles(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*model.UserProfile, error) {
+	unique := make([]uuid.UUID, 0, len(ids))
+	seen := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	profiles, err := s.repo.GetProfiles(ctx, unique)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]*model.UserProfile, len(profiles))
+	for _, profile := range profiles {
+		byID[profile.ID] = profile
+	}
+	return byID, nil
+}
+
+// BlockUser blocks another user. Blocking someone already blocked is not an
+// error.
+func (s *UserService) BlockUser(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	if blockerID == blockedID {
+		return ErrBlockSelf
+	}
+	if _, err := s.GetUser(ctx, blockedID); err != nil {
+		return err
+	}
+
+	created, err := s.repo.Block(ctx, &model.UserBlock{BlockerID: blockerID, BlockedID: blockedID, CreatedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	if created && s.blockListener != nil {
+		s.blockListener.UserBlocked(blockerID, blockedID)
+	}
+	return nil
+}
+
+// UnblockUser lifts a block
+func (s *UserService) UnblockUser(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	removed, err := s.repo.Unblock(ctx, blockerID, blockedID)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return ErrNotBlocked
+	}
+	if s.blockListener != nil {
+		s.blockListener.UserUnblocked(blockerID, blockedID)
+	}
+	return nil
+}
+
+// ListBlocks returns the users the caller has blocked, most recent first
+func (s *UserService) ListBlocks(ctx context.Context, blockerID uuid.UUID) ([]*model.UserBlock, error) {
+	blocks, err := s.repo.ListBlocks(ctx, blockerID)
+	if err != nil {
+		return nil, err
+	}
+	if blocks == nil {
+		blocks = []*model.UserBlock{}
+	}
+	return blocks, nil
+}
+
+// BlockedUsers returns the set of users blocked by blockerID
+func (s *UserService) BlockedUsers(ctx context.Context, blockerID uuid.UUID) (map[uuid.UUID]bool, error) {
+	blocks, err := s.repo.ListBlocks(ctx, blockerID)
+	if err != nil {
+		return nil, err
+	}
+	blocked := make(map[uuid.UUID]bool, len(blocks))
+	for _, block := range blocks {
+		blocked[block.BlockedID] = true
+	}
+	return blocked, nil
+}
+
+// IsBlockedEitherWay reports whether either user has blocked the other
+func (s *UserService) IsBlockedEitherWay(ctx context.Context, a, b uuid.UUID) (bool, error) {
+	return s.repo.IsBlockedEitherWay(ctx, a, b)
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"rtcs/internal/middleware"
+	"rtcs/internal/model"
+	"rtcs/internal/notify"
+
+	"github.com/google/uuid"
+)
+
+// Email verification errors
+var (
+	ErrInvalidEmail             = errors.New("invalid email address")
+	ErrEmailNotVerified         = errors.New("email address is not verified")
+	ErrInvalidVerificationToken = errors.New("email verification link is invalid or expired")
+)
+
+// maxEmailLength is the longest address SMTP can deliver to (RFC 5321)
+const maxEmailLength = 254
+
+// ValidateEmail returns ErrInvalidEmail unless address is a bare RFC 5322
+// address such as alice@example.com. Display names, angle brackets and
+// comments are refused since the address doubles as the username.
+func ValidateEmail(address string) error {
+	if len(address) > maxEmailLength {
+		return ErrInvalidEmail
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Name != "" || parsed.Address != address {
+		return ErrInvalidEmail
+	}
+	// Addresses without a dot in the domain are valid but only reach hosts
+	// on the local network
+	domain := address[strings.LastIndex(address, "@")+1:]
+	if !strings.Contains(domain, ".") || strings.HasSuffix(domain, ".") {
+		return ErrInvalidEmail
+	}
+	return nil
+}
+
+// UnverifiedAction is something users can be kept from doing until they
+// have verified their email
+type UnverifiedAction string
+
+const (
+	RestrictLogin        UnverifiedAction = "login"         // Logging in at all
+	RestrictSendMessages UnverifiedAction = "send_messages" // Sending messages
+	RestrictCreateChats  UnverifiedAction = "create_chats"  // Creating chats and opening direct messages
+	RestrictJoinChats    UnverifiedAction = "join_chats"    // Joining chats, also through invites
+)
+
+// UnverifiedPolicy lists the actions unverified users are refused
+type UnverifiedPolicy []UnverifiedAction
+
+// ParseUnverifiedPolicy reads a comma-separated list of actions such as
+// "send_messages,create_chats"
+func ParseUnverifiedPolicy(value string) (UnverifiedPolicy, error) {
+	var policy UnverifiedPolicy
+	for _, name := range strings.Split(value, ",") {
+		action := UnverifiedAction(strings.TrimSpace(name))
+		switch action {
+		case "":
+			continue
+		case RestrictLogin, RestrictSendMessages, RestrictCreateChats, RestrictJoinChats:
+			policy = append(policy, action)
+		default:
+			return nil, fmt.Errorf("unknown restriction %q", action)
+		}
+	}
+	return policy, nil
+}
+
+// Restricts reports whether unverified users are refused action
+func (p UnverifiedPolicy) Restricts(action UnverifiedAction) bool {
+	for _, restricted := range p {
+		if restricted == action {
+			return true
+		}
+	}
+	return false
+}
+
+// VerificationGate refuses actions that unverified users are restricted from
+type VerificationGate interface {
+	// RequireVerified returns ErrEmailNotVerified if the user has not
+	// verified their email and action is restricted
+	RequireVerified(ctx context.Context, userID uuid.UUID, action UnverifiedAction) error
+}
+
+// RequestEmailVerification sends a new verification link to the user with
+// the given email. Like password resets, unknown and already verified
+// addresses get nothing, without telling the caller.
+func (s *AuthService) RequestEmailVerification(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetByUsername(ctx, email)
+	if err != nil || user == nil || user.EmailVerified {
+		return err
+	}
+	return s.sendVerification(ctx, user)
+}
+
+// sendVerification mails the user a signed link proving they own their email
+func (s *AuthService) sendVerification(ctx context.Context, user *model.User) error {
+	token, err := middleware.GenerateEmailVerificationToken(user.ID.String(), user.Username)
+	if err != nil {
+		return err
+	}
+
+	link := s.publicURL + "/auth/verify-email?token=" + url.QueryEscape(token)
+	return s.notifier.Notify(ctx, notify.Message{
+		To:      user.Username,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Open %s within %v to confirm this address for your account.\n"+
+			"If you did not sign up, you can ignore this message.", link, middleware.EmailVerificationTTL),
+	})
+}
+
+// VerifyEmail marks the user a verification token was sent to as verified.
+// Using a link again is harmless.
+func (s *AuthService) VerifyEmail(ctx context.Context, token string) error {
+	claims, err := middleware.ValidateEmailVerificationToken(token)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	// The link only vouches for the address it was sent to
+	if user == nil || user.DeletedAt != nil || user.Username != claims.Email {
+		return ErrInvalidVerificationToken
+	}
+	if user.EmailVerified {
+		return nil
+	}
+	_, err = s.userRepo.MarkEmailVerified(ctx, userID)
+	return err
+}
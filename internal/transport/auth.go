@@ -2,10 +2,17 @@ package transport
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
+	"net"
 	"net/http"
-	"strings"
+	"strconv"
+	"time"
 
+	"rtcs/internal/middleware"
 	"rtcs/internal/service"
+
+	"github.com/google/uuid"
 )
 
 // AuthHandler handles authentication requests
@@ -30,8 +37,30 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
-type LoginResponse struct {
-	Token string `json:"token"`
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type EmailVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
 }
 
 // Register handles user registration
@@ -42,12 +71,6 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate email format
-	if !isValidEmail(req.Email) {
-		http.Error(w, "Invalid email format", http.StatusBadRequest)
-		return
-	}
-
 	_, err := h.authService.Register(r.Context(), req.Email, req.Password)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -67,24 +90,227 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.authService.Login(r.Context(), req.Email, req.Password)
-	if err != nil {
+	tokens, err := h.authService.Login(r.Context(), req.Email, req.Password, clientIP(r))
+	var throttled *service.LoginThrottledError
+	var mfa *service.MFARequiredError
+	switch {
+	case errors.As(err, &throttled):
+		writeThrottled(w, throttled)
+		return
+	case errors.As(err, &mfa):
+		// The password was right; the client continues at /auth/login/mfa
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(MFAChallengeResponse{MFARequired: true, MFAToken: mfa.Token, ExpiresIn: mfa.ExpiresIn})
+		return
+	case errors.Is(err, service.ErrInvalidCredentials):
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid credentials"})
 		return
+	case errors.Is(err, service.ErrEmailNotVerified):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Email address is not verified"})
+		return
+	case err != nil:
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
 	}
 
-	response := LoginResponse{Token: token}
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		return
 	}
 }
 
-// Helper function for email validation
-func isValidEmail(email string) bool {
-	// Simple regex for demonstration; use a more robust one in production
-	return strings.Contains(email, "@") && strings.Contains(email, ".")
+// Refresh exchanges a refresh token for a new access and refresh token
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.authService.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Logout revokes the caller's access token and, if one is given, the session
+// its refresh token belongs to
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	// The body is optional; without one only the access token is revoked
+	var req LogoutRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Get user ID and claims from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	claims, _ := r.Context().Value("claims").(*middleware.Claims)
+
+	if err := h.authService.Logout(r.Context(), userID, claims, req.RefreshToken); err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangePassword sets a new password for the caller and signs out their
+// other sessions
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Get user ID and claims from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var sessionID string
+	if claims, ok := r.Context().Value("claims").(*middleware.Claims); ok {
+		sessionID = claims.SessionID
+	}
+
+	if err := h.authService.ChangePassword(r.Context(), userID, sessionID, req.CurrentPassword, req.NewPassword); err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RequestPasswordReset sends a reset link to the given email. It answers the
+// same whether or not an account exists.
+func (h *AuthHandler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authService.RequestPasswordReset(r.Context(), req.Email); err != nil {
+		log.Printf("Password reset request failed: %v", err)
+		http.Error(w, "Password reset failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ConfirmPasswordReset sets a new password with a reset token
+func (h *AuthHandler) ConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req PasswordResetConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authService.ResetPassword(r.Context(), req.Token, req.NewPassword); err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyEmail confirms the caller's email with the token from the link sent
+// to them
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Missing token", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authService.VerifyEmail(r.Context(), token); err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email address verified"})
+}
+
+// RequestEmailVerification sends a new verification link to the given email.
+// It answers the same whether or not an unverified account exists.
+func (h *AuthHandler) RequestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	var req EmailVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authService.RequestEmailVerification(r.Context(), req.Email); err != nil {
+		log.Printf("Email verification request failed: %v", err)
+		http.Error(w, "Email verification failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// writeThrottled answers a login attempt made while logins are locked
+func writeThrottled(w http.ResponseWriter, throttled *service.LoginThrottledError) {
+	// Round up so clients never retry while still locked out
+	retryAfter := int64((throttled.RetryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Too many failed login attempts"})
+}
+
+// writeAuthError maps auth service errors to HTTP statuses
+func writeAuthError(w http.ResponseWriter, err error) {
+	var throttled *service.LoginThrottledError
+	if errors.As(err, &throttled) {
+		writeThrottled(w, throttled)
+		return
+	}
+
+	switch {
+	case errors.Is(err, service.ErrWeakPassword), errors.Is(err, service.ErrInvalidResetToken),
+		errors.Is(err, service.ErrInvalidVerificationToken):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrWrongPassword), errors.Is(err, service.ErrInvalidMFACode),
+		errors.Is(err, service.ErrEmailNotVerified):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrMFAAlreadyEnabled), errors.Is(err, service.ErrMFANotEnabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrInvalidCredentials), errors.Is(err, service.ErrInvalidRefreshToken),
+		errors.Is(err, service.ErrRefreshTokenReused), errors.Is(err, service.ErrInvalidMFAToken):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	default:
+		// Database and Redis errors are not for clients to see
+		log.Printf("Auth request failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// clientIP returns the address the request came from. Forwarding headers are
+// ignored since clients can set them to anything.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
@@ -0,0 +1,40 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"rtcs/internal/service"
+)
+
+func TestWriteAuthError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   string
+	}{
+		{"known error", service.ErrWrongPassword, http.StatusForbidden, service.ErrWrongPassword.Error()},
+		{"wrapped known error", fmt.Errorf("changing password: %w", service.ErrWeakPassword), http.StatusBadRequest, service.ErrWeakPassword.Error()},
+		{"internal error", errors.New("dial tcp 10.0.0.7:6379: connection refused"), http.StatusInternalServerError, "Internal server error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeAuthError(rec, tt.err)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if body := strings.TrimSpace(rec.Body.String()); !strings.Contains(body, tt.body) {
+				t.Errorf("body = %q, want it to contain %q", body, tt.body)
+			}
+			if tt.status == http.StatusInternalServerError && strings.Contains(rec.Body.String(), "10.0.0.7") {
+				t.Errorf("internal error details leaked: %q", rec.Body.String())
+			}
+		})
+	}
+}
@@ -2,8 +2,11 @@ package transport
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
+	"rtcs/internal/model"
 	"rtcs/internal/service"
 
 	"github.com/google/uuid"
@@ -19,9 +22,32 @@ func NewChatHandler(service *service.ChatService) *ChatHandler {
 }
 
 type createChatRequest struct {
+	Name       string               `json:"name"`
+	Visibility model.ChatVisibility `json:"visibility,omitempty"`
+}
+
+type renameChatRequest struct {
 	Name string `json:"name"`
 }
 
+type setRoleRequest struct {
+	Role model.ChatRole `json:"role"`
+}
+
+type transferOwnershipRequest struct {
+	UserID uuid.UUID `json:"user_id"`
+}
+
+type banMemberRequest struct {
+	Duration string `json:"duration,omitempty"` // Go duration such as "24h"; empty for a permanent ban
+	Reason   string `json:"reason,omitempty"`
+}
+
+type createInviteRequest struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxUses   int        `json:"max_uses,omitempty"`
+}
+
 func (h *ChatHandler) CreateChat(w http.ResponseWriter, r *http.Request) {
 	var req createChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -36,9 +62,9 @@ func (h *ChatHandler) CreateChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chat, err := h.service.CreateChat(r.Context(), req.Name, userID)
+	chat, err := h.service.CreateChat(r.Context(), req.Name, userID, req.Visibility)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeChatError(w, err)
 		return
 	}
 
@@ -54,9 +80,16 @@ func (h *ChatHandler) GetChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chat, err := h.service.GetChat(r.Context(), chatID)
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chat, err := h.service.GetChat(r.Context(), chatID, userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeChatError(w, err)
 		return
 	}
 	if chat == nil {
@@ -102,7 +135,7 @@ func (h *ChatHandler) JoinChat(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.service.JoinChat(r.Context(), chatID, userID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeChatError(w, err)
 		return
 	}
 
@@ -125,9 +158,378 @@ func (h *ChatHandler) LeaveChat(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.service.LeaveChat(r.Context(), chatID, userID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeChatError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
+
+func (h *ChatHandler) RenameChat(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	chatID, err := uuid.Parse(vars["chatId"])
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	var req renameChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chat, err := h.service.RenameChat(r.Context(), chatID, userID, req.Name)
+	if err != nil {
+		writeChatError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chat)
+}
+
+func (h *ChatHandler) KickMember(w http.ResponseWriter, r *http.Request) {
+	chatID, targetID, ok := chatMemberVars(w, r)
+	if !ok {
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.KickMember(r.Context(), chatID, userID, targetID); err != nil {
+		writeChatError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ChatHandler) BanMember(w http.ResponseWriter, r *http.Request) {
+	chatID, targetID, ok := chatMemberVars(w, r)
+	if !ok {
+		return
+	}
+
+	// The body is optional; without one the ban is permanent
+	var req banMemberRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	var duration time.Duration
+	if req.Duration != "" {
+		var err error
+		if duration, err = time.ParseDuration(req.Duration); err != nil {
+			http.Error(w, "Invalid duration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ban, err := h.service.BanMember(r.Context(), chatID, userID, targetID, duration, req.Reason)
+	if err != nil {
+		writeChatError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ban)
+}
+
+func (h *ChatHandler) UnbanMember(w http.ResponseWriter, r *http.Request) {
+	chatID, targetID, ok := chatMemberVars(w, r)
+	if !ok {
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.UnbanMember(r.Context(), chatID, userID, targetID); err != nil {
+		writeChatError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ChatHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	chatID, err := uuid.Parse(vars["chatId"])
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	embed := r.URL.Query().Get("embed") == "profiles"
+	members, err := h.service.ListMembers(r.Context(), chatID, userID, embed)
+	if err != nil {
+		writeChatError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+func (h *ChatHandler) SetMemberRole(w http.ResponseWriter, r *http.Request) {
+	chatID, targetID, ok := chatMemberVars(w, r)
+	if !ok {
+		return
+	}
+
+	var req setRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.SetMemberRole(r.Context(), chatID, userID, targetID, req.Role); err != nil {
+		writeChatError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ChatHandler) TransferOwnership(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	chatID, err := uuid.Parse(vars["chatId"])
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	var req transferOwnershipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.TransferOwnership(r.Context(), chatID, userID, req.UserID); err != nil {
+		writeChatError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ChatHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	chatID, err := uuid.Parse(vars["chatId"])
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	// The body is optional; without one the invite never expires
+	var req createInviteRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	invite, err := h.service.CreateInvite(r.Context(), chatID, userID, req.ExpiresAt, req.MaxUses)
+	if err != nil {
+		writeChatError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invite)
+}
+
+func (h *ChatHandler) ListInvites(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	chatID, err := uuid.Parse(vars["chatId"])
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	invites, err := h.service.ListInvites(r.Context(), chatID, userID)
+	if err != nil {
+		writeChatError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+func (h *ChatHandler) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	chatID, err := uuid.Parse(vars["chatId"])
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+	inviteID, err := uuid.Parse(vars["inviteId"])
+	if err != nil {
+		http.Error(w, "Invalid invite ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.RevokeInvite(r.Context(), chatID, userID, inviteID); err != nil {
+		writeChatError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ChatHandler) AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chat, err := h.service.AcceptInvite(r.Context(), token, userID)
+	if err != nil {
+		writeChatError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chat)
+}
+
+// OpenDirectChat returns the caller's direct message chat with another user,
+// creating it on first use
+func (h *ChatHandler) OpenDirectChat(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	peerID, err := uuid.Parse(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chat, err := h.service.OpenDirectChat(r.Context(), userID, peerID)
+	if err != nil {
+		writeChatError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chat)
+}
+
+// chatMemberVars parses the chat and user IDs of a member route
+func chatMemberVars(w http.ResponseWriter, r *http.Request) (chatID, userID uuid.UUID, ok bool) {
+	vars := mux.Vars(r)
+	chatID, err := uuid.Parse(vars["chatId"])
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return uuid.Nil, uuid.Nil, false
+	}
+	userID, err = uuid.Parse(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return uuid.Nil, uuid.Nil, false
+	}
+	return chatID, userID, true
+}
+
+// writeChatError maps chat service errors to HTTP statuses
+func writeChatError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrChatNotFound), errors.Is(err, service.ErrInviteNotFound),
+		errors.Is(err, service.ErrInvalidInvite), errors.Is(err, service.ErrUserNotFound),
+		errors.Is(err, service.ErrBanNotFound), errors.Is(err, service.ErrMessageNotInChat):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrNotChatMember), errors.Is(err, service.ErrPermissionDenied),
+		errors.Is(err, service.ErrPrivateChat), errors.Is(err, service.ErrBanned),
+		errors.Is(err, service.ErrBlocked), errors.Is(err, service.ErrEmailNotVerified):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrLastOwner):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrInvalidChatName), errors.Is(err, service.ErrInvalidRole),
+		errors.Is(err, service.ErrSelfManagement), errors.Is(err, service.ErrInvalidVisibility),
+		errors.Is(err, service.ErrInvalidInviteOpts), errors.Is(err, service.ErrDirectToSelf),
+		errors.Is(err, service.ErrInvalidBan):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
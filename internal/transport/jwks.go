@@ -0,0 +1,17 @@
+package transport
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"rtcs/internal/middleware"
+)
+
+// JWKS publishes the public keys tokens are signed with so other services can
+// verify them. Keys retired from signing stay listed until removed from the
+// configuration.
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(middleware.Keys().JWKS())
+}
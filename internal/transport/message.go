@@ -1,7 +1,9 @@
 package transport
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -14,8 +16,14 @@ import (
 
 // SendMessageRequest represents the request body for sending a message
 type SendMessageRequest struct {
-	ChatID string `json:"chat_id"`
-	Text   string `json:"text"`
+	ChatID   string `json:"chat_id"`
+	Text     string `json:"text"`
+	ParentID string `json:"parent_id,omitempty"` // Message being replied to
+}
+
+// EditMessageRequest represents the request body for editing a message
+type EditMessageRequest struct {
+	Text string `json:"text"`
 }
 
 // MessageHandler handles message-related requests
@@ -59,9 +67,21 @@ func (h *MessageHandler) Send(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	message, err := h.messageService.SendMessage(r.Context(), req.ChatID, userID.String(), req.Text)
+	message, err := h.messageService.SendMessage(r.Context(), req.ChatID, userID.String(), req.Text, req.ParentID)
 	if err != nil {
 		log.Printf("Error sending message: %v", err)
+		if errors.Is(err, service.ErrParentNotFound) {
+			http.Error(w, "Parent message not found in this chat", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, service.ErrEmailNotVerified) {
+			http.Error(w, "Email address is not verified", http.StatusForbidden)
+			return
+		}
+		if isForbidden(err) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 		http.Error(w, "Failed to send message", http.StatusInternalServerError)
 		return
 	}
@@ -104,28 +124,195 @@ func (h *MessageHandler) GetChatHistory(w http.ResponseWriter, r *http.Request)
 	}
 	log.Printf("User ID from context: %s", userID.String())
 
-	limit := 50 // Default limit
+	query := service.HistoryQuery{
+		UserID:        userID,
+		Before:        r.URL.Query().Get("before"),
+		After:         r.URL.Query().Get("after"),
+		EmbedProfiles: r.URL.Query().Get("embed") == "profiles",
+	}
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil {
-			limit = l
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
 		}
+		query.Limit = l
 	}
-	log.Printf("Using limit: %d", limit)
+	if query.Before != "" && query.After != "" {
+		http.Error(w, "Only one of before and after may be set", http.StatusBadRequest)
+		return
+	}
+	log.Printf("Using limit: %d", query.Limit)
 
-	messages, err := h.messageService.GetChatHistory(r.Context(), chatID, limit)
+	page, err := h.messageService.GetChatHistory(r.Context(), chatID, query)
 	if err != nil {
 		log.Printf("Error getting chat history: %v", err)
+		if errors.Is(err, service.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		if isForbidden(err) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 		http.Error(w, "Failed to get chat history", http.StatusInternalServerError)
 		return
 	}
-	log.Printf("Retrieved %d messages", len(messages))
+	log.Printf("Retrieved %d messages", len(page.Messages))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// GetThread handles retrieving the thread a message belongs to
+func (h *MessageHandler) GetThread(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received get thread request")
+
+	vars := mux.Vars(r)
+	messageID := vars["messageId"]
+	if _, err := uuid.Parse(messageID); err != nil {
+		log.Printf("Error parsing message ID: %v", err)
+		http.Error(w, "Invalid message ID format", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		log.Printf("Error: user_id not found in context or wrong type")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit := service.DefaultHistoryLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	thread, err := h.messageService.GetThread(r.Context(), messageID, userID.String(), limit)
+	if err != nil {
+		log.Printf("Error getting thread: %v", err)
+		if errors.Is(err, service.ErrMessageNotFound) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		if isForbidden(err) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Failed to get thread", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Retrieved thread %s with %d replies", thread.Root.ID, len(thread.Replies))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(thread); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+// EditMessage handles message editing
+func (h *MessageHandler) EditMessage(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received edit message request")
+
+	vars := mux.Vars(r)
+	messageID := vars["messageId"]
+	if _, err := uuid.Parse(messageID); err != nil {
+		log.Printf("Error parsing message ID: %v", err)
+		http.Error(w, "Invalid message ID format", http.StatusBadRequest)
+		return
+	}
+
+	var req EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("Error decoding request body: %v", err)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Text == "" {
+		http.Error(w, "Message text cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		log.Printf("Error: user_id not found in context or wrong type")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	message, err := h.messageService.EditMessage(r.Context(), messageID, userID.String(), req.Text)
+	if err != nil {
+		log.Printf("Error editing message: %v", err)
+		if errors.Is(err, service.ErrMessageNotFound) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, service.ErrNotMessageOwner) || isForbidden(err) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Failed to edit message", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Message edited successfully: %s", message.ID)
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(messages); err != nil {
+	if err := json.NewEncoder(w).Encode(message); err != nil {
 		log.Printf("Error encoding response: %v", err)
 	}
 }
 
+// AddReaction handles reacting to a message
+func (h *MessageHandler) AddReaction(w http.ResponseWriter, r *http.Request) {
+	h.changeReaction(w, r, h.messageService.AddReaction)
+}
+
+// RemoveReaction handles taking back a reaction
+func (h *MessageHandler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	h.changeReaction(w, r, h.messageService.RemoveReaction)
+}
+
+func (h *MessageHandler) changeReaction(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, messageID, userID, emoji string) error) {
+	vars := mux.Vars(r)
+	messageID := vars["messageId"]
+	if _, err := uuid.Parse(messageID); err != nil {
+		log.Printf("Error parsing message ID: %v", err)
+		http.Error(w, "Invalid message ID format", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		log.Printf("Error: user_id not found in context or wrong type")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := change(r.Context(), messageID, userID.String(), vars["emoji"]); err != nil {
+		log.Printf("Error changing reaction on message %s: %v", messageID, err)
+		switch {
+		case errors.Is(err, service.ErrInvalidEmoji):
+			http.Error(w, "Invalid emoji", http.StatusBadRequest)
+		case errors.Is(err, service.ErrMessageNotFound):
+			http.Error(w, "Message not found", http.StatusNotFound)
+		case isForbidden(err):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		default:
+			http.Error(w, "Failed to update reaction", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // DeleteMessage handles message deletion
 func (h *MessageHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Received delete message request")
@@ -149,6 +336,14 @@ func (h *MessageHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 
 	if err := h.messageService.DeleteMessage(r.Context(), messageID, userID.String()); err != nil {
 		log.Printf("Error deleting message: %v", err)
+		if errors.Is(err, service.ErrMessageNotFound) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, service.ErrNotMessageOwner) || isForbidden(err) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 		http.Error(w, "Failed to delete message", http.StatusInternalServerError)
 		return
 	}
@@ -156,3 +351,8 @@ func (h *MessageHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// isForbidden reports whether err is a permission failure from the service
+func isForbidden(err error) bool {
+	return errors.Is(err, service.ErrNotChatMember) || errors.Is(err, service.ErrPermissionDenied)
+}
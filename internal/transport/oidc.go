@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"rtcs/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+// oidcStateCookie binds a provider login to the browser that started it, so
+// a callback URL planted by someone else does not log the victim in as them
+const oidcStateCookie = "oidc_state"
+
+// StartOIDCLogin redirects the user to the provider to log in
+func (h *AuthHandler) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, state, err := h.authService.StartOIDCLogin(r.Context(), mux.Vars(r)["provider"])
+	if err != nil {
+		writeOIDCError(w, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc",
+		MaxAge:   int(service.OIDCStateTTL.Seconds()),
+		HttpOnly: true,
+		// The provider's redirect back is a top-level navigation, which Lax
+		// cookies survive
+		SameSite: http.SameSiteLaxMode,
+		Secure:   isHTTPS(r),
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes a provider login, answering like Login
+func (h *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		http.Error(w, service.ErrInvalidOIDCState.Error(), http.StatusBadRequest)
+		return
+	}
+	// The state is used up whatever happens next
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/auth/oidc", MaxAge: -1, HttpOnly: true})
+
+	if reason := query.Get("error"); reason != "" {
+		// The user cancelled or the provider refused; the provider explains
+		// in error_description
+		log.Printf("OIDC provider %s refused the login: %s %s", mux.Vars(r)["provider"], reason, query.Get("error_description"))
+		http.Error(w, service.ErrOIDCLoginFailed.Error(), http.StatusUnauthorized)
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		http.Error(w, "Missing code", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := h.authService.FinishOIDCLogin(r.Context(), mux.Vars(r)["provider"], state, code)
+	var mfa *service.MFARequiredError
+	if errors.As(err, &mfa) {
+		// The client continues at /auth/login/mfa
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(MFAChallengeResponse{MFARequired: true, MFAToken: mfa.Token, ExpiresIn: mfa.ExpiresIn})
+		return
+	}
+	if err != nil {
+		writeOIDCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// writeOIDCError maps provider login errors to HTTP statuses
+func writeOIDCError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrUnknownProvider):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidOIDCState), errors.Is(err, service.ErrInvalidEmail):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrOIDCLoginFailed):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, service.ErrProviderEmailNotVerified):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrAccountNotLinkable):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("OIDC login failed: %v", err)
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+	}
+}
+
+// isHTTPS reports whether the client reached us over HTTPS, directly or
+// through a proxy terminating TLS. A client lying about it only weakens its
+// own cookie.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"rtcs/internal/model"
+	"rtcs/internal/service"
+
+	"github.com/google/uuid"
+)
+
+// SearchHandler handles search requests
+type SearchHandler struct {
+	searchService *service.SearchService
+}
+
+// NewSearchHandler creates a new search handler
+func NewSearchHandler(searchService *service.SearchService) *SearchHandler {
+	return &SearchHandler{
+		searchService: searchService,
+	}
+}
+
+// SearchMessagesResponse is the response body of a message search
+type SearchMessagesResponse struct {
+	Results []*model.SearchResult `json:"results"`
+}
+
+// SearchMessages handles full-text search over the caller's chats
+func (h *SearchHandler) SearchMessages(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(uuid.UUID)
+	if !ok {
+		log.Printf("Error: user_id not found in context or wrong type")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params := r.URL.Query()
+	query := &model.SearchQuery{
+		UserID: userID,
+		Text:   params.Get("q"),
+	}
+
+	var err error
+	if query.ChatID, err = optionalUUID(params.Get("chat_id")); err != nil {
+		http.Error(w, "Invalid chat ID format", http.StatusBadRequest)
+		return
+	}
+	if query.SenderID, err = optionalUUID(params.Get("sender_id")); err != nil {
+		http.Error(w, "Invalid sender ID format", http.StatusBadRequest)
+		return
+	}
+	if query.From, err = optionalTime(params.Get("from")); err != nil {
+		http.Error(w, "Invalid from time, expected RFC 3339", http.StatusBadRequest)
+		return
+	}
+	if query.To, err = optionalTime(params.Get("to")); err != nil {
+		http.Error(w, "Invalid to time, expected RFC 3339", http.StatusBadRequest)
+		return
+	}
+	if query.Limit, err = optionalInt(params.Get("limit")); err != nil || query.Limit < 0 {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	if query.Offset, err = optionalInt(params.Get("offset")); err != nil || query.Offset < 0 {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+
+	results, err := h.searchService.SearchMessages(r.Context(), query)
+	if err != nil {
+		log.Printf("Error searching messages for %s: %v", userID, err)
+		if errors.Is(err, service.ErrEmptySearch) || errors.Is(err, service.ErrSearchTooLong) ||
+			errors.Is(err, service.ErrInvalidSearchRange) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to search messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(SearchMessagesResponse{Results: results}); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+func optionalUUID(s string) (*uuid.UUID, error) {
+	if s == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func optionalTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func optionalInt(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
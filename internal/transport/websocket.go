@@ -1,24 +1,43 @@
 package transport
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"rtcs/internal/fanout"
+	"rtcs/internal/middleware"
+	"rtcs/internal/model"
+	"rtcs/internal/service"
+
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"golang.org/x/time/rate"
 )
 
 const (
-	maxMessageSize = 4096 // 4KB
-	writeWait     = 10 * time.Second
-	pongWait      = 60 * time.Second
-	pingPeriod    = (pongWait * 9) / 10
-	maxConnections = 10000
-	messagesPerSecond = 5 // Rate limit: messages per second per client
+	maxMessageSize    = 4096 // 4KB
+	writeWait         = 10 * time.Second
+	pongWait          = 60 * time.Second
+	pingPeriod        = (pongWait * 9) / 10
+	maxConnections    = 10000
+	messagesPerSecond = 5  // Rate limit: messages per second per client
+	messageBurst      = 10 // Frames allowed at once, e.g. subscribing to several chats on connect
+	dbTimeout         = 5 * time.Second
+	maxReplay         = 100 // Messages replayed per chat and resume frame; must stay below the send buffer size
+
+	// typingTimeout expires typing indicators unless refreshed by another typing_start
+	typingTimeout = 5 * time.Second
+
+	// bearerSubprotocol is the Sec-WebSocket-Protocol value browsers use to
+	// pass a token during the handshake: new WebSocket(url, ["bearer", token])
+	bearerSubprotocol = "bearer"
 )
 
 var upgrader = websocket.Upgrader{
@@ -33,24 +52,41 @@ var upgrader = websocket.Upgrader{
 }
 
 type Client struct {
-	conn     *websocket.Conn
-	userID   string
-	send     chan []byte
-	handler  *WebSocketHandler
-	limiter  *rate.Limiter
-	closed   bool
-	closeMux sync.RWMutex
+	conn      *websocket.Conn
+	userID    string
+	expiresAt time.Time // Zero if the token carries no expiry
+	send      chan []byte
+	handler   *WebSocketHandler
+	limiter   *rate.Limiter
+	closed    bool
+	closeMux  sync.RWMutex
+	chats     map[string]bool  // Chat IDs this client is subscribed to, guarded by handler.clientsMux
+	blocked   map[string]bool  // Users whose frames are held back from this client, guarded by handler.clientsMux
 }
 
 type WebSocketHandler struct {
-	clients    map[*Client]bool
-	clientsMux sync.RWMutex
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	stats      *WebSocketStats
-	shutdown   chan struct{}
-	userIDs    map[string]*Client // Map to track user IDs to clients
+	clients     map[*Client]bool
+	clientsMux  sync.RWMutex
+	broadcast   chan outboundMessage
+	register    chan *Client
+	unregister  chan *Client
+	stats       *WebSocketStats
+	shutdown    chan struct{}
+	userIDs     map[string]map[*Client]bool // All open connections per user
+	rooms       map[string]map[*Client]bool // Subscribers per chat ID
+	chatService *service.ChatService
+	msgService  *service.MessageService
+	fanout      fanout.Fanout
+	blocks      service.BlockList
+	typing      map[string]*time.Timer // Expiry timers keyed by typingKey, for users typing on this node
+	typingMux   sync.Mutex
+}
+
+// outboundMessage is a frame queued for delivery by the hub
+type outboundMessage struct {
+	chatID   string // Empty for frames delivered to every client
+	authorID string // User the frame is from, if any; skipped for clients blocking them
+	data     []byte
 }
 
 type WebSocketStats struct {
@@ -60,29 +96,62 @@ type WebSocketStats struct {
 	Errors           int64
 }
 
-func NewWebSocketHandler() *WebSocketHandler {
+// NewWebSocketHandler creates the hub. Events are published through fo and
+// delivered to local clients as they come back, so several nodes sharing a
+// backend behave as one hub.
+func NewWebSocketHandler(chatService *service.ChatService, msgService *service.MessageService, fo fanout.Fanout) (*WebSocketHandler, error) {
 	h := &WebSocketHandler{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte, 256),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		stats:      &WebSocketStats{},
-		shutdown:   make(chan struct{}),
-		userIDs:    make(map[string]*Client),
+		clients:     make(map[*Client]bool),
+		broadcast:   make(chan outboundMessage, 256),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+		stats:       &WebSocketStats{},
+		shutdown:    make(chan struct{}),
+		userIDs:     make(map[string]map[*Client]bool),
+		rooms:       make(map[string]map[*Client]bool),
+		chatService: chatService,
+		msgService:  msgService,
+		fanout:      fo,
+		typing:      make(map[string]*time.Timer),
+	}
+
+	if err := fo.Subscribe(context.Background(), h.deliver); err != nil {
+		return nil, err
 	}
 
 	// Start the broadcast handler
 	go h.run()
 
-	return h
+	return h, nil
+}
+
+// SetBlockList enables holding back frames from users a client has blocked
+func (h *WebSocketHandler) SetBlockList(blocks service.BlockList) {
+	h.blocks = blocks
 }
 
 type WebSocketMessage struct {
-	Type   string   `json:"type"`
-	UserID string   `json:"userId,omitempty"`
-	Text   string   `json:"text,omitempty"`
-	Sender string   `json:"sender,omitempty"`
-	Users  []string `json:"users,omitempty"` // Add users field for user list
+	Type      string     `json:"type"`
+	ChatID    string     `json:"chatId,omitempty"`
+	MessageID string     `json:"messageId,omitempty"`
+	ParentID  string     `json:"parentId,omitempty"` // Thread root of a reply
+	Emoji     string     `json:"emoji,omitempty"`
+	UserID    string     `json:"userId,omitempty"`
+	Text      string     `json:"text,omitempty"`
+	Sender    string     `json:"sender,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	Users     []string   `json:"users,omitempty"` // Add users field for user list
+	Error     string     `json:"error,omitempty"`
+
+	// Delivery tracking: Seq is the per-chat sequence number of a message;
+	// Chats maps chat IDs to the last sequence number seen in a resume frame;
+	// More is set on resumed frames when further messages remain to be
+	// replayed. The server keeps no delivery state between connections, so
+	// clients track the highest seq they received per chat themselves.
+	Seq   int64            `json:"seq,omitempty"`
+	Chats map[string]int64 `json:"chats,omitempty"`
+	More  bool             `json:"more,omitempty"`
 }
 
 func (h *WebSocketHandler) run() {
@@ -91,6 +160,10 @@ func (h *WebSocketHandler) run() {
 		case client := <-h.register:
 			h.clientsMux.Lock()
 			h.clients[client] = true
+			if h.userIDs[client.userID] == nil {
+				h.userIDs[client.userID] = make(map[*Client]bool)
+			}
+			h.userIDs[client.userID][client] = true
 			h.clientsMux.Unlock()
 			atomic.AddInt64(&h.stats.ActiveConnections, 1)
 
@@ -98,6 +171,13 @@ func (h *WebSocketHandler) run() {
 			h.clientsMux.Lock()
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
+				for chatID := range client.chats {
+					h.removeFromRoom(client, chatID)
+				}
+				delete(h.userIDs[client.userID], client)
+				if len(h.userIDs[client.userID]) == 0 {
+					delete(h.userIDs, client.userID)
+				}
 				close(client.send)
 			}
 			h.clientsMux.Unlock()
@@ -105,13 +185,21 @@ func (h *WebSocketHandler) run() {
 
 		case message := <-h.broadcast:
 			h.clientsMux.RLock()
-			for client := range h.clients {
+			recipients := h.clients
+			if message.chatID != "" {
+				recipients = h.rooms[message.chatID]
+			}
+			for client := range recipients {
+				if message.authorID != "" && client.blocked[message.authorID] {
+					continue
+				}
 				select {
-				case client.send <- message:
+				case client.send <- message.data:
 					atomic.AddInt64(&h.stats.MessagesSent, 1)
 				default:
-					client.close()
-					h.unregister <- client
+					// The client can't keep up; make it reconnect and resume
+					// rather than silently losing frames
+					go client.evict("send buffer full, resume from last seen seq")
 				}
 			}
 			h.clientsMux.RUnlock()
@@ -137,21 +225,49 @@ func (c *Client) close() {
 	}
 }
 
+// evict closes a connection that fell behind, using a close code that tells
+// the client to reconnect and send a resume frame
+func (c *Client) evict(reason string) {
+	c.closeMux.Lock()
+	defer c.closeMux.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	log.Printf("Evicting client %s: %s", c.userID, reason)
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, reason)
+	c.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(writeWait))
+	c.conn.Close()
+}
+
 func (c *Client) readPump() {
 	defer func() {
-		// If user was identified, notify others about leave
-		if c.userID != "" {
-			c.handler.broadcastMessage(WebSocketMessage{
-				Type:   "user_leave",
-				UserID: c.userID,
-			})
-			c.handler.clientsMux.Lock()
-			delete(c.handler.userIDs, c.userID)
-			c.handler.clientsMux.Unlock()
+		c.handler.clientsMux.RLock()
+		chatIDs := make([]string, 0, len(c.chats))
+		for chatID := range c.chats {
+			chatIDs = append(chatIDs, chatID)
+		}
+		c.handler.clientsMux.RUnlock()
+		for _, chatID := range chatIDs {
+			c.handler.stopTyping(chatID, c.userID)
 		}
 
+		// Notify others about leave
+		c.handler.broadcastMessage(WebSocketMessage{
+			Type:   "user_leave",
+			UserID: c.userID,
+		})
+
 		c.handler.unregister <- c
 		c.close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		defer cancel()
+		if err := c.handler.fanout.UserOffline(ctx, c.userID); err != nil {
+			log.Printf("Error updating presence for %s: %v", c.userID, err)
+		}
 		log.Printf("WebSocket connection closed: %s", c.userID)
 	}()
 
@@ -184,41 +300,60 @@ func (c *Client) readPump() {
 		var wsMsg WebSocketMessage
 		if err := json.Unmarshal(message, &wsMsg); err != nil {
 			log.Printf("Error parsing WebSocket message: %v", err)
-			// If not JSON, treat as regular message
-			c.handler.broadcastMessage(WebSocketMessage{
-				Type: "message",
-				Text: string(message),
-			})
+			c.sendError("", "invalid message format")
 			continue
 		}
 
+		// The identity always comes from the handshake token; anything the
+		// client puts in userId or sender is ignored
+		wsMsg.UserID = c.userID
+		wsMsg.Sender = ""
+
 		// Handle different message types
 		switch wsMsg.Type {
 		case "user_join":
-			log.Printf("User joined: %s", wsMsg.UserID)
-			c.handler.clientsMux.Lock()
-			c.userID = wsMsg.UserID
-			c.handler.userIDs[wsMsg.UserID] = c
-			c.handler.clientsMux.Unlock()
+			log.Printf("User joined: %s", c.userID)
 			// Broadcast the user join to all clients
 			c.handler.broadcastMessage(wsMsg)
 			// Send user list to the new client
 			c.handler.sendUserList(c)
 		case "user_leave":
-			log.Printf("User left: %s", wsMsg.UserID)
-			if c.userID != "" {
-				c.handler.clientsMux.Lock()
-				delete(c.handler.userIDs, c.userID)
-				c.handler.clientsMux.Unlock()
-				c.handler.broadcastMessage(wsMsg)
+			log.Printf("User left: %s", c.userID)
+			c.handler.broadcastMessage(wsMsg)
+		case "subscribe":
+			c.subscribe(wsMsg.ChatID)
+		case "resume":
+			c.resume(wsMsg.Chats)
+		case "unsubscribe":
+			c.unsubscribe(wsMsg.ChatID)
+		case "typing_start", "typing_stop":
+			chatID, ok := c.subscribedChat(wsMsg.ChatID)
+			if !ok {
+				continue
+			}
+			if wsMsg.Type == "typing_start" {
+				c.handler.startTyping(chatID, c.userID)
+			} else {
+				c.handler.stopTyping(chatID, c.userID)
 			}
+		case "read":
+			chatID, ok := c.subscribedChat(wsMsg.ChatID)
+			if !ok {
+				continue
+			}
+			c.markRead(chatID, wsMsg.MessageID)
+		case "edit":
+			c.editMessage(wsMsg.MessageID, wsMsg.Text)
 		case "message":
-			log.Printf("Message from %s: %s", c.userID, wsMsg.Text)
-			wsMsg.Sender = c.userID
-			c.handler.broadcastMessage(wsMsg)
+			chatID, ok := c.subscribedChat(wsMsg.ChatID)
+			if !ok {
+				continue
+			}
+			log.Printf("Message from %s to chat %s: %s", c.userID, chatID, wsMsg.Text)
+			c.sendMessage(chatID, wsMsg.Text, wsMsg.ParentID)
 		default:
-			log.Printf("Unknown message type: %s", wsMsg.Type)
-			c.handler.broadcastMessage(wsMsg)
+			log.Printf("Ignoring unknown message type from %s: %s", c.userID, wsMsg.Type)
+			continue
 		}
 
 		atomic.AddInt64(&c.handler.stats.MessagesReceived, 1)
@@ -227,6 +362,15 @@ func (c *Client) readPump() {
 
 func (c *Client) writePump() {
 	ticker := time.NewTicker(pingPeriod)
+
+	// Close the session once the token used for the handshake expires
+	var expired <-chan time.Time
+	if !c.expiresAt.IsZero() {
+		expiry := time.NewTimer(time.Until(c.expiresAt))
+		defer expiry.Stop()
+		expired = expiry.C
+	}
+
 	defer func() {
 		ticker.Stop()
 		c.close()
@@ -263,6 +407,12 @@ func (c *Client) writePump() {
 				log.Printf("Error writing ping: %v", err)
 				return
 			}
+
+		case <-expired:
+			log.Printf("Token expired for %s, closing WebSocket", c.userID)
+			closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token expired")
+			c.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(writeWait))
+			return
 		}
 	}
 }
@@ -275,8 +425,40 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 	}
 
 	log.Printf("New WebSocket connection request from %s", r.RemoteAddr)
-	
-	conn, err := upgrader.Upgrade(w, r, nil)
+
+	// Authenticate before upgrading so unauthenticated clients get a plain 401
+	tokenString, viaSubprotocol := tokenFromRequest(r)
+	if tokenString == "" {
+		log.Printf("Connection rejected: no token provided")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	claims, err := middleware.ValidateToken(tokenString)
+	if err != nil || claims.UserID == "" {
+		log.Printf("Connection rejected: invalid token: %v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := middleware.CheckRevoked(r.Context(), claims); err != nil {
+		log.Printf("Connection rejected: %v", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	blocked, err := h.blockedBy(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("Error loading blocked users of %s: %v", claims.UserID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Browsers fail the handshake unless the server echoes a subprotocol
+	var responseHeader http.Header
+	if viaSubprotocol {
+		responseHeader = http.Header{"Sec-WebSocket-Protocol": {bearerSubprotocol}}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Printf("Failed to upgrade connection: %v", err)
 		return
@@ -284,20 +466,450 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 
 	client := &Client{
 		conn:    conn,
+		userID:  claims.UserID,
 		send:    make(chan []byte, 256),
 		handler: h,
-		limiter: rate.NewLimiter(rate.Limit(messagesPerSecond), 1),
+		limiter: rate.NewLimiter(rate.Limit(messagesPerSecond), messageBurst),
+		blocked: blocked,
+	}
+	if claims.ExpiresAt != nil {
+		client.expiresAt = claims.ExpiresAt.Time
 	}
 
 	log.Printf("WebSocket connection established from %s", r.RemoteAddr)
 	h.register <- client
 
+	ctx, cancel := context.WithTimeout(r.Context(), dbTimeout)
+	defer cancel()
+	if err := h.fanout.UserOnline(ctx, client.userID); err != nil {
+		log.Printf("Error updating presence for %s: %v", client.userID, err)
+	}
+
 	// Allow collection of memory referenced by the caller by doing all work in
 	// new goroutines.
 	go client.writePump()
 	go client.readPump()
 }
 
+// blockedBy returns the set of users blocked by userID
+func (h *WebSocketHandler) blockedBy(ctx context.Context, userID string) (map[string]bool, error) {
+	blocked := make(map[string]bool)
+	if h.blocks == nil {
+		return blocked, nil
+	}
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	users, err := h.blocks.BlockedUsers(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for blockedID := range users {
+		blocked[blockedID.String()] = true
+	}
+	return blocked, nil
+}
+
+// tokenFromRequest extracts the JWT from the handshake. It is looked up in the
+// Authorization header, then the Sec-WebSocket-Protocol header ("bearer, <token>"),
+// then the token query parameter. viaSubprotocol reports whether the
+// subprotocol form was used, in which case it has to be echoed back.
+func tokenFromRequest(r *http.Request) (token string, viaSubprotocol bool) {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		return strings.TrimPrefix(authHeader, "Bearer "), false
+	}
+
+	protocols := websocket.Subprotocols(r)
+	for i := 0; i+1 < len(protocols); i++ {
+		if protocols[i] == bearerSubprotocol {
+			return protocols[i+1], true
+		}
+	}
+
+	return r.URL.Query().Get("token"), false
+}
+
+// subscribe adds the client to a chat room after checking membership. It
+// returns the normalised chat ID and whether the subscription succeeded.
+func (c *Client) subscribe(chatIDStr string) (string, bool) {
+	chatID, err := uuid.Parse(chatIDStr)
+	if err != nil {
+		c.sendError(chatIDStr, "invalid chat ID")
+		return "", false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userID, err := uuid.Parse(c.userID)
+	if err != nil {
+		c.sendError(chatIDStr, "invalid user ID")
+		return "", false
+	}
+	if err := c.handler.chatService.CanSubscribe(ctx, chatID, userID); err != nil {
+		switch {
+		case errors.Is(err, service.ErrNotChatMember):
+			c.sendError(chatIDStr, "not a member of this chat")
+		case errors.Is(err, service.ErrBanned):
+			c.sendError(chatIDStr, "banned from this chat")
+		default:
+			log.Printf("Error checking membership of %s in chat %s: %v", c.userID, chatID, err)
+			c.sendError(chatIDStr, "failed to subscribe")
+		}
+		return "", false
+	}
+
+	c.handler.clientsMux.Lock()
+	c.handler.addToRoom(c, chatID.String())
+	c.handler.clientsMux.Unlock()
+
+	log.Printf("Client %s subscribed to chat %s", c.userID, chatID)
+	c.handler.sendTo(c, WebSocketMessage{Type: "subscribed", ChatID: chatID.String()})
+	return chatID.String(), true
+}
+
+// resume subscribes the client to each chat and replays the messages it missed
+// after the given sequence numbers. Messages that arrive live while replaying
+// may be delivered twice; clients drop duplicates by seq.
+func (c *Client) resume(lastSeen map[string]int64) {
+	for chatIDStr, afterSeq := range lastSeen {
+		chatID, ok := c.subscribe(chatIDStr)
+		if !ok {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		messages, err := c.handler.msgService.GetMessagesSince(ctx, chatID, afterSeq, maxReplay+1)
+		cancel()
+		if err != nil {
+			log.Printf("Error replaying chat %s for %s: %v", chatID, c.userID, err)
+			c.sendError(chatID, "failed to resume")
+			continue
+		}
+
+		more := len(messages) > maxReplay
+		if more {
+			messages = messages[:maxReplay]
+		}
+
+		lastSeq := afterSeq
+		for _, message := range messages {
+			// Held back messages still count as seen, so resuming moves on
+			lastSeq = message.Seq
+			if c.blocks(message.SenderID.String()) {
+				continue
+			}
+			c.handler.sendTo(c, messageFrame(message))
+		}
+
+		// If more is set the client sends another resume from seq
+		c.handler.sendTo(c, WebSocketMessage{Type: "resumed", ChatID: chatID, Seq: lastSeq, More: more})
+	}
+}
+
+// blocks reports whether the client's user has blocked userID
+func (c *Client) blocks(userID string) bool {
+	c.handler.clientsMux.RLock()
+	defer c.handler.clientsMux.RUnlock()
+	return c.blocked[userID]
+}
+
+// unsubscribe removes the client from a chat room
+func (c *Client) unsubscribe(chatIDStr string) {
+	chatID, err := uuid.Parse(chatIDStr)
+	if err != nil {
+		c.sendError(chatIDStr, "invalid chat ID")
+		return
+	}
+
+	c.handler.clientsMux.Lock()
+	c.handler.removeFromRoom(c, chatID.String())
+	c.handler.clientsMux.Unlock()
+
+	log.Printf("Client %s unsubscribed from chat %s", c.userID, chatID)
+	c.handler.sendTo(c, WebSocketMessage{Type: "unsubscribed", ChatID: chatID.String()})
+}
+
+// subscribedChat normalises a chat ID and reports whether the client may send
+// to it, replying with an error frame if not
+func (c *Client) subscribedChat(chatIDStr string) (string, bool) {
+	chatID, err := uuid.Parse(chatIDStr)
+	if err != nil {
+		c.sendError(chatIDStr, "invalid chat ID")
+		return "", false
+	}
+
+	c.handler.clientsMux.RLock()
+	subscribed := c.chats[chatID.String()]
+	c.handler.clientsMux.RUnlock()
+
+	if !subscribed {
+		c.sendError(chatIDStr, "not subscribed to this chat")
+		return "", false
+	}
+	return chatID.String(), true
+}
+
+// sendMessage persists a message through the message service, which in turn
+// fans it out to the chat's subscribers via MessageSent
+func (c *Client) sendMessage(chatID, text, parentID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if _, err := c.handler.msgService.SendMessage(ctx, chatID, c.userID, text, parentID); err != nil {
+		log.Printf("Error sending message from %s to chat %s: %v", c.userID, chatID, err)
+		reason := "failed to send message"
+		switch {
+		case errors.Is(err, service.ErrParentNotFound):
+			reason = "parent message not found in this chat"
+		case errors.Is(err, service.ErrEmailNotVerified):
+			reason = "email address is not verified"
+		}
+		c.sendError(chatID, reason)
+	}
+}
+
+// editMessage edits one of the user's messages; the change reaches the room
+// through MessageEdited
+func (c *Client) editMessage(messageID, text string) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if _, err := c.handler.msgService.EditMessage(ctx, messageID, c.userID, text); err != nil {
+		log.Printf("Error editing message %s for %s: %v", messageID, c.userID, err)
+		reason := "failed to edit message"
+		if errors.Is(err, service.ErrNotMessageOwner) {
+			reason = "not the sender of this message"
+		}
+		c.handler.sendTo(c, WebSocketMessage{Type: "error", MessageID: messageID, Error: reason})
+	}
+}
+
+// markRead moves the user's read pointer; the receipt reaches the room
+// through MessagesRead
+func (c *Client) markRead(chatIDStr, messageIDStr string) {
+	messageID, err := uuid.Parse(messageIDStr)
+	if err != nil {
+		c.sendError(chatIDStr, "invalid message ID")
+		return
+	}
+	// Both IDs were validated by subscribedChat and the handshake
+	chatID, _ := uuid.Parse(chatIDStr)
+	userID, _ := uuid.Parse(c.userID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if _, err := c.handler.chatService.MarkRead(ctx, chatID, userID, messageID); err != nil {
+		if errors.Is(err, service.ErrMessageNotInChat) {
+			c.sendError(chatIDStr, err.Error())
+			return
+		}
+		log.Printf("Error marking chat %s read for %s: %v", chatID, c.userID, err)
+		c.sendError(chatIDStr, "failed to mark as read")
+	}
+}
+
+func (c *Client) sendError(chatID, reason string) {
+	c.handler.sendTo(c, WebSocketMessage{Type: "error", ChatID: chatID, Error: reason})
+}
+
+// addToRoom must be called with clientsMux held for writing
+func (h *WebSocketHandler) addToRoom(c *Client, chatID string) {
+	if h.rooms[chatID] == nil {
+		h.rooms[chatID] = make(map[*Client]bool)
+	}
+	h.rooms[chatID][c] = true
+	if c.chats == nil {
+		c.chats = make(map[string]bool)
+	}
+	c.chats[chatID] = true
+}
+
+// removeFromRoom must be called with clientsMux held for writing
+func (h *WebSocketHandler) removeFromRoom(c *Client, chatID string) {
+	delete(h.rooms[chatID], c)
+	if len(h.rooms[chatID]) == 0 {
+		delete(h.rooms, chatID)
+	}
+	delete(c.chats, chatID)
+}
+
+// ChatJoined subscribes a user's open connections, on any node, to a chat they
+// joined over REST
+func (h *WebSocketHandler) ChatJoined(chatID, userID uuid.UUID) {
+	h.publish(fanout.Event{Kind: fanout.KindJoin, ChatID: chatID.String(), UserID: userID.String()})
+	h.broadcastMessage(WebSocketMessage{Type: "member_join", ChatID: chatID.String(), UserID: userID.String()})
+}
+
+// ChatLeft removes a user's open connections, on any node, from a chat they
+// left over REST
+func (h *WebSocketHandler) ChatLeft(chatID, userID uuid.UUID) {
+	h.publish(fanout.Event{Kind: fanout.KindLeave, ChatID: chatID.String(), UserID: userID.String()})
+	h.broadcastMessage(WebSocketMessage{Type: "member_leave", ChatID: chatID.String(), UserID: userID.String()})
+}
+
+// updateLocalRooms applies a membership change to this node's connections
+func (h *WebSocketHandler) updateLocalRooms(chatID, userID string, joined bool) {
+	h.clientsMux.Lock()
+	clients := make([]*Client, 0, len(h.userIDs[userID]))
+	for c := range h.userIDs[userID] {
+		if joined {
+			h.addToRoom(c, chatID)
+		} else {
+			h.removeFromRoom(c, chatID)
+		}
+		clients = append(clients, c)
+	}
+	h.clientsMux.Unlock()
+
+	msgType := "unsubscribed"
+	if joined {
+		msgType = "subscribed"
+	}
+	for _, c := range clients {
+		h.sendTo(c, WebSocketMessage{Type: msgType, ChatID: chatID})
+	}
+}
+
+// UserBlocked starts holding back the blocked user's frames from the
+// blocker's open connections, on any node
+func (h *WebSocketHandler) UserBlocked(blockerID, blockedID uuid.UUID) {
+	h.publish(fanout.Event{Kind: fanout.KindBlock, UserID: blockerID.String(), TargetID: blockedID.String()})
+}
+
+// UserUnblocked resumes delivering the unblocked user's frames to the
+// blocker's open connections, on any node
+func (h *WebSocketHandler) UserUnblocked(blockerID, blockedID uuid.UUID) {
+	h.publish(fanout.Event{Kind: fanout.KindUnblock, UserID: blockerID.String(), TargetID: blockedID.String()})
+}
+
+// updateLocalBlocks applies a block change to this node's connections
+func (h *WebSocketHandler) updateLocalBlocks(userID, targetID string, blocked bool) {
+	h.clientsMux.Lock()
+	defer h.clientsMux.Unlock()
+	for c := range h.userIDs[userID] {
+		if blocked {
+			if c.blocked == nil {
+				c.blocked = make(map[string]bool)
+			}
+			c.blocked[targetID] = true
+		} else {
+			delete(c.blocked, targetID)
+		}
+	}
+}
+
+// MessageSent delivers a persisted message to the chat's subscribers
+func (h *WebSocketHandler) MessageSent(message *model.Message) {
+	// Sending a message ends the sender's typing indicator
+	h.stopTyping(message.ChatID.String(), message.SenderID.String())
+	h.broadcastMessage(messageFrame(message))
+}
+
+// MessageEdited pushes the new text of a message to the chat's subscribers
+func (h *WebSocketHandler) MessageEdited(message *model.Message) {
+	frame := messageFrame(message)
+	frame.Type = "message_edited"
+	updatedAt := message.UpdatedAt
+	frame.UpdatedAt = &updatedAt
+	h.broadcastMessage(frame)
+}
+
+// ReactionAdded pushes a new reaction to the chat's subscribers
+func (h *WebSocketHandler) ReactionAdded(chatID uuid.UUID, reaction *model.MessageReaction) {
+	h.broadcastMessage(reactionFrame("reaction_added", chatID, reaction))
+}
+
+// ReactionRemoved pushes a withdrawn reaction to the chat's subscribers
+func (h *WebSocketHandler) ReactionRemoved(chatID uuid.UUID, reaction *model.MessageReaction) {
+	h.broadcastMessage(reactionFrame("reaction_removed", chatID, reaction))
+}
+
+func reactionFrame(frameType string, chatID uuid.UUID, reaction *model.MessageReaction) WebSocketMessage {
+	return WebSocketMessage{
+		Type:      frameType,
+		ChatID:    chatID.String(),
+		MessageID: reaction.MessageID.String(),
+		UserID:    reaction.UserID.String(),
+		Emoji:     reaction.Emoji,
+	}
+}
+
+// MessagesRead pushes a read receipt to the chat's subscribers
+func (h *WebSocketHandler) MessagesRead(read *model.ChatRead) {
+	h.broadcastMessage(WebSocketMessage{
+		Type:      "read",
+		ChatID:    read.ChatID.String(),
+		UserID:    read.UserID.String(),
+		MessageID: read.LastReadMessageID.String(),
+		Seq:       read.LastReadSeq,
+	})
+}
+
+func typingKey(chatID, userID string) string {
+	return chatID + "/" + userID
+}
+
+// startTyping announces that a user is typing, or extends the indicator if it
+// is already shown. It is cleared by stopTyping or after typingTimeout.
+func (h *WebSocketHandler) startTyping(chatID, userID string) {
+	key := typingKey(chatID, userID)
+
+	h.typingMux.Lock()
+	if timer, ok := h.typing[key]; ok {
+		timer.Reset(typingTimeout)
+		h.typingMux.Unlock()
+		return
+	}
+	h.typing[key] = time.AfterFunc(typingTimeout, func() {
+		h.stopTyping(chatID, userID)
+	})
+	h.typingMux.Unlock()
+
+	h.broadcastMessage(WebSocketMessage{Type: "typing_start", ChatID: chatID, UserID: userID})
+}
+
+// stopTyping clears a typing indicator if one is shown
+func (h *WebSocketHandler) stopTyping(chatID, userID string) {
+	key := typingKey(chatID, userID)
+
+	h.typingMux.Lock()
+	timer, ok := h.typing[key]
+	if ok {
+		timer.Stop()
+		delete(h.typing, key)
+	}
+	h.typingMux.Unlock()
+
+	if ok {
+		h.broadcastMessage(WebSocketMessage{Type: "typing_stop", ChatID: chatID, UserID: userID})
+	}
+}
+
+func messageFrame(message *model.Message) WebSocketMessage {
+	createdAt := message.CreatedAt
+	frame := WebSocketMessage{
+		Type:      "message",
+		ChatID:    message.ChatID.String(),
+		MessageID: message.ID.String(),
+		Text:      message.Text,
+		Sender:    message.SenderID.String(),
+		CreatedAt: &createdAt,
+		Seq:       message.Seq,
+	}
+	if message.ParentID != nil {
+		frame.ParentID = message.ParentID.String()
+	}
+	return frame
+}
+
+// broadcastMessage queues a frame for every subscriber of msg.ChatID, or for
+// every client if the frame is not scoped to a chat
 func (h *WebSocketHandler) broadcastMessage(msg WebSocketMessage) {
 	messageBytes, err := json.Marshal(msg)
 	if err != nil {
@@ -306,29 +918,92 @@ func (h *WebSocketHandler) broadcastMessage(msg WebSocketMessage) {
 	}
 
 	log.Printf("Broadcasting message: %s", string(messageBytes))
-	h.broadcast <- messageBytes
+	event := fanout.Event{Kind: fanout.KindBroadcast, UserID: frameAuthor(msg), Data: messageBytes}
+	if msg.ChatID != "" {
+		event.Kind = fanout.KindChat
+		event.ChatID = msg.ChatID
+	}
+	h.publish(event)
 }
 
-func (h *WebSocketHandler) sendUserList(client *Client) {
-	h.clientsMux.RLock()
-	users := make([]string, 0, len(h.clients))
-	for c := range h.clients {
-		if c.userID != "" {
-			users = append(users, c.userID)
-		}
+// frameAuthor returns the user whose activity a frame carries, for the frames
+// that are hidden from users who blocked them
+func frameAuthor(msg WebSocketMessage) string {
+	switch msg.Type {
+	case "message", "message_edited":
+		return msg.Sender
+	case "typing_start", "typing_stop", "reaction_added", "reaction_removed",
+		"read", "user_join", "user_leave":
+		return msg.UserID
+	default:
+		return ""
 	}
-	h.clientsMux.RUnlock()
+}
 
-	log.Printf("Sending user list: %v", users)
-	msg := WebSocketMessage{
-		Type:  "user_list",
-		Users: users,
+func (h *WebSocketHandler) publish(event fanout.Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	if err := h.fanout.Publish(ctx, event); err != nil {
+		log.Printf("Error publishing %s event: %v", event.Kind, err)
+		atomic.AddInt64(&h.stats.Errors, 1)
+	}
+}
+
+// deliver handles an event published by any node
+func (h *WebSocketHandler) deliver(event fanout.Event) {
+	switch event.Kind {
+	case fanout.KindBroadcast:
+		h.broadcast <- outboundMessage{authorID: event.UserID, data: event.Data}
+	case fanout.KindChat:
+		h.broadcast <- outboundMessage{chatID: event.ChatID, authorID: event.UserID, data: event.Data}
+	case fanout.KindJoin:
+		h.updateLocalRooms(event.ChatID, event.UserID, true)
+	case fanout.KindLeave:
+		h.updateLocalRooms(event.ChatID, event.UserID, false)
+	case fanout.KindBlock:
+		h.updateLocalBlocks(event.UserID, event.TargetID, true)
+	case fanout.KindUnblock:
+		h.updateLocalBlocks(event.UserID, event.TargetID, false)
+	default:
+		log.Printf("Unknown fanout event kind: %s", event.Kind)
 	}
+}
+
+// sendTo delivers a frame to a single client without blocking. Frames for
+// clients that have already been unregistered are dropped.
+func (h *WebSocketHandler) sendTo(c *Client, msg WebSocketMessage) {
 	msgBytes, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("Error marshaling user list: %v", err)
+		log.Printf("Error marshaling message: %v", err)
+		return
+	}
+
+	h.clientsMux.RLock()
+	defer h.clientsMux.RUnlock()
+	if !h.clients[c] {
+		return
+	}
+	select {
+	case c.send <- msgBytes:
+	default:
+		go c.evict("send buffer full, resume from last seen seq")
+	}
+}
+
+// sendUserList sends the users online anywhere in the cluster
+func (h *WebSocketHandler) sendUserList(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	users, err := h.fanout.OnlineUsers(ctx)
+	if err != nil {
+		log.Printf("Error listing online users: %v", err)
 		return
 	}
 
-	client.send <- msgBytes
+	log.Printf("Sending user list: %v", users)
+	h.sendTo(client, WebSocketMessage{
+		Type:  "user_list",
+		Users: users,
+	})
 }
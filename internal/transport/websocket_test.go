@@ -0,0 +1,782 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"rtcs/internal/fanout"
+	"rtcs/internal/middleware"
+	"rtcs/internal/model"
+	"rtcs/internal/repository"
+	"rtcs/internal/service"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// memberRepository is an in-memory chat repository for hub tests
+type memberRepository struct {
+	repository.Repository
+	mu       sync.Mutex
+	members  map[uuid.UUID]map[uuid.UUID]bool
+	reads    map[uuid.UUID]map[uuid.UUID]int64
+	messages *messageStore
+}
+
+func newMemberRepository() *memberRepository {
+	return &memberRepository{
+		members: make(map[uuid.UUID]map[uuid.UUID]bool),
+		reads:   make(map[uuid.UUID]map[uuid.UUID]int64),
+	}
+}
+
+func (m *memberRepository) GetChat(ctx context.Context, id uuid.UUID) (*model.Chat, error) {
+	return &model.Chat{ID: id}, nil
+}
+
+func (m *memberRepository) ListChats(ctx context.Context, userID uuid.UUID) ([]*model.Chat, error) {
+	return nil, nil
+}
+
+func (m *memberRepository) AddUserToChat(ctx context.Context, chatID, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.members[chatID] == nil {
+		m.members[chatID] = make(map[uuid.UUID]bool)
+	}
+	m.members[chatID][userID] = true
+	return nil
+}
+
+func (m *memberRepository) RemoveUserFromChat(ctx context.Context, chatID, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.members[chatID], userID)
+	return nil
+}
+
+func (m *memberRepository) IsMember(ctx context.Context, chatID, userID uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.members[chatID][userID], nil
+}
+
+func (m *memberRepository) GetBan(ctx context.Context, chatID, userID uuid.UUID) (*model.ChatBan, error) {
+	return nil, nil
+}
+
+func (m *memberRepository) GetMember(ctx context.Context, chatID, userID uuid.UUID) (*model.ChatUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.members[chatID][userID] {
+		return nil, nil
+	}
+	return &model.ChatUser{ChatID: chatID, UserID: userID, Role: model.RoleMember}, nil
+}
+
+func (m *memberRepository) GetMessage(ctx context.Context, id uuid.UUID) (*model.Message, error) {
+	return m.messages.GetMessage(ctx, id)
+}
+
+func (m *memberRepository) MarkRead(ctx context.Context, read *model.ChatRead) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.reads[read.ChatID] == nil {
+		m.reads[read.ChatID] = make(map[uuid.UUID]int64)
+	}
+	if read.LastReadSeq <= m.reads[read.ChatID][read.UserID] {
+		return false, nil
+	}
+	m.reads[read.ChatID][read.UserID] = read.LastReadSeq
+	return true, nil
+}
+
+// messageStore is an in-memory message repository and cache for hub tests
+type messageStore struct {
+	mu       sync.Mutex
+	messages map[uuid.UUID]*model.Message
+	seqs     map[uuid.UUID]int64
+	members  *memberRepository
+}
+
+func newMessageStore() *messageStore {
+	return &messageStore{
+		messages: make(map[uuid.UUID]*model.Message),
+		seqs:     make(map[uuid.UUID]int64),
+	}
+}
+
+func (m *messageStore) SaveMessage(ctx context.Context, message *model.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seqs[message.ChatID]++
+	message.Seq = m.seqs[message.ChatID]
+	m.messages[message.ID] = message
+	return nil
+}
+
+func (m *messageStore) GetMessagesAfter(ctx context.Context, chatID uuid.UUID, afterSeq int64, limit int) ([]*model.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var messages []*model.Message
+	for _, msg := range m.messages {
+		if msg.ChatID == chatID && msg.Seq > afterSeq {
+			messages = append(messages, msg)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Seq < messages[j].Seq })
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+func (m *messageStore) GetMessages(ctx context.Context, chatID uuid.UUID, before, after *model.MessageCursor, limit int) ([]*model.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var messages []*model.Message
+	for _, msg := range m.messages {
+		if msg.ChatID == chatID {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+func (m *messageStore) GetMessage(ctx context.Context, messageID uuid.UUID) (*model.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.messages[messageID], nil
+}
+
+func (m *messageStore) GetReplies(ctx context.Context, parentID uuid.UUID, limit int) ([]*model.Message, error) {
+	return nil, nil
+}
+
+func (m *messageStore) GetReplyStats(ctx context.Context, parentIDs []uuid.UUID) ([]*model.ReplyStats, error) {
+	return nil, nil
+}
+
+func (m *messageStore) EditMessage(ctx context.Context, message *model.Message, edit *model.MessageEdit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages[message.ID] = message
+	return nil
+}
+
+func (m *messageStore) AddReaction(ctx context.Context, reaction *model.MessageReaction) (bool, error) {
+	return true, nil
+}
+
+func (m *messageStore) RemoveReaction(ctx context.Context, reaction *model.MessageReaction) (bool, error) {
+	return true, nil
+}
+
+func (m *messageStore) GetReactionCounts(ctx context.Context, messageIDs []uuid.UUID) ([]*model.ReactionCount, error) {
+	return nil, nil
+}
+
+func (m *messageStore) GetMember(ctx context.Context, chatID, userID uuid.UUID) (*model.ChatUser, error) {
+	return m.members.GetMember(ctx, chatID, userID)
+}
+
+func (m *messageStore) DeleteMessage(ctx context.Context, messageID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.messages, messageID)
+	return nil
+}
+
+// noopCache never holds anything, so every read goes to the repository
+type noopCache struct{}
+
+func (noopCache) SetMessage(ctx context.Context, message *model.Message) error { return nil }
+func (noopCache) GetMessage(ctx context.Context, messageID string) (*model.Message, error) {
+	return nil, nil
+}
+func (noopCache) DeleteMessage(ctx context.Context, messageID string) error { return nil }
+func (noopCache) SetChatPage(ctx context.Context, chatID, pageKey string, page *model.MessagePage) error {
+	return nil
+}
+func (noopCache) GetChatPage(ctx context.Context, chatID, pageKey string) (*model.MessagePage, error) {
+	return nil, nil
+}
+func (noopCache) DeleteChatPages(ctx context.Context, chatID string) error { return nil }
+
+type testHub struct {
+	server         *httptest.Server
+	handler        *WebSocketHandler
+	repo           *memberRepository
+	messages       *messageStore
+	chatService    *service.ChatService
+	messageService *service.MessageService
+}
+
+func newTestHub(t *testing.T) *testHub {
+	t.Helper()
+	return newTestNode(t, newMemberRepository(), newMessageStore(), fanout.NewMemoryFanout())
+}
+
+// newTestNode creates a hub sharing storage and fanout with other nodes
+func newTestNode(t *testing.T, repo *memberRepository, messages *messageStore, fo fanout.Fanout) *testHub {
+	t.Helper()
+	repo.messages = messages
+	messages.members = repo
+	chatService := service.NewChatService(repo)
+	messageService := service.NewMessageService(messages, noopCache{})
+	h, err := NewWebSocketHandler(chatService, messageService, fo)
+	if err != nil {
+		t.Fatalf("NewWebSocketHandler failed: %v", err)
+	}
+	chatService.SetMembershipListener(h)
+	chatService.SetReadListener(h)
+	messageService.SetMessageListener(h)
+	server := httptest.NewServer(http.HandlerFunc(h.HandleWebSocket))
+	t.Cleanup(server.Close)
+	return &testHub{
+		server:         server,
+		handler:        h,
+		repo:           repo,
+		messages:       messages,
+		chatService:    chatService,
+		messageService: messageService,
+	}
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return newTestHub(t).server
+}
+
+// dialAs opens an authenticated connection for a user
+func dialAs(t *testing.T, server *httptest.Server, userID uuid.UUID) *websocket.Conn {
+	t.Helper()
+	token, err := middleware.GenerateToken(userID.String())
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(server)+"?token="+token, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// readMessageOfType reads frames until one of the given type arrives
+func readMessageOfType(t *testing.T, conn *websocket.Conn, msgType string) WebSocketMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg WebSocketMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON failed waiting for %s: %v", msgType, err)
+		}
+		if msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+func TestWebSocket_RejectsMissingToken(t *testing.T) {
+	server := newTestServer(t)
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL(server), nil)
+	if err == nil {
+		t.Fatal("Expected handshake to fail without a token")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %v", resp)
+	}
+}
+
+func TestWebSocket_RejectsInvalidToken(t *testing.T) {
+	server := newTestServer(t)
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL(server)+"?token=not-a-jwt", nil)
+	if err == nil {
+		t.Fatal("Expected handshake to fail with an invalid token")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %v", resp)
+	}
+}
+
+func TestWebSocket_IdentityComesFromToken(t *testing.T) {
+	userID := uuid.New().String()
+	token, err := middleware.GenerateToken(userID)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		header http.Header
+		dialer *websocket.Dialer
+	}{
+		{
+			name:   "authorization header",
+			header: http.Header{"Authorization": {"Bearer " + token}},
+			dialer: websocket.DefaultDialer,
+		},
+		{
+			name:   "subprotocol",
+			dialer: &websocket.Dialer{Subprotocols: []string{bearerSubprotocol, token}},
+		},
+		{
+			name:   "query parameter",
+			query:  "?token=" + token,
+			dialer: websocket.DefaultDialer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+			conn, _, err := tt.dialer.Dial(wsURL(server)+tt.query, tt.header)
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer conn.Close()
+
+			// Try to impersonate another user
+			if err := conn.WriteJSON(WebSocketMessage{Type: "user_join", UserID: "someone-else"}); err != nil {
+				t.Fatalf("WriteJSON failed: %v", err)
+			}
+
+			msg := readMessageOfType(t, conn, "user_join")
+			if msg.UserID != userID {
+				t.Errorf("Expected userId '%s', got '%s'", userID, msg.UserID)
+			}
+		})
+	}
+}
+
+func TestWebSocket_ClosesWhenTokenExpires(t *testing.T) {
+	// Sign with a key of our own to issue a token that expires right away
+	secret := []byte("websocket-expiry-test")
+	ks, err := middleware.NewKeySet("test", &middleware.SigningKey{ID: "test", Method: jwt.SigningMethodHS256, Sign: secret, Verify: secret})
+	if err != nil {
+		t.Fatalf("NewKeySet failed: %v", err)
+	}
+	previous := middleware.Keys()
+	middleware.SetKeySet(ks)
+	t.Cleanup(func() { middleware.SetKeySet(previous) })
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &middleware.Claims{
+		UserID: uuid.New().String(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(2 * time.Second)),
+		},
+	})
+	token.Header["kid"] = "test"
+	tokenString, err := token.SignedString(secret)
+	if err != nil {
+		t.Fatalf("SignedString failed: %v", err)
+	}
+
+	server := newTestServer(t)
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(server)+"?token="+tokenString, nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err = conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Expected the connection to close with a policy violation, got %v", err)
+	}
+}
+
+func TestWebSocket_SubscribeRequiresMembership(t *testing.T) {
+	hub := newTestHub(t)
+	chatID := uuid.New()
+	conn := dialAs(t, hub.server, uuid.New())
+
+	conn.WriteJSON(WebSocketMessage{Type: "subscribe", ChatID: chatID.String()})
+
+	msg := readMessageOfType(t, conn, "error")
+	if msg.ChatID != chatID.String() {
+		t.Errorf("Expected error for chat '%s', got '%s'", chatID, msg.ChatID)
+	}
+}
+
+func TestWebSocket_MessagesAreScopedToRooms(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+
+	chatA, chatB := uuid.New(), uuid.New()
+	alice, bob := uuid.New(), uuid.New()
+	hub.repo.AddUserToChat(ctx, chatA, alice)
+	hub.repo.AddUserToChat(ctx, chatA, bob)
+	hub.repo.AddUserToChat(ctx, chatB, bob)
+
+	aliceConn := dialAs(t, hub.server, alice)
+	bobConn := dialAs(t, hub.server, bob)
+
+	aliceConn.WriteJSON(WebSocketMessage{Type: "subscribe", ChatID: chatA.String()})
+	readMessageOfType(t, aliceConn, "subscribed")
+	bobConn.WriteJSON(WebSocketMessage{Type: "subscribe", ChatID: chatB.String()})
+	readMessageOfType(t, bobConn, "subscribed")
+
+	// Bob only listens to chat B, so a message in chat A must not reach him
+	aliceConn.WriteJSON(WebSocketMessage{Type: "message", ChatID: chatA.String(), Text: "only A"})
+	readMessageOfType(t, aliceConn, "message")
+
+	bobConn.WriteJSON(WebSocketMessage{Type: "message", ChatID: chatB.String(), Text: "only B"})
+	msg := readMessageOfType(t, bobConn, "message")
+	if msg.Text != "only B" {
+		t.Errorf("Expected 'only B', got '%s'", msg.Text)
+	}
+	if msg.Sender != bob.String() {
+		t.Errorf("Expected sender '%s', got '%s'", bob, msg.Sender)
+	}
+}
+
+func TestWebSocket_RESTMembershipChangesUpdateRooms(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+
+	chatID := uuid.New()
+	userID := uuid.New()
+	conn := dialAs(t, hub.server, userID)
+
+	if err := hub.chatService.JoinChat(ctx, chatID, userID); err != nil {
+		t.Fatalf("JoinChat failed: %v", err)
+	}
+	msg := readMessageOfType(t, conn, "subscribed")
+	if msg.ChatID != chatID.String() {
+		t.Errorf("Expected subscription to '%s', got '%s'", chatID, msg.ChatID)
+	}
+
+	if err := hub.chatService.LeaveChat(ctx, chatID, userID); err != nil {
+		t.Fatalf("LeaveChat failed: %v", err)
+	}
+	readMessageOfType(t, conn, "unsubscribed")
+
+	conn.WriteJSON(WebSocketMessage{Type: "message", ChatID: chatID.String(), Text: "still here?"})
+	readMessageOfType(t, conn, "error")
+}
+
+func TestWebSocket_MessagesArePersisted(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+
+	chatID, userID := uuid.New(), uuid.New()
+	hub.repo.AddUserToChat(ctx, chatID, userID)
+
+	conn := dialAs(t, hub.server, userID)
+	conn.WriteJSON(WebSocketMessage{Type: "subscribe", ChatID: chatID.String()})
+	readMessageOfType(t, conn, "subscribed")
+
+	conn.WriteJSON(WebSocketMessage{Type: "message", ChatID: chatID.String(), Text: "durable"})
+	msg := readMessageOfType(t, conn, "message")
+
+	messageID, err := uuid.Parse(msg.MessageID)
+	if err != nil {
+		t.Fatalf("Expected a server-assigned message ID, got '%s'", msg.MessageID)
+	}
+	if msg.CreatedAt == nil || msg.CreatedAt.IsZero() {
+		t.Error("Expected a server-assigned timestamp")
+	}
+
+	stored, _ := hub.messages.GetMessage(ctx, messageID)
+	if stored == nil || stored.Text != "durable" {
+		t.Errorf("Expected message to be stored, got %v", stored)
+	}
+}
+
+func TestWebSocket_RESTMessagesAreFannedOut(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+
+	chatID, alice, bob := uuid.New(), uuid.New(), uuid.New()
+	hub.repo.AddUserToChat(ctx, chatID, alice)
+	hub.repo.AddUserToChat(ctx, chatID, bob)
+
+	conn := dialAs(t, hub.server, bob)
+	conn.WriteJSON(WebSocketMessage{Type: "subscribe", ChatID: chatID.String()})
+	readMessageOfType(t, conn, "subscribed")
+
+	// What MessageHandler.Send does for POST /messages
+	sent, err := hub.messageService.SendMessage(ctx, chatID.String(), alice.String(), "from REST", "")
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	msg := readMessageOfType(t, conn, "message")
+	if msg.MessageID != sent.ID.String() {
+		t.Errorf("Expected message ID '%s', got '%s'", sent.ID, msg.MessageID)
+	}
+	if msg.Sender != alice.String() {
+		t.Errorf("Expected sender '%s', got '%s'", alice, msg.Sender)
+	}
+}
+
+func TestWebSocket_NodesShareRoomsAndPresence(t *testing.T) {
+	repo, messages, bus := newMemberRepository(), newMessageStore(), fanout.NewMemoryFanout()
+	nodeA := newTestNode(t, repo, messages, bus)
+	nodeB := newTestNode(t, repo, messages, bus)
+	ctx := context.Background()
+
+	chatID, alice, bob := uuid.New(), uuid.New(), uuid.New()
+	repo.AddUserToChat(ctx, chatID, alice)
+
+	aliceConn := dialAs(t, nodeA.server, alice)
+	aliceConn.WriteJSON(WebSocketMessage{Type: "subscribe", ChatID: chatID.String()})
+	readMessageOfType(t, aliceConn, "subscribed")
+
+	// Bob joins over REST on the other node and is seen by Alice
+	bobConn := dialAs(t, nodeB.server, bob)
+	if err := nodeB.chatService.JoinChat(ctx, chatID, bob); err != nil {
+		t.Fatalf("JoinChat failed: %v", err)
+	}
+	readMessageOfType(t, bobConn, "subscribed")
+	readMessageOfType(t, aliceConn, "member_join")
+
+	bobConn.WriteJSON(WebSocketMessage{Type: "message", ChatID: chatID.String(), Text: "across nodes"})
+	msg := readMessageOfType(t, aliceConn, "message")
+	if msg.Text != "across nodes" {
+		t.Errorf("Expected 'across nodes', got '%s'", msg.Text)
+	}
+
+	aliceConn.WriteJSON(WebSocketMessage{Type: "user_join"})
+	list := readMessageOfType(t, aliceConn, "user_list")
+	if len(list.Users) != 2 {
+		t.Errorf("Expected 2 users online across nodes, got %v", list.Users)
+	}
+}
+
+func TestWebSocket_ResumeReplaysMissedMessages(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+
+	chatID, alice, bob := uuid.New(), uuid.New(), uuid.New()
+	hub.repo.AddUserToChat(ctx, chatID, alice)
+	hub.repo.AddUserToChat(ctx, chatID, bob)
+
+	// Sent while Bob was offline
+	for _, text := range []string{"one", "two", "three"} {
+		if _, err := hub.messageService.SendMessage(ctx, chatID.String(), alice.String(), text, ""); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+	}
+
+	conn := dialAs(t, hub.server, bob)
+	conn.WriteJSON(WebSocketMessage{Type: "resume", Chats: map[string]int64{chatID.String(): 1}})
+
+	for _, want := range []struct {
+		text string
+		seq  int64
+	}{{"two", 2}, {"three", 3}} {
+		msg := readMessageOfType(t, conn, "message")
+		if msg.Text != want.text || msg.Seq != want.seq {
+			t.Errorf("Expected '%s' with seq %d, got '%s' with seq %d", want.text, want.seq, msg.Text, msg.Seq)
+		}
+	}
+
+	resumed := readMessageOfType(t, conn, "resumed")
+	if resumed.Seq != 3 || resumed.More {
+		t.Errorf("Expected resumed at seq 3 with nothing more, got seq %d more %v", resumed.Seq, resumed.More)
+	}
+
+	// Resuming subscribes, so new messages arrive live with the next seq
+	hub.messageService.SendMessage(ctx, chatID.String(), alice.String(), "four", "")
+	msg := readMessageOfType(t, conn, "message")
+	if msg.Seq != 4 {
+		t.Errorf("Expected live message with seq 4, got %d", msg.Seq)
+	}
+}
+
+func TestWebSocket_TypingIndicators(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+
+	chatID, alice, bob := uuid.New(), uuid.New(), uuid.New()
+	hub.repo.AddUserToChat(ctx, chatID, alice)
+	hub.repo.AddUserToChat(ctx, chatID, bob)
+
+	aliceConn := dialAs(t, hub.server, alice)
+	bobConn := dialAs(t, hub.server, bob)
+	for _, conn := range []*websocket.Conn{aliceConn, bobConn} {
+		conn.WriteJSON(WebSocketMessage{Type: "subscribe", ChatID: chatID.String()})
+		readMessageOfType(t, conn, "subscribed")
+	}
+
+	aliceConn.WriteJSON(WebSocketMessage{Type: "typing_start", ChatID: chatID.String()})
+	msg := readMessageOfType(t, bobConn, "typing_start")
+	if msg.UserID != alice.String() {
+		t.Errorf("Expected typing user '%s', got '%s'", alice, msg.UserID)
+	}
+
+	// Sending a message clears the indicator without an explicit typing_stop
+	aliceConn.WriteJSON(WebSocketMessage{Type: "message", ChatID: chatID.String(), Text: "done typing"})
+	msg = readMessageOfType(t, bobConn, "typing_stop")
+	if msg.UserID != alice.String() {
+		t.Errorf("Expected typing_stop for '%s', got '%s'", alice, msg.UserID)
+	}
+}
+
+func TestWebSocket_ReadReceipts(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+
+	chatID, alice, bob := uuid.New(), uuid.New(), uuid.New()
+	hub.repo.AddUserToChat(ctx, chatID, alice)
+	hub.repo.AddUserToChat(ctx, chatID, bob)
+
+	aliceConn := dialAs(t, hub.server, alice)
+	bobConn := dialAs(t, hub.server, bob)
+	for _, conn := range []*websocket.Conn{aliceConn, bobConn} {
+		conn.WriteJSON(WebSocketMessage{Type: "subscribe", ChatID: chatID.String()})
+		readMessageOfType(t, conn, "subscribed")
+	}
+
+	sent, err := hub.messageService.SendMessage(ctx, chatID.String(), alice.String(), "read me", "")
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	bobConn.WriteJSON(WebSocketMessage{Type: "read", ChatID: chatID.String(), MessageID: sent.ID.String()})
+	receipt := readMessageOfType(t, aliceConn, "read")
+	if receipt.UserID != bob.String() || receipt.MessageID != sent.ID.String() || receipt.Seq != sent.Seq {
+		t.Errorf("Unexpected receipt: %+v", receipt)
+	}
+
+	bobConn.WriteJSON(WebSocketMessage{Type: "read", ChatID: chatID.String(), MessageID: uuid.New().String()})
+	if msg := readMessageOfType(t, bobConn, "error"); msg.Error != service.ErrMessageNotInChat.Error() {
+		t.Errorf("Expected %q, got %q", service.ErrMessageNotInChat, msg.Error)
+	}
+}
+
+func TestWebSocket_EditMessage(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+
+	chatID, alice, bob := uuid.New(), uuid.New(), uuid.New()
+	hub.repo.AddUserToChat(ctx, chatID, alice)
+	hub.repo.AddUserToChat(ctx, chatID, bob)
+
+	aliceConn := dialAs(t, hub.server, alice)
+	bobConn := dialAs(t, hub.server, bob)
+	for _, conn := range []*websocket.Conn{aliceConn, bobConn} {
+		conn.WriteJSON(WebSocketMessage{Type: "subscribe", ChatID: chatID.String()})
+		readMessageOfType(t, conn, "subscribed")
+	}
+
+	sent, _ := hub.messageService.SendMessage(ctx, chatID.String(), alice.String(), "typo", "")
+
+	// Bob may not edit Alice's message
+	bobConn.WriteJSON(WebSocketMessage{Type: "edit", MessageID: sent.ID.String(), Text: "hijacked"})
+	readMessageOfType(t, bobConn, "error")
+
+	aliceConn.WriteJSON(WebSocketMessage{Type: "edit", MessageID: sent.ID.String(), Text: "fixed"})
+	msg := readMessageOfType(t, bobConn, "message_edited")
+	if msg.MessageID != sent.ID.String() || msg.Text != "fixed" {
+		t.Errorf("Unexpected edit event: %+v", msg)
+	}
+	if msg.UpdatedAt == nil {
+		t.Error("Expected updatedAt on edit event")
+	}
+}
+
+func TestWebSocket_Reactions(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+
+	chatID, alice, bob := uuid.New(), uuid.New(), uuid.New()
+	hub.repo.AddUserToChat(ctx, chatID, alice)
+	hub.repo.AddUserToChat(ctx, chatID, bob)
+
+	aliceConn := dialAs(t, hub.server, alice)
+	aliceConn.WriteJSON(WebSocketMessage{Type: "subscribe", ChatID: chatID.String()})
+	readMessageOfType(t, aliceConn, "subscribed")
+
+	sent, _ := hub.messageService.SendMessage(ctx, chatID.String(), alice.String(), "ship it", "")
+
+	if err := hub.messageService.AddReaction(ctx, sent.ID.String(), bob.String(), "🚀"); err != nil {
+		t.Fatalf("AddReaction failed: %v", err)
+	}
+	msg := readMessageOfType(t, aliceConn, "reaction_added")
+	if msg.MessageID != sent.ID.String() || msg.UserID != bob.String() || msg.Emoji != "🚀" {
+		t.Errorf("Unexpected reaction event: %+v", msg)
+	}
+
+	if err := hub.messageService.RemoveReaction(ctx, sent.ID.String(), bob.String(), "🚀"); err != nil {
+		t.Fatalf("RemoveReaction failed: %v", err)
+	}
+	msg = readMessageOfType(t, aliceConn, "reaction_removed")
+	if msg.Emoji != "🚀" {
+		t.Errorf("Unexpected reaction event: %+v", msg)
+	}
+}
+
+func TestWebSocket_BlockedUsersAreHeldBack(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+
+	chatID := uuid.New()
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	for _, user := range []uuid.UUID{alice, bob, carol} {
+		hub.repo.AddUserToChat(ctx, chatID, user)
+	}
+
+	aliceConn := dialAs(t, hub.server, alice)
+	bobConn := dialAs(t, hub.server, bob)
+	carolConn := dialAs(t, hub.server, carol)
+	for _, conn := range []*websocket.Conn{aliceConn, bobConn, carolConn} {
+		conn.WriteJSON(WebSocketMessage{Type: "subscribe", ChatID: chatID.String()})
+		readMessageOfType(t, conn, "subscribed")
+	}
+
+	hub.handler.UserBlocked(alice, bob)
+	bobConn.WriteJSON(WebSocketMessage{Type: "message", ChatID: chatID.String(), Text: "hidden"})
+	readMessageOfType(t, bobConn, "message")
+	carolConn.WriteJSON(WebSocketMessage{Type: "message", ChatID: chatID.String(), Text: "visible"})
+	readMessageOfType(t, carolConn, "message")
+
+	// Frames arrive in order, so bob's would have come first
+	visible := readMessageOfType(t, aliceConn, "message")
+	if visible.Text != "visible" {
+		t.Errorf("Expected bob's message to be held back, got '%s'", visible.Text)
+	}
+
+	// So are bob's read receipts and presence
+	bobConn.WriteJSON(WebSocketMessage{Type: "read", ChatID: chatID.String(), MessageID: visible.MessageID})
+	readMessageOfType(t, bobConn, "read")
+	bobConn.WriteJSON(WebSocketMessage{Type: "user_join"})
+	readMessageOfType(t, bobConn, "user_join")
+	carolConn.WriteJSON(WebSocketMessage{Type: "read", ChatID: chatID.String(), MessageID: visible.MessageID})
+	readMessageOfType(t, carolConn, "read")
+	carolConn.WriteJSON(WebSocketMessage{Type: "user_join"})
+	readMessageOfType(t, carolConn, "user_join")
+	if msg := readMessageOfType(t, aliceConn, "read"); msg.UserID != carol.String() {
+		t.Errorf("Expected bob's read receipt to be held back, got one from %s", msg.UserID)
+	}
+	if msg := readMessageOfType(t, aliceConn, "user_join"); msg.UserID != carol.String() {
+		t.Errorf("Expected bob's join to be held back, got one from %s", msg.UserID)
+	}
+
+	hub.handler.UserUnblocked(alice, bob)
+	bobConn.WriteJSON(WebSocketMessage{Type: "message", ChatID: chatID.String(), Text: "shown again"})
+	if msg := readMessageOfType(t, aliceConn, "message"); msg.Text != "shown again" {
+		t.Errorf("Expected bob's message after unblocking, got '%s'", msg.Text)
+	}
+}
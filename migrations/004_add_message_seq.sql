@@ -0,0 +1,14 @@
+-- Per-chat message sequence numbers used for acknowledgements and resume
+ALTER TABLE chats ADD COLUMN last_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN seq BIGINT;
+
+-- Number existing messages in creation order
+WITH numbered AS (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY chat_id ORDER BY created_at, id) AS seq
+    FROM messages
+)
+UPDATE messages SET seq = numbered.seq FROM numbered WHERE messages.id = numbered.id;
+UPDATE chats SET last_seq = COALESCE((SELECT MAX(seq) FROM messages WHERE messages.chat_id = chats.id), 0);
+
+ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_chat_seq ON messages(chat_id, seq);
@@ -0,0 +1,9 @@
+-- Per-user read pointers for read receipts and unread counts
+CREATE TABLE IF NOT EXISTS chat_reads (
+    chat_id UUID REFERENCES chats(id),
+    user_id UUID REFERENCES users(id),
+    last_read_message_id UUID NOT NULL REFERENCES messages(id),
+    last_read_seq BIGINT NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chat_id, user_id)
+);
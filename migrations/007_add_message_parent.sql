@@ -0,0 +1,4 @@
+-- Threaded replies: a reply points at the root message of its thread
+ALTER TABLE messages ADD COLUMN parent_id UUID REFERENCES messages(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_messages_parent_id ON messages(parent_id);
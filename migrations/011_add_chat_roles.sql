@@ -0,0 +1,19 @@
+-- Explicit chat roles and chat creators
+ALTER TABLE chats ADD COLUMN created_by UUID REFERENCES users(id);
+ALTER TABLE chat_users ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'member';
+
+-- The earliest member of an existing chat is taken to be its creator and owner
+WITH first_members AS (
+    SELECT DISTINCT ON (chat_id) chat_id, user_id
+    FROM chat_users
+    ORDER BY chat_id, joined_at, user_id
+)
+UPDATE chat_users SET role = 'owner'
+FROM first_members
+WHERE chat_users.chat_id = first_members.chat_id AND chat_users.user_id = first_members.user_id;
+
+UPDATE chats SET created_by = chat_users.user_id
+FROM chat_users
+WHERE chat_users.chat_id = chats.id AND chat_users.role = 'owner';
+
+CREATE INDEX IF NOT EXISTS idx_chats_created_by ON chats(created_by);
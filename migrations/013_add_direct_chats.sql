@@ -0,0 +1,5 @@
+-- Direct messages: 1:1 chats, at most one per pair of users
+ALTER TABLE chats ADD COLUMN kind VARCHAR(16) NOT NULL DEFAULT 'group';
+ALTER TABLE chats ADD COLUMN direct_key VARCHAR(73);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chats_direct_key ON chats(direct_key);
@@ -0,0 +1,5 @@
+-- Edit history goes with its message. Databases migrated before 006 gained
+-- ON DELETE CASCADE could not delete edited messages.
+ALTER TABLE message_edits DROP CONSTRAINT IF EXISTS message_edits_message_id_fkey;
+ALTER TABLE message_edits ADD CONSTRAINT message_edits_message_id_fkey
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE;
@@ -65,12 +65,12 @@ func TestAuthProvider(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Run migrations
-	err = db.AutoMigrate(&model.User{})
+	err = db.AutoMigrate(&model.User{}, &model.RefreshToken{})
 	assert.NoError(t, err)
 
 	// Initialize repository and service
 	userRepo := repository.NewUserRepository(db)
-	authService := service.NewAuthService(userRepo)
+	authService := service.NewAuthService(userRepo, repository.NewTokenRepository(db), nil)
 
 	// Create test server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -118,7 +118,7 @@ func TestAuthProvider(t *testing.T) {
 				return
 			}
 
-			token, err := authService.Login(r.Context(), req.Username, req.Password)
+			tokens, err := authService.Login(r.Context(), req.Username, req.Password, "")
 			if err != nil {
 				http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 				return
@@ -126,7 +126,7 @@ func TestAuthProvider(t *testing.T) {
 
 			w.WriteHeader(http.StatusOK)
 			json.NewEncoder(w).Encode(map[string]string{
-				"token": token,
+				"token": tokens.AccessToken,
 			})
 		}
 	}))
@@ -74,7 +74,7 @@ func TestWebSocketProvider(t *testing.T) {
 	messageRepo := repository.NewMessageRepository(db)
 
 	// Initialize services
-	authService := service.NewAuthService(userRepo)
+	authService := service.NewAuthService(userRepo, repository.NewTokenRepository(db), nil)
 	messageService := service.NewMessageService(messageRepo, nil)
 
 	// Create test server
@@ -136,7 +136,7 @@ func TestWebSocketProvider(t *testing.T) {
 				}
 
 				// Create message
-				_, err := messageService.SendMessage(r.Context(), payload.ChatID, userID.String(), payload.Content)
+				_, err := messageService.SendMessage(r.Context(), payload.ChatID, userID.String(), payload.Content, "")
 				if err != nil {
 					continue
 				}
@@ -64,7 +64,7 @@ func setupTestServer(t *testing.T) *testServer {
 	require.NoError(t, err)
 
 	// Run migrations
-	err = db.AutoMigrate(&model.User{}, &model.Message{}, &model.Chat{}, &model.ChatUser{})
+	err = db.AutoMigrate(&model.User{}, &model.Message{}, &model.Chat{}, &model.ChatUser{}, &model.ChatRead{}, &model.MessageEdit{}, &model.MessageReaction{}, &model.ChatInvite{}, &model.ChatBan{}, &model.RefreshToken{}, &model.PasswordResetToken{}, &model.UserTOTP{}, &model.RecoveryCode{}, &model.UserBlock{}, &model.DataExport{}, &model.UserIdentity{})
 	require.NoError(t, err)
 
 	// Initialize repositories
@@ -73,7 +73,7 @@ func setupTestServer(t *testing.T) *testServer {
 	chatRepo := repository.NewChatRepository(db)
 
 	// Initialize services
-	authService := service.NewAuthService(userRepo)
+	authService := service.NewAuthService(userRepo, repository.NewTokenRepository(db), nil)
 	messageService := service.NewMessageService(messageRepo, nil)
 	chatService := service.NewChatService(chatRepo)
 
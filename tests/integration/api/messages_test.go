@@ -0,0 +1,185 @@
+package api_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"rtcs/internal/model"
+	"rtcs/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// setupMigratedDB starts PostgreSQL with the schema built by the SQL
+// migrations rather than AutoMigrate, so foreign keys are as in production
+func setupMigratedDB(t *testing.T) *gorm.DB {
+	ctx := context.Background()
+	postgresContainer, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "postgres:15",
+			ExposedPorts: []string{"5432/tcp"},
+			Env: map[string]string{
+				"POSTGRES_USER":     "test",
+				"POSTGRES_PASSWORD": "test",
+				"POSTGRES_DB":       "test",
+			},
+			WaitingFor: wait.ForAll(
+				wait.ForLog("database system is ready to accept connections"),
+				wait.ForListeningPort("5432/tcp"),
+			),
+		},
+		Started: true,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { postgresContainer.Terminate(ctx) })
+
+	host, err := postgresContainer.Host(ctx)
+	require.NoError(t, err)
+	port, err := postgresContainer.MappedPort(ctx, "5432")
+	require.NoError(t, err)
+	dsn := "host=" + host + " port=" + port.Port() + " user=test password=test dbname=test sslmode=disable"
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	files, err := filepath.Glob(filepath.Join("..", "..", "..", "migrations", "*.sql"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	sort.Strings(files)
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		require.NoError(t, err)
+		require.NoError(t, db.Exec(string(migration)).Error, file)
+	}
+	return db
+}
+
+// seedChat creates a user and a chat they are a member of, with n messages
+// from them
+func seedChat(t *testing.T, db *gorm.DB, n int) (*model.User, *model.Chat, []*model.Message) {
+	ctx := context.Background()
+	user := &model.User{Username: uuid.NewString() + "@example.com", Password: "x", EmailVerified: true}
+	require.NoError(t, db.Create(user).Error)
+	chat := &model.Chat{Name: "general", CreatedBy: user.ID}
+	require.NoError(t, db.Create(chat).Error)
+	require.NoError(t, db.Create(&model.ChatUser{ChatID: chat.ID, UserID: user.ID, Role: model.RoleOwner, JoinedAt: time.Now()}).Error)
+
+	messageRepo := repository.NewMessageRepository(db)
+	messages := make([]*model.Message, n)
+	for i := range messages {
+		messages[i] = &model.Message{ChatID: chat.ID, SenderID: user.ID, Text: "hello"}
+		require.NoError(t, messageRepo.SaveMessage(ctx, messages[i]))
+	}
+	return user, chat, messages
+}
+
+func TestDeleteMessage_ReadPointer(t *testing.T) {
+	ctx := context.Background()
+	db := setupMigratedDB(t)
+	messageRepo := repository.NewMessageRepository(db)
+	chatRepo := repository.NewChatRepository(db)
+	user, chat, messages := seedChat(t, db, 3)
+	reader := &model.User{Username: uuid.NewString() + "@example.com", Password: "x", EmailVerified: true}
+	require.NoError(t, db.Create(reader).Error)
+	require.NoError(t, db.Create(&model.ChatUser{ChatID: chat.ID, UserID: reader.ID, Role: model.RoleMember, JoinedAt: time.Now()}).Error)
+
+	// Both users have read up to the newest message
+	for _, userID := range []uuid.UUID{user.ID, reader.ID} {
+		moved, err := chatRepo.MarkRead(ctx, &model.ChatRead{ChatID: chat.ID, UserID: userID, LastReadMessageID: messages[2].ID, LastReadSeq: messages[2].Seq})
+		require.NoError(t, err)
+		require.True(t, moved)
+	}
+
+	require.NoError(t, messageRepo.DeleteMessage(ctx, messages[2].ID))
+
+	var reads []model.ChatRead
+	require.NoError(t, db.Find(&reads, "chat_id = ?", chat.ID).Error)
+	require.Len(t, reads, 2)
+	for _, read := range reads {
+		assert.Equal(t, messages[1].ID, read.LastReadMessageID, "the pointer moves to the previous message")
+		assert.Equal(t, messages[2].Seq, read.LastReadSeq, "what was read stays read")
+	}
+	counts, err := chatRepo.UnreadCounts(ctx, reader.ID, nil)
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+
+	// Without an earlier message the pointer goes
+	require.NoError(t, messageRepo.DeleteMessage(ctx, messages[1].ID))
+	require.NoError(t, messageRepo.DeleteMessage(ctx, messages[0].ID))
+	require.NoError(t, db.Find(&reads, "chat_id = ?", chat.ID).Error)
+	assert.Empty(t, reads)
+}
+
+func TestDeleteMessage_Edited(t *testing.T) {
+	ctx := context.Background()
+	db := setupMigratedDB(t)
+	messageRepo := repository.NewMessageRepository(db)
+	_, _, messages := seedChat(t, db, 1)
+
+	message := messages[0]
+	edit := &model.MessageEdit{MessageID: message.ID, Text: message.Text, EditedAt: time.Now()}
+	message.Text = "hello, edited"
+	message.UpdatedAt = edit.EditedAt
+	require.NoError(t, messageRepo.EditMessage(ctx, message, edit))
+
+	require.NoError(t, messageRepo.DeleteMessage(ctx, message.ID))
+
+	var count int64
+	require.NoError(t, db.Model(&model.MessageEdit{}).Where("message_id = ?", message.ID).Count(&count).Error)
+	assert.Zero(t, count, "the edit history is deleted with the message")
+	var remaining int64
+	require.NoError(t, db.Model(&model.Message{}).Where("id = ?", message.ID).Count(&remaining).Error)
+	assert.Zero(t, remaining)
+}
+
+func TestDeleteAccount_DeletedMessagesLeaveHistory(t *testing.T) {
+	ctx := context.Background()
+	db := setupMigratedDB(t)
+	messageRepo := repository.NewMessageRepository(db)
+	accountRepo := repository.NewAccountRepository(db)
+	user, chat, messages := seedChat(t, db, 2)
+	other := &model.User{Username: uuid.NewString() + "@example.com", Password: "x", EmailVerified: true}
+	require.NoError(t, db.Create(other).Error)
+	require.NoError(t, db.Create(&model.ChatUser{ChatID: chat.ID, UserID: other.ID, Role: model.RoleMember, JoinedAt: time.Now()}).Error)
+	reply := &model.Message{ChatID: chat.ID, SenderID: other.ID, Text: "hi", ParentID: &messages[0].ID}
+	require.NoError(t, messageRepo.SaveMessage(ctx, reply))
+	ownReply := &model.Message{ChatID: chat.ID, SenderID: user.ID, Text: "hi again", ParentID: &messages[0].ID}
+	require.NoError(t, messageRepo.SaveMessage(ctx, ownReply))
+
+	deletion, err := accountRepo.DeleteAccount(ctx, user.ID, true)
+	require.NoError(t, err)
+	require.NotNil(t, deletion)
+
+	history, err := messageRepo.GetMessages(ctx, chat.ID, nil, nil, 50)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, reply.ID, history[0].ID)
+
+	replayed, err := messageRepo.GetMessagesAfter(ctx, chat.ID, 0, 50)
+	require.NoError(t, err)
+	require.Len(t, replayed, 1)
+	assert.Equal(t, reply.ID, replayed[0].ID)
+
+	replies, err := messageRepo.GetReplies(ctx, messages[0].ID, 50)
+	require.NoError(t, err)
+	require.Len(t, replies, 1)
+	assert.Equal(t, reply.ID, replies[0].ID)
+	stats, err := messageRepo.GetReplyStats(ctx, []uuid.UUID{messages[0].ID})
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	assert.EqualValues(t, 1, stats[0].Count)
+}
@@ -0,0 +1,306 @@
+package service_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"rtcs/internal/cache"
+	"rtcs/internal/middleware"
+	"rtcs/internal/model"
+	"rtcs/internal/service"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryAccountRepository hands out a fixed deletion result and keeps
+// exports in memory
+type memoryAccountRepository struct {
+	mu             sync.Mutex
+	deletion       *model.AccountDeletion
+	deleteMessages *bool // Policy flag of the last DeleteAccount call
+	memberships    []*model.ChatUser
+	messages       []*model.Message // Oldest first
+	exports        []*model.DataExport
+}
+
+func (m *memoryAccountRepository) DeleteAccount(ctx context.Context, userID uuid.UUID, deleteMessages bool) (*model.AccountDeletion, error) {
+	m.deleteMessages = &deleteMessages
+	return m.deletion, nil
+}
+
+func (m *memoryAccountRepository) ListMemberships(ctx context.Context, userID uuid.UUID) ([]*model.ChatUser, error) {
+	return m.memberships, nil
+}
+
+func (m *memoryAccountRepository) ListUserMessages(ctx context.Context, userID uuid.UUID, after *model.MessageCursor, limit int) ([]*model.Message, error) {
+	var page []*model.Message
+	for _, message := range m.messages {
+		if after != nil && !message.CreatedAt.After(after.CreatedAt) {
+			continue
+		}
+		if len(page) == limit {
+			break
+		}
+		page = append(page, message)
+	}
+	return page, nil
+}
+
+func (m *memoryAccountRepository) CreateExport(ctx context.Context, export *model.DataExport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.exports = append(m.exports, export)
+	return nil
+}
+
+func (m *memoryAccountRepository) GetLatestExport(ctx context.Context, userID uuid.UUID) (*model.DataExport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.exports) - 1; i >= 0; i-- {
+		if m.exports[i].UserID == userID {
+			copied := *m.exports[i]
+			copied.Archive = nil
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryAccountRepository) GetExportArchive(ctx context.Context, exportID uuid.UUID) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, export := range m.exports {
+		if export.ID == exportID && export.Status == model.ExportReady {
+			return export.Archive, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryAccountRepository) ClaimExport(ctx context.Context, staleBefore time.Time) (*model.DataExport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, export := range m.exports {
+		if export.Status == model.ExportPending {
+			now := time.Now()
+			export.Status = model.ExportRunning
+			export.StartedAt = &now
+			copied := *export
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryAccountRepository) CompleteExport(ctx context.Context, exportID uuid.UUID, archive []byte, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, export := range m.exports {
+		if export.ID == exportID {
+			export.Status = model.ExportReady
+			export.Archive = archive
+			export.ExpiresAt = &expiresAt
+		}
+	}
+	return nil
+}
+
+func (m *memoryAccountRepository) FailExport(ctx context.Context, exportID uuid.UUID, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, export := range m.exports {
+		if export.ID == exportID {
+			export.Status = model.ExportFailed
+			export.Error = reason
+		}
+	}
+	return nil
+}
+
+func (m *memoryAccountRepository) DeleteExpiredExports(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+// recordingMessageCache records which entries were purged
+type recordingMessageCache struct {
+	deletedPages    []string
+	deletedMessages []string
+}
+
+func (c *recordingMessageCache) SetMessage(ctx context.Context, message *model.Message) error {
+	return nil
+}
+
+func (c *recordingMessageCache) GetMessage(ctx context.Context, messageID string) (*model.Message, error) {
+	return nil, nil
+}
+
+func (c *recordingMessageCache) DeleteMessage(ctx context.Context, messageID string) error {
+	c.deletedMessages = append(c.deletedMessages, messageID)
+	return nil
+}
+
+func (c *recordingMessageCache) SetChatPage(ctx context.Context, chatID, pageKey string, page *model.MessagePage) error {
+	return nil
+}
+
+func (c *recordingMessageCache) GetChatPage(ctx context.Context, chatID, pageKey string) (*model.MessagePage, error) {
+	return nil, nil
+}
+
+func (c *recordingMessageCache) DeleteChatPages(ctx context.Context, chatID string) error {
+	c.deletedPages = append(c.deletedPages, chatID)
+	return nil
+}
+
+// recordingChatListener records the chats users left
+type recordingChatListener struct {
+	left []uuid.UUID
+}
+
+func (l *recordingChatListener) ChatJoined(chatID, userID uuid.UUID) {}
+
+func (l *recordingChatListener) ChatLeft(chatID, userID uuid.UUID) {
+	l.left = append(l.left, chatID)
+}
+
+func TestAccountService_DeleteAccount(t *testing.T) {
+	ctx := context.Background()
+	user, mockRepo := userWithPassword(t, "old-password1")
+	chatID, messageID, family := uuid.New(), uuid.New(), uuid.New()
+	accounts := &memoryAccountRepository{deletion: &model.AccountDeletion{
+		Username:       user.Username,
+		ChatIDs:        []uuid.UUID{chatID},
+		LeftChatIDs:    []uuid.UUID{chatID},
+		MessageIDs:     []uuid.UUID{messageID},
+		TokenFamilyIDs: []uuid.UUID{family},
+	}}
+	messageCache := &recordingMessageCache{}
+	denylist := &memoryDenylist{revoked: make(map[string]time.Time)}
+	limiter := newMemoryLoginLimiter(cache.LoginPolicy{FreeAttempts: 3, LockoutThreshold: 10, BaseDelay: time.Second})
+	listener := &recordingChatListener{}
+	accountService := service.NewAccountService(mockRepo, accounts, messageCache, denylist)
+	accountService.SetLoginLimiter(limiter)
+	accountService.SetMembershipListener(listener)
+
+	err := accountService.DeleteAccount(ctx, user.ID, nil, "wrong-password1")
+	assert.ErrorIs(t, err, service.ErrWrongPassword)
+	assert.Nil(t, accounts.deleteMessages, "nothing is deleted without the password")
+
+	limiter.RecordFailure(ctx, user.Username, "")
+	claims := &middleware.Claims{RegisteredClaims: jwt.RegisteredClaims{
+		ID:        "legacy-token",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}}
+	require.NoError(t, accountService.DeleteAccount(ctx, user.ID, claims, "old-password1"))
+
+	require.NotNil(t, accounts.deleteMessages)
+	assert.False(t, *accounts.deleteMessages, "messages are anonymized by default")
+	assert.Contains(t, denylist.revoked, family.String())
+	assert.Contains(t, denylist.revoked, "legacy-token")
+	assert.Equal(t, []string{chatID.String()}, messageCache.deletedPages)
+	assert.Equal(t, []string{messageID.String()}, messageCache.deletedMessages)
+	assert.Empty(t, limiter.failures)
+	assert.Equal(t, []uuid.UUID{chatID}, listener.left)
+}
+
+func TestAccountService_DeleteAccountWithDeletePolicy(t *testing.T) {
+	ctx := context.Background()
+	user, mockRepo := userWithPassword(t, "old-password1")
+	accounts := &memoryAccountRepository{deletion: &model.AccountDeletion{Username: user.Username}}
+	accountService := service.NewAccountService(mockRepo, accounts, &recordingMessageCache{}, nil)
+	accountService.SetMessagePolicy(service.DeleteMessages)
+
+	require.NoError(t, accountService.DeleteAccount(ctx, user.ID, nil, "old-password1"))
+	require.NotNil(t, accounts.deleteMessages)
+	assert.True(t, *accounts.deleteMessages)
+}
+
+func TestAccountService_DeleteAccountAlreadyDeleted(t *testing.T) {
+	deletedAt := time.Now()
+	user := &model.User{ID: uuid.New(), Username: model.DeletedUsername(uuid.Nil), DeletedAt: &deletedAt}
+	mockRepo := &MockUserRepository{}
+	mockRepo.On("GetByID", mock.Anything, user.ID).Return(user, nil)
+	accountService := service.NewAccountService(mockRepo, &memoryAccountRepository{}, &recordingMessageCache{}, nil)
+
+	err := accountService.DeleteAccount(context.Background(), user.ID, nil, "")
+	assert.ErrorIs(t, err, service.ErrUserNotFound)
+}
+
+func TestAccountService_Export(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	user := &model.User{ID: uuid.New(), Username: "test@example.com", Password: "hash", DisplayName: "Test"}
+	mockRepo := &MockUserRepository{}
+	mockRepo.On("GetByID", mock.Anything, user.ID).Return(user, nil)
+	chat := &model.Chat{ID: uuid.New(), Name: "general", Kind: model.KindGroup}
+	accounts := &memoryAccountRepository{
+		memberships: []*model.ChatUser{{ChatID: chat.ID, UserID: user.ID, Role: model.RoleMember, Chat: chat}},
+	}
+	// More than one batch, so that paging through them is covered
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 501; i++ {
+		accounts.messages = append(accounts.messages, &model.Message{
+			ID:        uuid.New(),
+			ChatID:    chat.ID,
+			SenderID:  user.ID,
+			Text:      "hello",
+			CreatedAt: start.Add(time.Duration(i) * time.Millisecond),
+		})
+	}
+	accountService := service.NewAccountService(mockRepo, accounts, &recordingMessageCache{}, nil)
+
+	_, _, err := accountService.ExportArchive(ctx, user.ID)
+	assert.ErrorIs(t, err, service.ErrExportNotFound)
+
+	export, err := accountService.RequestExport(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.ExportPending, export.Status)
+	again, err := accountService.RequestExport(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, export.ID, again.ID, "an export already queued is reused")
+	_, _, err = accountService.ExportArchive(ctx, user.ID)
+	assert.ErrorIs(t, err, service.ErrExportNotReady)
+
+	go accountService.RunExports(ctx)
+	var archive []byte
+	require.Eventually(t, func() bool {
+		_, archive, err = accountService.ExportArchive(ctx, user.ID)
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	require.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		require.NoError(t, err)
+		files[file.Name], err = io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+	}
+
+	var profile map[string]interface{}
+	require.NoError(t, json.Unmarshal(files["profile.json"], &profile))
+	assert.Equal(t, "Test", profile["display_name"])
+	assert.NotContains(t, profile, "password")
+
+	var memberships []map[string]interface{}
+	require.NoError(t, json.Unmarshal(files["memberships.json"], &memberships))
+	require.Len(t, memberships, 1)
+	assert.Equal(t, "general", memberships[0]["chat_name"])
+
+	var messages []*model.Message
+	require.NoError(t, json.Unmarshal(files["messages.json"], &messages))
+	assert.Len(t, messages, 501)
+}
@@ -1,15 +1,28 @@
 package service_test
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"rtcs/internal/cache"
+	"rtcs/internal/middleware"
 	"rtcs/internal/model"
+	"rtcs/internal/notify"
 	"rtcs/internal/service"
+	"rtcs/internal/totp"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // MockUserRepository is a mock implementation of the UserRepository interface
@@ -38,6 +51,281 @@ func (m *MockUserRepository) GetByUsername(ctx context.Context, username string)
 	return args.Get(0).(*model.User), args.Error(1)
 }
 
+func (m *MockUserRepository) UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error {
+	args := m.Called(ctx, id, passwordHash)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) MarkEmailVerified(ctx context.Context, id uuid.UUID) (bool, error) {
+	args := m.Called(ctx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) UpdateProfile(ctx context.Context, user *model.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) Search(ctx context.Context, prefix string, limit int) ([]*model.User, error) {
+	args := m.Called(ctx, prefix, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.User), args.Error(1)
+}
+
+func (m *MockUserRepository) GetProfiles(ctx context.Context, ids []uuid.UUID) ([]*model.UserProfile, error) {
+	args := m.Called(ctx, ids)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.UserProfile), args.Error(1)
+}
+
+func (m *MockUserRepository) Block(ctx context.Context, block *model.UserBlock) (bool, error) {
+	args := m.Called(ctx, block)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) Unblock(ctx context.Context, blockerID, blockedID uuid.UUID) (bool, error) {
+	args := m.Called(ctx, blockerID, blockedID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) ListBlocks(ctx context.Context, blockerID uuid.UUID) ([]*model.UserBlock, error) {
+	args := m.Called(ctx, blockerID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.UserBlock), args.Error(1)
+}
+
+func (m *MockUserRepository) IsBlockedEitherWay(ctx context.Context, a, b uuid.UUID) (bool, error) {
+	args := m.Called(ctx, a, b)
+	return args.Bool(0), args.Error(1)
+}
+
+// memoryTokenRepository is an in-memory refresh and reset token store
+type memoryTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[string]*model.RefreshToken
+	resets map[string]*model.PasswordResetToken
+}
+
+func newMemoryTokenRepository() *memoryTokenRepository {
+	return &memoryTokenRepository{
+		tokens: make(map[string]*model.RefreshToken),
+		resets: make(map[string]*model.PasswordResetToken),
+	}
+}
+
+func (m *memoryTokenRepository) CreateRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (m *memoryTokenRepository) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if token, ok := m.tokens[tokenHash]; ok {
+		copied := *token
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (m *memoryTokenRepository) RotateRefreshToken(ctx context.Context, used, next *model.RefreshToken) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := m.tokens[used.TokenHash]
+	if stored == nil || stored.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	stored.RevokedAt = &now
+	stored.ReplacedBy = &next.ID
+	m.tokens[next.TokenHash] = next
+	return true, nil
+}
+
+func (m *memoryTokenRepository) RevokeTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, token := range m.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *memoryTokenRepository) RevokeUserTokens(ctx context.Context, userID, keepFamilyID uuid.UUID) ([]uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	revoked := make(map[uuid.UUID]bool)
+	for _, token := range m.tokens {
+		if token.UserID == userID && token.FamilyID != keepFamilyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			revoked[token.FamilyID] = true
+		}
+	}
+	families := make([]uuid.UUID, 0, len(revoked))
+	for family := range revoked {
+		families = append(families, family)
+	}
+	return families, nil
+}
+
+func (m *memoryTokenRepository) CreatePasswordReset(ctx context.Context, reset *model.PasswordResetToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resets[reset.TokenHash] = reset
+	return nil
+}
+
+func (m *memoryTokenRepository) UsePasswordReset(ctx context.Context, tokenHash string) (*model.PasswordResetToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reset := m.resets[tokenHash]
+	if reset == nil || reset.UsedAt != nil || !reset.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	now := time.Now()
+	for _, other := range m.resets {
+		if other.UserID == reset.UserID && other.UsedAt == nil {
+			other.UsedAt = &now
+		}
+	}
+	copied := *reset
+	return &copied, nil
+}
+
+// memoryMFARepository is an in-memory 2FA store
+type memoryMFARepository struct {
+	totps map[uuid.UUID]*model.UserTOTP
+	codes map[string]*model.RecoveryCode
+}
+
+func newMemoryMFARepository() *memoryMFARepository {
+	return &memoryMFARepository{totps: make(map[uuid.UUID]*model.UserTOTP), codes: make(map[string]*model.RecoveryCode)}
+}
+
+func (m *memoryMFARepository) GetTOTP(ctx context.Context, userID uuid.UUID) (*model.UserTOTP, error) {
+	if enrollment, ok := m.totps[userID]; ok {
+		copied := *enrollment
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (m *memoryMFARepository) SaveTOTP(ctx context.Context, enrollment *model.UserTOTP) (bool, error) {
+	if existing, ok := m.totps[enrollment.UserID]; ok && existing.ConfirmedAt != nil {
+		return false, nil
+	}
+	m.totps[enrollment.UserID] = enrollment
+	return true, nil
+}
+
+func (m *memoryMFARepository) ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64, codes []*model.RecoveryCode) (bool, error) {
+	enrollment, ok := m.totps[userID]
+	if !ok || enrollment.ConfirmedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	enrollment.ConfirmedAt = &now
+	enrollment.LastUsedStep = step
+	for _, code := range codes {
+		m.codes[code.CodeHash] = code
+	}
+	return true, nil
+}
+
+func (m *memoryMFARepository) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	enrollment, ok := m.totps[userID]
+	if !ok || enrollment.ConfirmedAt == nil || enrollment.LastUsedStep >= step {
+		return false, nil
+	}
+	enrollment.LastUsedStep = step
+	return true, nil
+}
+
+func (m *memoryMFARepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	code, ok := m.codes[codeHash]
+	if !ok || code.UserID != userID || code.UsedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	code.UsedAt = &now
+	return true, nil
+}
+
+func (m *memoryMFARepository) DeleteTOTP(ctx context.Context, userID uuid.UUID) error {
+	delete(m.totps, userID)
+	for hash, code := range m.codes {
+		if code.UserID == userID {
+			delete(m.codes, hash)
+		}
+	}
+	return nil
+}
+
+// memoryDenylist is an in-memory access token denylist
+type memoryDenylist struct {
+	revoked map[string]time.Time
+}
+
+func (d *memoryDenylist) Revoke(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	d.revoked[tokenID] = expiresAt
+	return nil
+}
+
+func (d *memoryDenylist) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	_, ok := d.revoked[tokenID]
+	return ok, nil
+}
+
+// memoryLoginLimiter applies a login policy per username, ignoring IPs
+type memoryLoginLimiter struct {
+	policy   cache.LoginPolicy
+	failures map[string]int
+	locked   map[string]time.Time
+}
+
+func newMemoryLoginLimiter(policy cache.LoginPolicy) *memoryLoginLimiter {
+	return &memoryLoginLimiter{policy: policy, failures: make(map[string]int), locked: make(map[string]time.Time)}
+}
+
+func (l *memoryLoginLimiter) Check(ctx context.Context, username, ip string) (time.Duration, string, error) {
+	if until, ok := l.locked[username]; ok && time.Now().Before(until) {
+		return time.Until(until), "username", nil
+	}
+	return 0, "", nil
+}
+
+func (l *memoryLoginLimiter) RecordFailure(ctx context.Context, username, ip string) error {
+	l.failures[username]++
+	if lock := l.policy.LockFor(l.failures[username]); lock > 0 {
+		l.locked[username] = time.Now().Add(lock)
+	}
+	return nil
+}
+
+func (l *memoryLoginLimiter) RecordSuccess(ctx context.Context, username, ip string) error {
+	delete(l.failures, username)
+	delete(l.locked, username)
+	return nil
+}
+
+func hashPassword(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+	return string(hash)
+}
+
 func TestAuthService_Register(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -78,7 +366,7 @@ func TestAuthService_Register(t *testing.T) {
 			tt.mockSetup(mockRepo)
 
 			// Create auth service with mock repository
-			authService := service.NewAuthService(mockRepo)
+			authService := service.NewAuthService(mockRepo, newMemoryTokenRepository(), nil)
 
 			// Test registration
 			_, err := authService.Register(context.Background(), tt.username, tt.password)
@@ -111,7 +399,7 @@ func TestAuthService_Login(t *testing.T) {
 			mockSetup: func(m *MockUserRepository) {
 				m.On("GetByUsername", mock.Anything, "test@example.com").Return(&model.User{
 					Username: "test@example.com",
-					Password: "$2a$10$abcdefghijklmnopqrstuvwxyz", // Mocked hashed password
+					Password: hashPassword(t, "password123"),
 				}, nil)
 			},
 			expectedError: false,
@@ -134,18 +422,19 @@ func TestAuthService_Login(t *testing.T) {
 			tt.mockSetup(mockRepo)
 
 			// Create auth service with mock repository
-			authService := service.NewAuthService(mockRepo)
+			authService := service.NewAuthService(mockRepo, newMemoryTokenRepository(), nil)
 
 			// Test login
-			token, err := authService.Login(context.Background(), tt.username, tt.password)
+			tokens, err := authService.Login(context.Background(), tt.username, tt.password, "")
 
 			// Assert results
 			if tt.expectedError {
 				assert.Error(t, err)
-				assert.Empty(t, token)
+				assert.Nil(t, tokens)
 			} else {
-				assert.NoError(t, err)
-				assert.NotEmpty(t, token)
+				require.NoError(t, err)
+				assert.NotEmpty(t, tokens.AccessToken)
+				assert.NotEmpty(t, tokens.RefreshToken)
 			}
 
 			// Verify mock expectations
@@ -153,3 +442,329 @@ func TestAuthService_Login(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthService_RefreshAndLogout(t *testing.T) {
+	ctx := context.Background()
+	user := &model.User{ID: uuid.New(), Username: "test@example.com", Password: hashPassword(t, "password123")}
+	mockRepo := &MockUserRepository{}
+	mockRepo.On("GetByUsername", mock.Anything, user.Username).Return(user, nil)
+	mockRepo.On("GetByID", mock.Anything, user.ID).Return(user, nil)
+	tokenRepo := newMemoryTokenRepository()
+	denylist := &memoryDenylist{revoked: make(map[string]time.Time)}
+	authService := service.NewAuthService(mockRepo, tokenRepo, denylist)
+
+	login, err := authService.Login(ctx, user.Username, "password123", "")
+	require.NoError(t, err)
+
+	t.Run("refresh rotates the refresh token", func(t *testing.T) {
+		refreshed, err := authService.Refresh(ctx, login.RefreshToken)
+		require.NoError(t, err)
+		assert.NotEqual(t, login.RefreshToken, refreshed.RefreshToken)
+		assert.NotEmpty(t, refreshed.AccessToken)
+
+		// Replaying the used token revokes the whole family, including the
+		// token that replaced it and the access tokens issued with them
+		_, err = authService.Refresh(ctx, login.RefreshToken)
+		assert.ErrorIs(t, err, service.ErrRefreshTokenReused)
+		_, err = authService.Refresh(ctx, refreshed.RefreshToken)
+		assert.ErrorIs(t, err, service.ErrRefreshTokenReused)
+		_, err = authService.ValidateToken(ctx, refreshed.AccessToken)
+		assert.ErrorIs(t, err, middleware.ErrTokenRevoked)
+		_, err = authService.ValidateToken(ctx, login.AccessToken)
+		assert.ErrorIs(t, err, middleware.ErrTokenRevoked)
+	})
+
+	t.Run("unknown refresh token", func(t *testing.T) {
+		_, err := authService.Refresh(ctx, "not-a-token")
+		assert.ErrorIs(t, err, service.ErrInvalidRefreshToken)
+	})
+
+	t.Run("logout revokes the access token and session", func(t *testing.T) {
+		session, err := authService.Login(ctx, user.Username, "password123", "")
+		require.NoError(t, err)
+		claims, err := middleware.ValidateToken(session.AccessToken)
+		require.NoError(t, err)
+
+		refreshed, err := authService.Refresh(ctx, session.RefreshToken)
+		require.NoError(t, err)
+
+		require.NoError(t, authService.Logout(ctx, user.ID, claims, refreshed.RefreshToken))
+		assert.Contains(t, denylist.revoked, claims.ID)
+		_, err = authService.Refresh(ctx, refreshed.RefreshToken)
+		assert.Error(t, err)
+		// Other access tokens of the session stop working too
+		_, err = authService.ValidateToken(ctx, refreshed.AccessToken)
+		assert.ErrorIs(t, err, middleware.ErrTokenRevoked)
+	})
+
+	t.Run("logout refuses another user's refresh token", func(t *testing.T) {
+		session, err := authService.Login(ctx, user.Username, "password123", "")
+		require.NoError(t, err)
+		err = authService.Logout(ctx, uuid.New(), nil, session.RefreshToken)
+		assert.ErrorIs(t, err, service.ErrInvalidRefreshToken)
+	})
+}
+
+func TestAuthService_LoginLockout(t *testing.T) {
+	ctx := context.Background()
+	user := &model.User{ID: uuid.New(), Username: "test@example.com", Password: hashPassword(t, "password123")}
+	mockRepo := &MockUserRepository{}
+	mockRepo.On("GetByUsername", mock.Anything, user.Username).Return(user, nil)
+	limiter := newMemoryLoginLimiter(cache.LoginPolicy{
+		FreeAttempts:     2,
+		LockoutThreshold: 4,
+		BaseDelay:        time.Minute,
+		LockoutDuration:  time.Hour,
+		Window:           time.Hour,
+	})
+	authService := service.NewAuthService(mockRepo, newMemoryTokenRepository(), nil)
+	authService.SetLoginLimiter(limiter)
+
+	// Failures within the free attempts are not delayed
+	_, err := authService.Login(ctx, user.Username, "wrong", "192.0.2.1")
+	assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+	_, err = authService.Login(ctx, user.Username, "wrong", "192.0.2.1")
+	assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+	assert.Empty(t, limiter.locked, "exactly the free attempts leave the account open")
+	_, err = authService.Login(ctx, user.Username, "wrong", "192.0.2.1")
+	assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+
+	// Once locked, even the right password is refused
+	_, err = authService.Login(ctx, user.Username, "password123", "192.0.2.1")
+	var throttled *service.LoginThrottledError
+	require.ErrorAs(t, err, &throttled)
+	assert.ErrorIs(t, err, service.ErrTooManyAttempts)
+	assert.InDelta(t, time.Minute.Seconds(), throttled.RetryAfter.Seconds(), 1)
+
+	delete(limiter.locked, user.Username)
+	tokens, err := authService.Login(ctx, user.Username, "password123", "192.0.2.1")
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.Empty(t, limiter.failures, "a successful login clears the failures")
+}
+
+func TestLoginPolicy_LockFor(t *testing.T) {
+	policy := cache.LoginPolicy{FreeAttempts: 3, LockoutThreshold: 10, BaseDelay: time.Second, LockoutDuration: 15 * time.Minute}
+
+	assert.Zero(t, policy.LockFor(2))
+	assert.Zero(t, policy.LockFor(3), "the free attempts are not delayed")
+	assert.Equal(t, time.Second, policy.LockFor(4))
+	assert.Equal(t, 2*time.Second, policy.LockFor(5))
+	assert.Equal(t, 32*time.Second, policy.LockFor(9))
+	assert.Equal(t, 15*time.Minute, policy.LockFor(10))
+}
+
+// userWithPassword mocks a user whose password can be changed
+func userWithPassword(t *testing.T, password string) (*model.User, *MockUserRepository) {
+	user := &model.User{ID: uuid.New(), Username: "test@example.com", Password: hashPassword(t, password)}
+	mockRepo := &MockUserRepository{}
+	mockRepo.On("GetByUsername", mock.Anything, user.Username).Return(user, nil)
+	mockRepo.On("GetByID", mock.Anything, user.ID).Return(user, nil)
+	mockRepo.On("UpdatePassword", mock.Anything, user.ID, mock.Anything).
+		Run(func(args mock.Arguments) { user.Password = args.String(2) }).
+		Return(nil)
+	return user, mockRepo
+}
+
+// readOutbox returns the messages written to a file outbox
+func readOutbox(t *testing.T, path string) []notify.Message {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	defer f.Close()
+
+	var messages []notify.Message
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var msg notify.Message
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &msg))
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// resetToken picks the token out of a password reset message
+func resetToken(msg notify.Message) string {
+	_, rest, _ := strings.Cut(msg.Body, "token: ")
+	token, _, _ := strings.Cut(rest, "\n")
+	return token
+}
+
+func TestAuthService_ChangePassword(t *testing.T) {
+	ctx := context.Background()
+	user, mockRepo := userWithPassword(t, "password123")
+	tokenRepo := newMemoryTokenRepository()
+	denylist := &memoryDenylist{revoked: make(map[string]time.Time)}
+	authService := service.NewAuthService(mockRepo, tokenRepo, denylist)
+
+	current, err := authService.Login(ctx, user.Username, "password123", "")
+	require.NoError(t, err)
+	other, err := authService.Login(ctx, user.Username, "password123", "")
+	require.NoError(t, err)
+	claims, err := middleware.ValidateToken(current.AccessToken)
+	require.NoError(t, err)
+	otherClaims, err := middleware.ValidateToken(other.AccessToken)
+	require.NoError(t, err)
+
+	err = authService.ChangePassword(ctx, user.ID, claims.SessionID, "wrong", "new-password1")
+	assert.ErrorIs(t, err, service.ErrWrongPassword)
+	err = authService.ChangePassword(ctx, user.ID, claims.SessionID, "password123", "short")
+	assert.ErrorIs(t, err, service.ErrWeakPassword)
+
+	require.NoError(t, authService.ChangePassword(ctx, user.ID, claims.SessionID, "password123", "new-password1"))
+
+	// Only the new password works from now on
+	_, err = authService.Login(ctx, user.Username, "password123", "")
+	assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+	_, err = authService.Login(ctx, user.Username, "new-password1", "")
+	assert.NoError(t, err)
+
+	// The caller's session survives, the other one is gone
+	_, err = authService.Refresh(ctx, current.RefreshToken)
+	assert.NoError(t, err)
+	_, err = authService.Refresh(ctx, other.RefreshToken)
+	assert.Error(t, err)
+	_, err = authService.ValidateToken(ctx, other.AccessToken)
+	assert.ErrorIs(t, err, middleware.ErrTokenRevoked)
+	assert.Contains(t, denylist.revoked, otherClaims.SessionID)
+	assert.NotContains(t, denylist.revoked, claims.SessionID)
+}
+
+func TestAuthService_PasswordReset(t *testing.T) {
+	ctx := context.Background()
+	user, mockRepo := userWithPassword(t, "password123")
+	mockRepo.On("GetByUsername", mock.Anything, "nobody@example.com").Return(nil, nil)
+	tokenRepo := newMemoryTokenRepository()
+	limiter := newMemoryLoginLimiter(cache.DefaultUsernamePolicy)
+	outbox := filepath.Join(t.TempDir(), "outbox.jsonl")
+	authService := service.NewAuthService(mockRepo, tokenRepo, &memoryDenylist{revoked: make(map[string]time.Time)})
+	authService.SetNotifier(notify.NewFileOutbox(outbox))
+	authService.SetLoginLimiter(limiter)
+	authService.SetPublicURL("https://chat.example.com/")
+
+	session, err := authService.Login(ctx, user.Username, "password123", "")
+	require.NoError(t, err)
+
+	// Unknown addresses get no message and no error
+	require.NoError(t, authService.RequestPasswordReset(ctx, "nobody@example.com"))
+	assert.Empty(t, readOutbox(t, outbox))
+
+	require.NoError(t, authService.RequestPasswordReset(ctx, user.Username))
+	messages := readOutbox(t, outbox)
+	require.Len(t, messages, 1)
+	assert.Equal(t, user.Username, messages[0].To)
+	require.Contains(t, messages[0].Body, "https://chat.example.com/reset-password?token=")
+	token := resetToken(messages[0])
+
+	// A rejected password leaves the token usable
+	err = authService.ResetPassword(ctx, token, "short")
+	assert.ErrorIs(t, err, service.ErrWeakPassword)
+
+	limiter.locked[user.Username] = time.Now().Add(time.Hour)
+	require.NoError(t, authService.ResetPassword(ctx, token, "new-password1"))
+	assert.NotContains(t, limiter.locked, user.Username, "a reset lifts a lockout")
+
+	// The token is single-use and every session is signed out
+	err = authService.ResetPassword(ctx, token, "another-password1")
+	assert.ErrorIs(t, err, service.ErrInvalidResetToken)
+	_, err = authService.Refresh(ctx, session.RefreshToken)
+	assert.Error(t, err)
+	_, err = authService.Login(ctx, user.Username, "new-password1", "")
+	assert.NoError(t, err)
+
+	t.Run("expired token", func(t *testing.T) {
+		require.NoError(t, authService.RequestPasswordReset(ctx, user.Username))
+		for _, reset := range tokenRepo.resets {
+			reset.ExpiresAt = time.Now().Add(-time.Minute)
+		}
+		messages := readOutbox(t, outbox)
+		err := authService.ResetPassword(ctx, resetToken(messages[len(messages)-1]), "new-password2")
+		assert.ErrorIs(t, err, service.ErrInvalidResetToken)
+	})
+}
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	policy := service.PasswordPolicy{MinLength: 10, RequireDigit: true, RequireSymbol: true}
+
+	assert.ErrorIs(t, policy.Validate("a1!"), service.ErrWeakPassword)
+	assert.ErrorIs(t, policy.Validate("abcdefghij!"), service.ErrWeakPassword)
+	assert.ErrorIs(t, policy.Validate("abcdefghij1"), service.ErrWeakPassword)
+	assert.ErrorIs(t, policy.Validate(strings.Repeat("a1!", 25)), service.ErrWeakPassword, "longer than bcrypt accepts")
+	assert.NoError(t, policy.Validate("abcdefgh1!"))
+	assert.NoError(t, service.DefaultPasswordPolicy.Validate("password123"))
+}
+
+func TestAuthService_TOTP(t *testing.T) {
+	ctx := context.Background()
+	user, mockRepo := userWithPassword(t, "password123")
+	authService := service.NewAuthService(mockRepo, newMemoryTokenRepository(), &memoryDenylist{revoked: make(map[string]time.Time)})
+	authService.SetMFARepository(newMemoryMFARepository())
+	codeAt := func(secret string, offset int64) string {
+		code, err := totp.Code(secret, totp.Step(time.Now())+offset)
+		require.NoError(t, err)
+		return code
+	}
+
+	enrollment, err := authService.EnrollTOTP(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+
+	// Until confirmed, logins need no second factor
+	_, err = authService.Login(ctx, user.Username, "password123", "")
+	require.NoError(t, err)
+
+	// A code from outside the allowed clock drift is refused
+	_, err = authService.ConfirmTOTP(ctx, user.ID, codeAt(enrollment.Secret, 5))
+	assert.ErrorIs(t, err, service.ErrInvalidMFACode)
+	recoveryCodes, err := authService.ConfirmTOTP(ctx, user.ID, codeAt(enrollment.Secret, 0))
+	require.NoError(t, err)
+	assert.Len(t, recoveryCodes, 10)
+	_, err = authService.EnrollTOTP(ctx, user.ID)
+	assert.ErrorIs(t, err, service.ErrMFAAlreadyEnabled)
+
+	challenge := func() string {
+		tokens, err := authService.Login(ctx, user.Username, "password123", "")
+		assert.Nil(t, tokens)
+		var mfa *service.MFARequiredError
+		require.ErrorAs(t, err, &mfa)
+		return mfa.Token
+	}
+
+	t.Run("login needs a fresh code", func(t *testing.T) {
+		mfaToken := challenge()
+		_, err := middleware.ValidateToken(mfaToken)
+		assert.Error(t, err, "the challenge must not work as an access token")
+
+		// The code used to confirm cannot be replayed
+		_, err = authService.VerifyMFA(ctx, mfaToken, codeAt(enrollment.Secret, 0), "")
+		assert.ErrorIs(t, err, service.ErrInvalidMFACode)
+
+		tokens, err := authService.VerifyMFA(ctx, mfaToken, codeAt(enrollment.Secret, 1), "")
+		require.NoError(t, err)
+		assert.NotEmpty(t, tokens.AccessToken)
+
+		// The challenge is single-use
+		_, err = authService.VerifyMFA(ctx, mfaToken, recoveryCodes[0], "")
+		assert.ErrorIs(t, err, service.ErrInvalidMFAToken)
+	})
+
+	t.Run("recovery codes work once", func(t *testing.T) {
+		_, err := authService.VerifyMFA(ctx, challenge(), strings.ToUpper(recoveryCodes[0]), "")
+		require.NoError(t, err)
+		_, err = authService.VerifyMFA(ctx, challenge(), recoveryCodes[0], "")
+		assert.ErrorIs(t, err, service.ErrInvalidMFACode)
+	})
+
+	t.Run("disable needs a code", func(t *testing.T) {
+		err := authService.DisableTOTP(ctx, user.ID, "not-a-code", "")
+		assert.ErrorIs(t, err, service.ErrInvalidMFACode)
+		require.NoError(t, authService.DisableTOTP(ctx, user.ID, recoveryCodes[1], ""))
+
+		tokens, err := authService.Login(ctx, user.Username, "password123", "")
+		require.NoError(t, err)
+		assert.NotEmpty(t, tokens.AccessToken)
+		assert.ErrorIs(t, authService.DisableTOTP(ctx, user.ID, recoveryCodes[2], ""), service.ErrMFANotEnabled)
+	})
+}
@@ -0,0 +1,255 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"rtcs/internal/middleware"
+	"rtcs/internal/model"
+	"rtcs/internal/oidc"
+	"rtcs/internal/oidc/oidctest"
+	"rtcs/internal/service"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryUserDirectory serves users by ID and username from memory; the
+// remaining UserRepository methods are the mock's
+type memoryUserDirectory struct {
+	*MockUserRepository
+	users map[uuid.UUID]*model.User
+}
+
+func newMemoryUserDirectory(users ...*model.User) *memoryUserDirectory {
+	d := &memoryUserDirectory{MockUserRepository: &MockUserRepository{}, users: make(map[uuid.UUID]*model.User)}
+	for _, user := range users {
+		d.users[user.ID] = user
+	}
+	return d
+}
+
+func (d *memoryUserDirectory) GetByID(ctx context.Context, id uuid.UUID) (*model.User, error) {
+	return d.users[id], nil
+}
+
+func (d *memoryUserDirectory) GetByUsername(ctx context.Context, username string) (*model.User, error) {
+	for _, user := range d.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+// memoryIdentityRepository links provider accounts to the users of a
+// memoryUserDirectory
+type memoryIdentityRepository struct {
+	users      *memoryUserDirectory
+	identities []*model.UserIdentity
+}
+
+func (m *memoryIdentityRepository) GetIdentity(ctx context.Context, provider, subject string) (*model.UserIdentity, error) {
+	for _, identity := range m.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryIdentityRepository) LinkIdentity(ctx context.Context, identity *model.UserIdentity) (bool, error) {
+	if existing, _ := m.GetIdentity(ctx, identity.Provider, identity.Subject); existing != nil {
+		return false, nil
+	}
+	m.identities = append(m.identities, identity)
+	return true, nil
+}
+
+func (m *memoryIdentityRepository) CreateUserWithIdentity(ctx context.Context, user *model.User, identity *model.UserIdentity) (bool, error) {
+	if existing, _ := m.users.GetByUsername(ctx, user.Username); existing != nil {
+		return false, nil
+	}
+	identity.UserID = user.ID
+	if linked, _ := m.LinkIdentity(ctx, identity); !linked {
+		return false, nil
+	}
+	m.users.users[user.ID] = user
+	return true, nil
+}
+
+// memoryOIDCStates keeps logins in flight in memory
+type memoryOIDCStates struct {
+	requests map[string]*oidc.AuthRequest
+}
+
+func (m *memoryOIDCStates) Save(ctx context.Context, req *oidc.AuthRequest, ttl time.Duration) error {
+	m.requests[req.State] = req
+	return nil
+}
+
+func (m *memoryOIDCStates) Take(ctx context.Context, state string) (*oidc.AuthRequest, error) {
+	req := m.requests[state]
+	delete(m.requests, state)
+	return req, nil
+}
+
+type oidcFixture struct {
+	idp        *oidctest.Server
+	auth       *service.AuthService
+	users      *memoryUserDirectory
+	identities *memoryIdentityRepository
+	mfa        *memoryMFARepository
+}
+
+func newOIDCFixture(t *testing.T, users ...*model.User) *oidcFixture {
+	idp := oidctest.NewServer()
+	t.Cleanup(idp.Close)
+
+	f := &oidcFixture{idp: idp, users: newMemoryUserDirectory(users...), mfa: newMemoryMFARepository()}
+	f.identities = &memoryIdentityRepository{users: f.users}
+	f.auth = service.NewAuthService(f.users, newMemoryTokenRepository(), nil)
+	f.auth.SetMFARepository(f.mfa)
+	f.auth.SetOIDC(f.identities, &memoryOIDCStates{requests: make(map[string]*oidc.AuthRequest)})
+	f.auth.AddOIDCProvider(oidc.NewProvider(idp.Config("corp", "https://chat.example.com/auth/oidc/corp/callback"), nil))
+	return f
+}
+
+// start begins a login and has the user log in at the provider, returning
+// the state and code the callback receives
+func (f *oidcFixture) start(t *testing.T) (string, string) {
+	t.Helper()
+	authURL, state, err := f.auth.StartOIDCLogin(context.Background(), "corp")
+	require.NoError(t, err)
+	callback, err := f.idp.Authorize(authURL)
+	require.NoError(t, err)
+	require.Equal(t, state, callback.Query().Get("state"))
+	return state, callback.Query().Get("code")
+}
+
+func (f *oidcFixture) login(t *testing.T) (*service.TokenPair, error) {
+	t.Helper()
+	state, code := f.start(t)
+	return f.auth.FinishOIDCLogin(context.Background(), "corp", state, code)
+}
+
+// sessionUser returns the user the access token was issued to
+func sessionUser(t *testing.T, tokens *service.TokenPair) string {
+	t.Helper()
+	require.NotNil(t, tokens)
+	claims, err := middleware.ValidateToken(tokens.AccessToken)
+	require.NoError(t, err)
+	return claims.UserID
+}
+
+func TestOIDCLogin_CreatesAndReusesAccount(t *testing.T) {
+	f := newOIDCFixture(t)
+	f.idp.SetUser(oidctest.User{Subject: "employee-17", Email: "alice@corp.example.com", EmailVerified: true, Name: "  Alice Example  "})
+
+	tokens, err := f.login(t)
+	require.NoError(t, err)
+	require.Len(t, f.users.users, 1)
+	var user *model.User
+	for _, u := range f.users.users {
+		user = u
+	}
+	assert.Equal(t, "alice@corp.example.com", user.Username)
+	assert.True(t, user.EmailVerified)
+	assert.Equal(t, "Alice Example", user.DisplayName)
+	assert.Empty(t, user.Password, "accounts from a provider have no password")
+	assert.Equal(t, user.ID.String(), sessionUser(t, tokens))
+	require.Len(t, f.identities.identities, 1)
+	assert.Equal(t, "corp", f.identities.identities[0].Provider)
+	assert.Equal(t, "employee-17", f.identities.identities[0].Subject)
+
+	// The provider account keeps logging in the same user, even after its
+	// address changes there
+	f.idp.SetUser(oidctest.User{Subject: "employee-17", Email: "alice.example@corp.example.com", EmailVerified: true})
+	tokens, err = f.login(t)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID.String(), sessionUser(t, tokens))
+	assert.Len(t, f.users.users, 1)
+	assert.Len(t, f.identities.identities, 1)
+}
+
+func TestOIDCLogin_LinksVerifiedAccount(t *testing.T) {
+	local := &model.User{ID: uuid.New(), Username: "bob@corp.example.com", Password: hashPassword(t, "password123"), EmailVerified: true}
+	f := newOIDCFixture(t, local)
+	f.idp.SetUser(oidctest.User{Subject: "employee-42", Email: "bob@corp.example.com", EmailVerified: true})
+
+	tokens, err := f.login(t)
+	require.NoError(t, err)
+	assert.Equal(t, local.ID.String(), sessionUser(t, tokens))
+	assert.Len(t, f.users.users, 1, "no account is created")
+	require.Len(t, f.identities.identities, 1)
+	assert.Equal(t, local.ID, f.identities.identities[0].UserID)
+}
+
+func TestOIDCLogin_RefusesUnverifiedAccount(t *testing.T) {
+	// Someone registered the address without owning it
+	local := &model.User{ID: uuid.New(), Username: "carol@corp.example.com", Password: hashPassword(t, "password123")}
+	f := newOIDCFixture(t, local)
+	f.idp.SetUser(oidctest.User{Subject: "employee-7", Email: "carol@corp.example.com", EmailVerified: true})
+
+	_, err := f.login(t)
+	assert.ErrorIs(t, err, service.ErrAccountNotLinkable)
+	assert.Empty(t, f.identities.identities)
+}
+
+func TestOIDCLogin_RefusesUnverifiedProviderEmail(t *testing.T) {
+	local := &model.User{ID: uuid.New(), Username: "dave@corp.example.com", EmailVerified: true}
+	f := newOIDCFixture(t, local)
+	f.idp.SetUser(oidctest.User{Subject: "employee-9", Email: "dave@corp.example.com", EmailVerified: false})
+
+	_, err := f.login(t)
+	assert.ErrorIs(t, err, service.ErrProviderEmailNotVerified)
+	assert.Empty(t, f.identities.identities)
+	assert.Len(t, f.users.users, 1)
+}
+
+func TestOIDCLogin_State(t *testing.T) {
+	ctx := context.Background()
+	f := newOIDCFixture(t)
+	f.idp.SetUser(oidctest.User{Subject: "employee-3", Email: "erin@corp.example.com", EmailVerified: true})
+
+	_, _, err := f.auth.StartOIDCLogin(ctx, "other")
+	assert.ErrorIs(t, err, service.ErrUnknownProvider)
+
+	state, code := f.start(t)
+	_, err = f.auth.FinishOIDCLogin(ctx, "corp", "forged-state", code)
+	assert.ErrorIs(t, err, service.ErrInvalidOIDCState)
+
+	_, err = f.auth.FinishOIDCLogin(ctx, "corp", state, code)
+	require.NoError(t, err)
+	_, err = f.auth.FinishOIDCLogin(ctx, "corp", state, code)
+	assert.ErrorIs(t, err, service.ErrInvalidOIDCState, "states work once")
+}
+
+func TestOIDCLogin_RefusesInvalidIDToken(t *testing.T) {
+	f := newOIDCFixture(t)
+	f.idp.SetUser(oidctest.User{Subject: "employee-5", Email: "frank@corp.example.com", EmailVerified: true})
+	f.idp.IDTokenClaims = func(claims jwt.MapClaims) { claims["nonce"] = "from-another-login" }
+
+	_, err := f.login(t)
+	assert.ErrorIs(t, err, service.ErrOIDCLoginFailed)
+	assert.Empty(t, f.users.users)
+}
+
+func TestOIDCLogin_RequiresSecondFactor(t *testing.T) {
+	now := time.Now()
+	local := &model.User{ID: uuid.New(), Username: "grace@corp.example.com", EmailVerified: true}
+	f := newOIDCFixture(t, local)
+	f.mfa.totps[local.ID] = &model.UserTOTP{UserID: local.ID, Secret: "JBSWY3DPEHPK3PXP", ConfirmedAt: &now}
+	f.idp.SetUser(oidctest.User{Subject: "employee-11", Email: "grace@corp.example.com", EmailVerified: true})
+
+	tokens, err := f.login(t)
+	assert.Nil(t, tokens)
+	var mfa *service.MFARequiredError
+	require.ErrorAs(t, err, &mfa)
+	claims, err := middleware.ValidateMFAToken(mfa.Token)
+	require.NoError(t, err)
+	assert.Equal(t, local.ID.String(), claims.UserID)
+}
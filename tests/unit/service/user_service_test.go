@@ -0,0 +1,128 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"rtcs/internal/model"
+	"rtcs/internal/service"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestUserService_UpdateProfile(t *testing.T) {
+	ctx := context.Background()
+	user := &model.User{ID: uuid.New(), Username: "test@example.com", Bio: "unchanged"}
+	mockRepo := &MockUserRepository{}
+	mockRepo.On("GetByID", mock.Anything, user.ID).Return(user, nil)
+	mockRepo.On("UpdateProfile", mock.Anything, mock.AnythingOfType("*model.User")).Return(nil)
+	userService := service.NewUserService(mockRepo)
+
+	updated, err := userService.UpdateProfile(ctx, user.ID, service.ProfileUpdate{
+		DisplayName: stringPtr("  Test User "),
+		Timezone:    stringPtr("Europe/Berlin"),
+		StatusText:  stringPtr("In a meeting"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Test User", updated.DisplayName)
+	assert.Equal(t, "Europe/Berlin", updated.Timezone)
+	assert.Equal(t, "unchanged", updated.Bio, "fields left out are kept")
+
+	tests := map[string]service.ProfileUpdate{
+		"unknown timezone":      {Timezone: stringPtr("Mars/Olympus_Mons")},
+		"display name too long": {DisplayName: stringPtr(strings.Repeat("a", 65))},
+		"status too long":       {StatusText: stringPtr(strings.Repeat("é", 141))},
+	}
+	for name, update := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := userService.UpdateProfile(ctx, user.ID, update)
+			assert.ErrorIs(t, err, service.ErrInvalidProfile)
+		})
+	}
+}
+
+func TestUserService_GetUser(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := &MockUserRepository{}
+	missing := uuid.New()
+	mockRepo.On("GetByID", mock.Anything, missing).Return(nil, nil)
+	userService := service.NewUserService(mockRepo)
+
+	_, err := userService.GetUser(ctx, missing)
+	assert.ErrorIs(t, err, service.ErrUserNotFound)
+}
+
+func TestUserService_SearchUsers(t *testing.T) {
+	ctx := context.Background()
+	alice := &model.User{ID: uuid.New(), Username: "alice@example.com", DisplayName: "Alice", Bio: "private"}
+	mockRepo := &MockUserRepository{}
+	mockRepo.On("Search", mock.Anything, "ali", service.MaxUserSearchLimit).Return([]*model.User{alice}, nil)
+	userService := service.NewUserService(mockRepo)
+
+	profiles, err := userService.SearchUsers(ctx, " ali ", 1000)
+	require.NoError(t, err)
+	require.Len(t, profiles, 1)
+	assert.Equal(t, &model.UserProfile{ID: alice.ID, Username: alice.Username, DisplayName: "Alice"}, profiles[0])
+
+	// An empty query does not list the whole directory
+	profiles, err = userService.SearchUsers(ctx, "", 0)
+	require.NoError(t, err)
+	assert.Empty(t, profiles)
+	mockRepo.AssertExpectations(t)
+}
+
+// recordingBlockListener keeps the block changes it is told about
+type recordingBlockListener struct {
+	blocked, unblocked []uuid.UUID
+}
+
+func (l *recordingBlockListener) UserBlocked(blockerID, blockedID uuid.UUID) {
+	l.blocked = append(l.blocked, blockedID)
+}
+
+func (l *recordingBlockListener) UserUnblocked(blockerID, blockedID uuid.UUID) {
+	l.unblocked = append(l.unblocked, blockedID)
+}
+
+func TestUserService_BlockUser(t *testing.T) {
+	ctx := context.Background()
+	me, other := uuid.New(), &model.User{ID: uuid.New(), Username: "other@example.com"}
+	mockRepo := &MockUserRepository{}
+	mockRepo.On("GetByID", mock.Anything, other.ID).Return(other, nil)
+	mockRepo.On("Block", mock.Anything, mock.AnythingOfType("*model.UserBlock")).Return(true, nil).Once()
+	mockRepo.On("Block", mock.Anything, mock.AnythingOfType("*model.UserBlock")).Return(false, nil).Once()
+	listener := &recordingBlockListener{}
+	userService := service.NewUserService(mockRepo)
+	userService.SetBlockListener(listener)
+
+	assert.ErrorIs(t, userService.BlockUser(ctx, me, me), service.ErrBlockSelf)
+
+	require.NoError(t, userService.BlockUser(ctx, me, other.ID))
+	// Blocking again succeeds without announcing the block twice
+	require.NoError(t, userService.BlockUser(ctx, me, other.ID))
+	assert.Equal(t, []uuid.UUID{other.ID}, listener.blocked)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUserService_UnblockUser(t *testing.T) {
+	ctx := context.Background()
+	me, other := uuid.New(), uuid.New()
+	mockRepo := &MockUserRepository{}
+	mockRepo.On("Unblock", mock.Anything, me, other).Return(true, nil).Once()
+	mockRepo.On("Unblock", mock.Anything, me, other).Return(false, nil).Once()
+	listener := &recordingBlockListener{}
+	userService := service.NewUserService(mockRepo)
+	userService.SetBlockListener(listener)
+
+	require.NoError(t, userService.UnblockUser(ctx, me, other))
+	assert.ErrorIs(t, userService.UnblockUser(ctx, me, other), service.ErrNotBlocked)
+	assert.Equal(t, []uuid.UUID{other}, listener.unblocked)
+}
@@ -0,0 +1,174 @@
+package service_test
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rtcs/internal/middleware"
+	"rtcs/internal/model"
+	"rtcs/internal/notify"
+	"rtcs/internal/service"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateEmail(t *testing.T) {
+	valid := []string{
+		"alice@example.com",
+		"alice.smith+chat@mail.example.co.uk",
+		"o'brien@example.org",
+	}
+	invalid := []string{
+		"",
+		"invalid-email",
+		"alice@",
+		"@example.com",
+		"alice@localhost",
+		"alice@example.com.",
+		"alice@@example.com",
+		"Alice <alice@example.com>",
+		"<alice@example.com>",
+		"alice@example.com (work)",
+		" alice@example.com",
+		"alice@example.com\r\nBcc: mallory@example.com",
+		strings.Repeat("a", 250) + "@example.com",
+	}
+	for _, address := range valid {
+		assert.NoError(t, service.ValidateEmail(address), address)
+	}
+	for _, address := range invalid {
+		assert.ErrorIs(t, service.ValidateEmail(address), service.ErrInvalidEmail, address)
+	}
+}
+
+// verificationToken returns the token from the link in a verification email
+func verificationToken(t *testing.T, msg notify.Message) string {
+	_, rest, found := strings.Cut(msg.Body, "/auth/verify-email?token=")
+	require.True(t, found, "no verification link in %q", msg.Body)
+	token, _, _ := strings.Cut(rest, " ")
+	token, err := url.QueryUnescape(token)
+	require.NoError(t, err)
+	return token
+}
+
+func TestAuthService_EmailVerification(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := &MockUserRepository{}
+	var user *model.User
+	mockRepo.On("GetByUsername", mock.Anything, "new@example.com").Return(nil, nil).Once()
+	mockRepo.On("Create", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { user = args.Get(1).(*model.User) }).
+		Return(nil)
+	outbox := filepath.Join(t.TempDir(), "outbox.jsonl")
+	authService := service.NewAuthService(mockRepo, newMemoryTokenRepository(), nil)
+	authService.SetNotifier(notify.NewFileOutbox(outbox))
+	authService.SetPublicURL("https://chat.example.com")
+
+	_, err := authService.Register(ctx, "Not An Address", "password123")
+	assert.ErrorIs(t, err, service.ErrInvalidEmail)
+
+	_, err = authService.Register(ctx, "new@example.com", "password123")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.False(t, user.EmailVerified)
+	messages := readOutbox(t, outbox)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "new@example.com", messages[0].To)
+	assert.Contains(t, messages[0].Body, "https://chat.example.com/auth/verify-email?token=")
+	token := verificationToken(t, messages[0])
+
+	mockRepo.On("GetByID", mock.Anything, user.ID).Return(user, nil)
+	mockRepo.On("MarkEmailVerified", mock.Anything, user.ID).
+		Run(func(args mock.Arguments) { user.EmailVerified = true }).
+		Return(true, nil).Once()
+
+	// Other purposes and altered tokens are refused
+	mfaToken, err := middleware.GenerateMFAToken(user.ID.String())
+	require.NoError(t, err)
+	assert.ErrorIs(t, authService.VerifyEmail(ctx, mfaToken), service.ErrInvalidVerificationToken)
+	assert.ErrorIs(t, authService.VerifyEmail(ctx, token+"x"), service.ErrInvalidVerificationToken)
+	otherAddress, err := middleware.GenerateEmailVerificationToken(user.ID.String(), "old@example.com")
+	require.NoError(t, err)
+	assert.ErrorIs(t, authService.VerifyEmail(ctx, otherAddress), service.ErrInvalidVerificationToken)
+
+	require.NoError(t, authService.VerifyEmail(ctx, token))
+	assert.True(t, user.EmailVerified)
+	// Opening the link again changes nothing
+	require.NoError(t, authService.VerifyEmail(ctx, token))
+	mockRepo.AssertNumberOfCalls(t, "MarkEmailVerified", 1)
+
+	// Verified and unknown addresses are not sent another link
+	mockRepo.On("GetByUsername", mock.Anything, "new@example.com").Return(user, nil)
+	mockRepo.On("GetByUsername", mock.Anything, "nobody@example.com").Return(nil, nil)
+	require.NoError(t, authService.RequestEmailVerification(ctx, "new@example.com"))
+	require.NoError(t, authService.RequestEmailVerification(ctx, "nobody@example.com"))
+	assert.Len(t, readOutbox(t, outbox), 1)
+}
+
+func TestAuthService_ResendEmailVerification(t *testing.T) {
+	ctx := context.Background()
+	user, mockRepo := userWithPassword(t, "password123")
+	outbox := filepath.Join(t.TempDir(), "outbox.jsonl")
+	authService := service.NewAuthService(mockRepo, newMemoryTokenRepository(), nil)
+	authService.SetNotifier(notify.NewFileOutbox(outbox))
+
+	require.NoError(t, authService.RequestEmailVerification(ctx, user.Username))
+	messages := readOutbox(t, outbox)
+	require.Len(t, messages, 1)
+	assert.Equal(t, user.Username, messages[0].To)
+	verificationToken(t, messages[0])
+}
+
+func TestAuthService_LoginRestrictedUntilVerified(t *testing.T) {
+	ctx := context.Background()
+	user, mockRepo := userWithPassword(t, "password123")
+	authService := service.NewAuthService(mockRepo, newMemoryTokenRepository(), nil)
+
+	// Without the restriction unverified users log in as before
+	_, err := authService.Login(ctx, user.Username, "password123", "")
+	require.NoError(t, err)
+
+	authService.SetUnverifiedPolicy(service.UnverifiedPolicy{service.RestrictLogin})
+	_, err = authService.Login(ctx, user.Username, "password123", "")
+	assert.ErrorIs(t, err, service.ErrEmailNotVerified)
+	// A wrong password still says nothing about the address
+	_, err = authService.Login(ctx, user.Username, "wrong-password", "")
+	assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+
+	user.EmailVerified = true
+	_, err = authService.Login(ctx, user.Username, "password123", "")
+	assert.NoError(t, err)
+}
+
+func TestParseUnverifiedPolicy(t *testing.T) {
+	policy, err := service.ParseUnverifiedPolicy(" send_messages, join_chats ,")
+	require.NoError(t, err)
+	assert.True(t, policy.Restricts(service.RestrictSendMessages))
+	assert.True(t, policy.Restricts(service.RestrictJoinChats))
+	assert.False(t, policy.Restricts(service.RestrictLogin))
+
+	policy, err = service.ParseUnverifiedPolicy("")
+	require.NoError(t, err)
+	assert.Empty(t, policy)
+
+	_, err = service.ParseUnverifiedPolicy("login,post")
+	assert.Error(t, err)
+}
+
+func TestUserService_RequireVerified(t *testing.T) {
+	ctx := context.Background()
+	user, mockRepo := userWithPassword(t, "password123")
+	userService := service.NewUserService(mockRepo)
+	userService.SetUnverifiedPolicy(service.UnverifiedPolicy{service.RestrictSendMessages})
+
+	assert.NoError(t, userService.RequireVerified(ctx, user.ID, service.RestrictCreateChats))
+	assert.ErrorIs(t, userService.RequireVerified(ctx, user.ID, service.RestrictSendMessages), service.ErrEmailNotVerified)
+
+	user.EmailVerified = true
+	assert.NoError(t, userService.RequireVerified(ctx, user.ID, service.RestrictSendMessages))
+}